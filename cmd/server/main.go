@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -15,6 +16,9 @@ import (
 	"github.com/socialgouv/xfuncjs-server/pkg/http"
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
 	"github.com/socialgouv/xfuncjs-server/pkg/node"
+	"github.com/socialgouv/xfuncjs-server/pkg/readiness"
+	"github.com/socialgouv/xfuncjs-server/pkg/signature"
+	"github.com/socialgouv/xfuncjs-server/pkg/source"
 )
 
 func main() {
@@ -36,11 +40,33 @@ func main() {
 	logCrossplaneIO := flag.Bool("log-crossplane-io", cfg.LogCrossplaneIO, "Log full Crossplane RunFunction request/response at DEBUG (redacted)")
 	healthCheckWait := flag.Duration("health-check-wait", cfg.HealthCheckWait, "Timeout for health check")
 	healthCheckInterval := flag.Duration("health-check-interval", cfg.HealthCheckInterval, "Interval for health check polling")
+	responseTTL := flag.Duration("response-ttl", cfg.ResponseTTL, "TTL of the function responses, after which Crossplane calls the function again")
+	responseTTLMin := flag.Duration("response-ttl-min", cfg.ResponseTTLMin, "Minimum TTL a function can request")
+	responseTTLMax := flag.Duration("response-ttl-max", cfg.ResponseTTLMax, "Maximum TTL a function can request")
+	failureLogLines := flag.Int("failure-log-lines", cfg.FailureLogLines, "Number of function output lines attached to failed executions (0 disables)")
+	failureLogMode := flag.String("failure-log-mode", cfg.FailureLogMode, "Where to attach function output on failure (message, warning)")
+	nodeLogLevel := flag.String("node-log-level", cfg.NodeLogLevel, "Log level of the Node.js processes (trace, debug, info, warn, error, fatal, silent)")
 	requestTimeout := flag.Duration("request-timeout", cfg.NodeRequestTimeout, "Timeout for requests")
 	tlsEnabled := flag.Bool("tls-enabled", cfg.TLSEnabled, "Enable TLS")
 	tlsCertFile := flag.String("tls-cert-file", cfg.TLSCertFile, "Path to TLS certificate file")
 	tlsKeyFile := flag.String("tls-key-file", cfg.TLSKeyFile, "Path to TLS key file")
 	insecure := flag.Bool("insecure", false, "Disable TLS (insecure mode for testing)")
+	workspaceManifest := flag.String("workspace-manifest", cfg.WorkspaceManifest, "Manifest of the workspace packages bundled in the image, yarn is used when it does not exist")
+	yarnDirStrategy := flag.String("yarn-dir-strategy", cfg.YarnDirStrategy, "How the shared parts of /app/.yarn are provided to each workspace (reference, hardlink, copy)")
+	installTimeout := flag.Duration("install-timeout", cfg.InstallTimeout, "Timeout of a single dependency install, not counting the time spent in the queue")
+	npmRegistryServer := flag.String("npm-registry-server", cfg.NpmRegistryServer, "npm registry URL used by yarn installs")
+	yarnCacheFolder := flag.String("yarn-cache-folder", cfg.YarnCacheFolder, "Offline mirror or cache of package archives used by yarn installs")
+	yarnStrictInstall := flag.Bool("yarn-strict-install", cfg.YarnStrictInstall, "Fail functions whose dependency installation fails instead of starting them anyway")
+	lockfileStrict := flag.Bool("lockfile-strict", cfg.LockfileStrict, "Fail functions whose yarnLock does not match the declared dependencies instead of warning")
+	dependencyPolicyFile := flag.String("dependency-policy-file", cfg.DependencyPolicyFile, "Path to a YAML or JSON dependency policy file")
+	readinessRulesFile := flag.String("readiness-rules-file", cfg.ReadinessRulesFile, "Path to a YAML or JSON file of per-kind readiness rules, added to the default rules")
+	remoteSourceRegistryMirror := flag.String("remote-source-registry-mirror", cfg.RemoteSourceRegistryMirror, "Registry replacing the registry of every OCI source")
+	remoteSourceProxy := flag.String("remote-source-proxy", cfg.RemoteSourceProxy, "HTTP(S) proxy URL used to fetch remote sources")
+	signatureKeysDir := flag.String("signature-keys-dir", cfg.SignatureKeysDir, "Directory of the PEM public keys trusted to sign function sources")
+	requireSignature := flag.Bool("require-signature", cfg.RequireSignature, "Reject unsigned function sources and sources whose signature is invalid")
+	yarnOfflineOnly := flag.Bool("yarn-offline-only", cfg.YarnOfflineOnly, "Disable network access during yarn installs")
+	adminEnabled := flag.Bool("admin-enabled", cfg.AdminEnabled, "Enable the process administration HTTP API (token from XFUNCJS_ADMIN_TOKEN)")
+	adminRollStagger := flag.Duration("admin-roll-stagger", cfg.AdminRollStagger, "Default delay between process restarts when rolling all processes")
 	flag.Parse()
 
 	// Override config with command line flags (highest priority)
@@ -56,7 +82,29 @@ func main() {
 	cfg.HealthCheckWait = *healthCheckWait
 	cfg.HealthCheckInterval = *healthCheckInterval
 	cfg.NodeRequestTimeout = *requestTimeout
-	
+	cfg.NodeLogLevel = *nodeLogLevel
+	cfg.ResponseTTL = *responseTTL
+	cfg.ResponseTTLMin = *responseTTLMin
+	cfg.ResponseTTLMax = *responseTTLMax
+	cfg.FailureLogLines = *failureLogLines
+	cfg.FailureLogMode = *failureLogMode
+	cfg.InstallTimeout = *installTimeout
+	cfg.YarnDirStrategy = *yarnDirStrategy
+	cfg.WorkspaceManifest = *workspaceManifest
+	cfg.NpmRegistryServer = *npmRegistryServer
+	cfg.YarnCacheFolder = *yarnCacheFolder
+	cfg.YarnOfflineOnly = *yarnOfflineOnly
+	cfg.DependencyPolicyFile = *dependencyPolicyFile
+	cfg.ReadinessRulesFile = *readinessRulesFile
+	cfg.RemoteSourceRegistryMirror = *remoteSourceRegistryMirror
+	cfg.RemoteSourceProxy = *remoteSourceProxy
+	cfg.SignatureKeysDir = *signatureKeysDir
+	cfg.RequireSignature = *requireSignature
+	cfg.YarnStrictInstall = *yarnStrictInstall
+	cfg.LockfileStrict = *lockfileStrict
+	cfg.AdminEnabled = *adminEnabled
+	cfg.AdminRollStagger = *adminRollStagger
+
 	// Handle --insecure flag (overrides all TLS settings)
 	if *insecure {
 		cfg.TLSEnabled = false
@@ -91,6 +139,17 @@ func main() {
 		log.WithFields(pkgerrors.GetFields(err)).Fatal("Invalid configuration")
 	}
 
+	// Load the dependency policy, if any
+	var dependencyPolicy *node.DependencyPolicy
+	if cfg.DependencyPolicyFile != "" {
+		dependencyPolicy, err = node.LoadDependencyPolicy(cfg.DependencyPolicyFile)
+		if err != nil {
+			err = pkgerrors.WrapWithCode(err, pkgerrors.ErrorCodeInvalidInput, "failed to load dependency policy")
+			log.WithFields(pkgerrors.GetFields(err)).Fatal("Invalid dependency policy")
+		}
+		log.WithField("file", cfg.DependencyPolicyFile).Info("Loaded dependency policy")
+	}
+
 	// Create process manager with all configuration options
 	processManager, err := node.NewProcessManager(
 		cfg.GCInterval,
@@ -100,7 +159,20 @@ func main() {
 		node.WithHealthCheckWait(cfg.HealthCheckWait),
 		node.WithHealthCheckInterval(cfg.HealthCheckInterval),
 		node.WithRequestTimeout(cfg.NodeRequestTimeout),
+		node.WithNodeLogLevel(cfg.NodeLogLevel),
 		node.WithYarnQueue(cfg.MaxConcurrentYarnInstalls),
+		node.WithInstallTimeout(cfg.InstallTimeout),
+		node.WithWorkspaceManifest(cfg.WorkspaceManifest),
+		node.WithYarnDirStrategy(cfg.YarnDirStrategy),
+		node.WithRegistryConfig(node.RegistryConfig{
+			Server:        cfg.NpmRegistryServer,
+			AuthTokenFile: cfg.NpmAuthTokenFile,
+			CacheFolder:   cfg.YarnCacheFolder,
+			OfflineOnly:   cfg.YarnOfflineOnly,
+		}),
+		node.WithDependencyPolicy(dependencyPolicy),
+		node.WithLockfileStrict(cfg.LockfileStrict),
+		node.WithStrictInstall(cfg.YarnStrictInstall),
 	)
 	if err != nil {
 		err = pkgerrors.WrapWithCode(err, pkgerrors.ErrorCodeInternalError, "failed to create process manager")
@@ -110,9 +182,53 @@ func main() {
 	// Create gRPC server
 	grpcServer := grpc.NewServer(processManager, log)
 	grpcServer.SetLogCrossplaneIO(cfg.LogCrossplaneIO)
+	grpcServer.SetFailureLogs(cfg.FailureLogLines, cfg.FailureLogMaxBytes, cfg.FailureLogMode)
+	grpcServer.SetResponseTTL(cfg.ResponseTTL, cfg.ResponseTTLMin, cfg.ResponseTTLMax)
+
+	// Load the readiness rules, if any, on top of the default ones
+	if cfg.ReadinessRulesFile != "" {
+		readinessRules, err := readiness.LoadRules(cfg.ReadinessRulesFile)
+		if err != nil {
+			err = pkgerrors.WrapWithCode(err, pkgerrors.ErrorCodeInvalidInput, "failed to load readiness rules")
+			log.WithFields(pkgerrors.GetFields(err)).Fatal("Invalid readiness rules")
+		}
+		grpcServer.SetReadinessRules(readinessRules)
+		log.WithField("file", cfg.ReadinessRulesFile).Info("Loaded readiness rules")
+	}
+
+	// Create the fetcher of remote function sources
+	if cfg.RemoteSourceCacheDir == "" {
+		cfg.RemoteSourceCacheDir = filepath.Join(cfg.TempDir, "remote-sources")
+	}
+	remoteSources, err := source.NewFetcher(source.Config{
+		CacheDir:        cfg.RemoteSourceCacheDir,
+		RegistryMirror:  cfg.RemoteSourceRegistryMirror,
+		Proxy:           cfg.RemoteSourceProxy,
+		CredentialsFile: cfg.RemoteSourceCredentialsFile,
+		MaxSize:         cfg.RemoteSourceMaxSize,
+	})
+	if err != nil {
+		err = pkgerrors.WrapWithCode(err, pkgerrors.ErrorCodeInternalError, "failed to create remote source fetcher")
+		log.WithFields(pkgerrors.GetFields(err)).Fatal("Failed to create remote source fetcher")
+	}
+	grpcServer.SetRemoteSources(remoteSources)
+
+	if cfg.SignatureKeysDir != "" {
+		if _, err := signature.LoadKeys(cfg.SignatureKeysDir); err != nil {
+			log.WithField(logger.FieldError, err.Error()).Fatal("Failed to load signature keys")
+		}
+		grpcServer.SetSignatureVerifier(signature.NewVerifier(cfg.SignatureKeysDir, cfg.RequireSignature))
+		log.WithFields(map[string]interface{}{
+			"dir":      cfg.SignatureKeysDir,
+			"required": cfg.RequireSignature,
+		}).Info("Source signature verification enabled")
+	}
 
 	// Create HTTP server for health checks
 	httpServer := http.NewServer(processManager, log)
+	if cfg.AdminEnabled {
+		httpServer.EnableAdmin(cfg.AdminToken, cfg.AdminRollStagger)
+	}
 
 	// Start gRPC server
 	go func() {
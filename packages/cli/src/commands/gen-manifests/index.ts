@@ -35,6 +35,7 @@ interface SourceSpec {
   dependencies?: Record<string, string>
   yarnLock?: string
   tsConfig?: string
+  encoding?: string
 }
 
 interface InputSpec {
@@ -366,6 +367,25 @@ async function bundleTypeScript(
   }
 }
 
+/**
+ * Encodes the inline code, yarn.lock and tsconfig.json of a source with gzip+base64.
+ * The server decodes them according to the encoding field.
+ */
+function encodeSource(source: SourceSpec): void {
+  if (source.encoding) {
+    return
+  }
+  for (const field of ["inline", "yarnLock", "tsConfig"] as const) {
+    const value = source[field]
+    if (value) {
+      const encoded = gzipSync(Buffer.from(value, "utf8")).toString("base64")
+      moduleLogger.debug(`Encoded ${field}: ${value.length} chars -> ${encoded.length} chars`)
+      source[field] = encoded
+    }
+  }
+  source.encoding = "gzip+base64"
+}
+
 /**
  * Main function for the gen-manifests command
  * Processes function directories and generates composition manifests
@@ -373,14 +393,23 @@ async function bundleTypeScript(
  * @returns Promise<void>
  */
 async function genManifestsAction(
-  options: { bundle?: boolean; bundleConfig?: string; embedDeps?: boolean } = {}
+  options: {
+    bundle?: boolean
+    bundleConfig?: string
+    embedDeps?: boolean
+    encodeSource?: boolean
+  } = {}
 ): Promise<void> {
   // Default to bundling enabled
   const shouldBundle = options.bundle !== false
   // Default to external dependencies (not embedded)
   const shouldEmbedDeps = options.embedDeps === true
+  // Default to readable sources, which older servers also accept
+  const shouldEncodeSource = options.encodeSource === true
 
-  moduleLogger.debug(`Bundle: ${shouldBundle}, Embed dependencies: ${shouldEmbedDeps}`)
+  moduleLogger.debug(
+    `Bundle: ${shouldBundle}, Embed dependencies: ${shouldEmbedDeps}, Encode source: ${shouldEncodeSource}`
+  )
 
   // Parse custom bundle config if provided
   let bundleConfig: Partial<BuildOptions> = {}
@@ -626,8 +655,11 @@ async function genManifestsAction(
           }
         }
 
-        // Add yarn.lock to the manifest if found
-        if (yarnLock) {
+        // Add yarn.lock to the manifest if found. Without --encode-source it is compressed
+        // on its own, without encoding field, as servers without encoding support expect.
+        if (yarnLock && shouldEncodeSource) {
+          xfuncjsStep.input.spec.source.yarnLock = yarnLock
+        } else if (yarnLock) {
           try {
             const gz = gzipSync(Buffer.from(yarnLock, "utf8"))
             const b64 = gz.toString("base64")
@@ -674,6 +706,11 @@ async function genManifestsAction(
         }
       }
 
+      // Compress the source fields, which make up most of the Composition size
+      if (shouldEncodeSource) {
+        encodeSource(xfuncjsStep.input.spec.source)
+      }
+
       // Create function-specific directory in manifests
       const functionManifestDir = path.join(manifestsDir, functionName)
       if (!fs.existsSync(functionManifestDir)) {
@@ -712,6 +749,10 @@ export default function (program: Command): void {
     .option("--no-bundle", "Disable TypeScript bundling")
     .option("--bundle-config <json>", "Custom esbuild configuration (JSON string)")
     .option("--embed-deps", "Embed dependencies in the bundle (default: false)")
+    .option(
+      "--encode-source",
+      "Encode the code, yarn.lock and tsconfig.json with gzip+base64 in spec.source.encoding (default: false)"
+    )
     .action(async options => {
       try {
         await genManifestsAction(options)
@@ -1,2 +1,2 @@
-export { default as logger, createLogger } from "./logger.ts"
+export { default as logger, createLogger, runWithRequestId, setLogLevel } from "./logger.ts"
 export { compareKubernetesVersions, getLatestKubernetesVersion } from "./version-utils.ts"
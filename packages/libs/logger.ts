@@ -1,8 +1,26 @@
+import { AsyncLocalStorage } from "node:async_hooks"
+
 import pino from "pino"
 
+// Request-scoped context, used to tag every log line emitted while a request
+// runs so the Go server can correlate the output with the request
+export const requestContext = new AsyncLocalStorage<{ requestId: string }>()
+
+// Run fn with the given request ID attached to every log line it produces
+export function runWithRequestId<T>(requestId: string | undefined, fn: () => T): T {
+  if (!requestId) {
+    return fn()
+  }
+  return requestContext.run({ requestId }, fn)
+}
+
 export const logger = pino({
   name: "xfuncjs",
   level: process.env.XFUNCJS_LOG_LEVEL || process.env.LOG_LEVEL || "info",
+  mixin() {
+    const store = requestContext.getStore()
+    return store ? { requestId: store.requestId } : {}
+  },
   formatters: {
     level: (label: string) => {
       return { level: label.toUpperCase() }
@@ -14,13 +32,32 @@ export const logger = pino({
   timestamp: false,
 })
 
+// Child loggers keep the level they were created with, so they are tracked
+// to let setLogLevel change the level of every logger at runtime
+const childLoggers = new Set<pino.Logger>()
+
 // Export a function to create child loggers
 export function createLogger(name: string) {
-  return logger.child({ name })
+  const child = logger.child({ name })
+  childLoggers.add(child)
+  return child
+}
+
+// Change the level of the root logger and every child logger
+export function setLogLevel(level: string) {
+  if (!Object.prototype.hasOwnProperty.call(logger.levels.values, level) && level !== "silent") {
+    throw new Error(`Invalid log level: ${level}`)
+  }
+  logger.level = level
+  for (const child of childLoggers) {
+    child.level = level
+  }
 }
 
-// Redirect console.log and console.error to use the logger
+// Redirect console methods to use the logger
 const originalConsoleLog = console.log
+const originalConsoleInfo = console.info
+const originalConsoleWarn = console.warn
 const originalConsoleError = console.error
 
 // Store original functions to allow restoring them if needed
@@ -32,6 +69,22 @@ console.log = function (...args) {
   }
 }
 
+console.info = function (...args) {
+  if (args.length === 1) {
+    logger.info(args[0])
+  } else {
+    logger.info({ context: args })
+  }
+}
+
+console.warn = function (...args) {
+  if (args.length === 1) {
+    logger.warn(args[0])
+  } else {
+    logger.warn({ context: args })
+  }
+}
+
 console.error = function (...args) {
   if (args.length === 1) {
     logger.error(args[0])
@@ -43,6 +96,8 @@ console.error = function (...args) {
 // Export original console functions for potential restoration
 export const restoreConsole = () => {
   console.log = originalConsoleLog
+  console.info = originalConsoleInfo
+  console.warn = originalConsoleWarn
   console.error = originalConsoleError
 }
 
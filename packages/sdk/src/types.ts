@@ -38,11 +38,30 @@ export interface ExtraResourceRequirement {
   namespace?: string
 }
 
+// Secret holding the connection details of a namespaced composite resource
+export interface ConnectionSecret {
+  // Defaults to <composite name>-connection
+  name?: string
+  // Stops generating the Secret
+  disabled?: boolean
+}
+
+// How a returned resource is combined with the desired resource of the same name
+// produced by earlier pipeline steps
+export interface MergeStrategy {
+  // Defaults to replace
+  strategy?: "replace" | "mergePatch" | "deepMerge"
+  // How deepMerge combines lists, defaults to replace
+  lists?: "replace" | "append" | "mergeByName"
+}
+
 // Desired composite resource entry returned by a composition function
 export interface CompositeResourceEntry {
   resource: KubernetesResourceLike
   // Connection details to expose on the composite resource
   connectionDetails?: Record<string, string>
+  // Defaults to the merge strategy of the response
+  merge?: MergeStrategy
 }
 
 // Crossplane composite resource
@@ -61,7 +80,10 @@ export interface CrossplaneObservedResources {
 export interface CrossplaneResourceEntry {
   resource: KubernetesResourceLike
   ready?: boolean
-  connectionDetails?: string[]
+  // Keys copied from the observed connection details of the resource, or values
+  connectionDetails?: string[] | Record<string, string>
+  // Defaults to the merge strategy of the response
+  merge?: MergeStrategy
 }
 
 // Crossplane desired resources
@@ -72,6 +94,29 @@ export interface CrossplaneDesiredResources {
   composite?: CompositeResourceEntry
   // Extra resource requirements requested by the function
   extraResourceRequirements?: Record<string, ExtraResourceRequirement>
+  // Default merge strategy of the resources, spec.merge of the input when not set
+  merge?: MergeStrategy
+  // Desired resources of earlier pipeline steps to remove
+  removeResources?: string[]
+  // Secret generated for a namespaced composite resource, spec.connectionSecret of the
+  // input when not set
+  connectionSecret?: ConnectionSecret
+  // Output of an operation function, recorded in the Operation status
+  output?: Record<string, unknown>
+  // Results reported to Crossplane; a Fatal result fails the function
+  results?: FunctionResultEntry[]
+  // How long Crossplane may cache the response, e.g. "30s", clamped by the server
+  ttl?: string
+}
+
+// Result reported to Crossplane with an explicit severity
+export interface FunctionResultEntry {
+  // Defaults to Normal
+  severity?: "Fatal" | "Warning" | "Normal"
+  reason?: string
+  // Defaults to Composite
+  target?: "Composite" | "CompositeAndClaim"
+  message: string
 }
 
 // Crossplane input structure
@@ -93,10 +138,18 @@ export interface RunFunctionRequest<
   TComposite = KubernetesResourceLike,
   TExtraResources = Record<string, unknown[]>,
 > {
+  // "operation" when the function runs in an Operation, CronOperation or WatchOperation
+  mode?: FunctionMode
+  // The composite resource, undefined in operation mode
   composite: TComposite
   extraResources?: TExtraResources
+  // The resource that triggered a WatchOperation
+  watchedResource?: KubernetesResourceLike
 }
 
+// Whether a function runs in a Composition or in an Operation
+export type FunctionMode = "composition" | "operation"
+
 // Type for composition functions
 export type CompositionFunction = (
   input: CrossplaneInput
@@ -1,21 +1,18 @@
 import { createLogger } from "@crossplane-js/libs"
 
 import { createModel } from "./model.ts"
+import { getLoadedFunction } from "./module.ts"
 import type { NodeResponse, NodeError, FunctionInput, RunFunctionRequest } from "./types.ts"
 
 // Create a logger for this module
 const moduleLogger = createLogger("executor")
 
 /**
- * Executes JavaScript/TypeScript code from a file with the given input
- * @param code The code to execute
+ * Executes the loaded user module with the given input
  * @param input The input data for the code
  * @returns The result of running the code
  */
-export async function executeCode(
-  codeFilePath: string,
-  input: FunctionInput
-): Promise<NodeResponse> {
+export async function executeCode(input: FunctionInput): Promise<NodeResponse> {
   // Set up a timeout to prevent infinite loops or long-running code
   const executionTimeout = 25000 // 25 seconds (less than the 30s in Go to ensure we can respond)
   let timeoutId: NodeJS.Timeout | null = null
@@ -35,24 +32,8 @@ export async function executeCode(
         throw new Error("Input is undefined or null")
       }
 
-      // Import the module directly from the file path
-      moduleLogger.debug(`Importing module from file: ${codeFilePath}`)
-
-      let module
-      try {
-        // Use standard Node.js dynamic import
-        module = await import(codeFilePath)
-      } catch (importErr) {
-        moduleLogger.error(`Error importing module: ${(importErr as Error).message}`)
-        if ((importErr as Error).stack) {
-          moduleLogger.debug(`Import error stack trace: ${(importErr as Error).stack}`)
-        }
-        throw new Error(`Module import failed: ${(importErr as Error).message}`)
-      }
-
-      if (!module.default || typeof module.default !== "function") {
-        throw new Error("Module does not export a default function")
-      }
+      // Reuse the module imported and validated at startup
+      const fn = getLoadedFunction()
 
       // Execute the default exported function with the input
       moduleLogger.debug("Executing default exported function")
@@ -61,8 +42,11 @@ export async function executeCode(
       try {
         const inputData = input as any
 
+        // Operations (Operation, CronOperation, WatchOperation) have no composite
+        // resource, only the resource that triggered a WatchOperation
+        const mode = inputData?.mode === "operation" ? "operation" : "composition"
         const compositeResource = inputData?.observed?.composite?.resource
-        const composite = createModel(compositeResource)
+        const composite = compositeResource ? createModel(compositeResource) : undefined
 
         // // Add observed resources
         const observedResources = inputData?.observed?.resources
@@ -75,13 +59,15 @@ export async function executeCode(
         const context = inputData?.context || {}
 
         const req: RunFunctionRequest = {
+          mode,
           composite,
           observed: observedResources,
           extraResources,
+          watchedResource: inputData?.watchedResource,
           context,
         }
 
-        result = await module.default(req)
+        result = await fn(req)
 
         moduleLogger.debug("Function execution completed")
       } catch (execErr) {
@@ -126,7 +112,7 @@ export async function executeCode(
     let errorCode = 500
     if (error.message.includes("timed out")) {
       errorCode = 408 // Request Timeout
-    } else if (error.message.includes("Module import failed")) {
+    } else if (error.message.includes("Module is not loaded")) {
       errorCode = 400 // Bad Request - code issue
     } else if (error.message.includes("Function execution error")) {
       errorCode = 422 // Unprocessable Entity - runtime error in user code
@@ -0,0 +1,115 @@
+import { createLogger } from "@crossplane-js/libs"
+
+import type { ModuleDiagnostic, ModuleLoadState } from "./types.ts"
+
+// Create a logger for this module
+const moduleLogger = createLogger("module")
+
+// Loading state of the user module, reported by the /ready endpoint
+let state: ModuleLoadState = { status: "loading" }
+
+// Default exported function of the user module, once loaded
+let loadedFunction: ((req: unknown) => unknown) | null = null
+
+// Matches "file:///path/to/file.ts:12:5", "/path/to/file.ts:12" and "(file.ts:12:5)"
+const locationPattern = /(?:file:\/\/)?(\/[^\s:()]+):(\d+)(?::(\d+))?/
+
+/**
+ * Imports the user module and checks that it default-exports a function, and
+ * optionally a `requirements` object of extra resource requirements.
+ * The result is kept for the /ready endpoint and reused by every execution.
+ * @param codeFilePath The path of the user module
+ * @returns The loading state once the import is done
+ */
+export async function loadModule(codeFilePath: string): Promise<ModuleLoadState> {
+  moduleLogger.debug(`Importing module from file: ${codeFilePath}`)
+
+  let module
+  try {
+    module = await import(codeFilePath)
+  } catch (err: unknown) {
+    const diagnostic = toDiagnostic(err, codeFilePath)
+    moduleLogger.error(`Error importing module: ${diagnostic.message}`)
+    state = { status: "error", diagnostics: [diagnostic] }
+    return state
+  }
+
+  if (!module.default || typeof module.default !== "function") {
+    const diagnostic: ModuleDiagnostic = {
+      kind: "export",
+      message: `Module does not export a default function (got ${typeof module.default})`,
+      file: codeFilePath,
+    }
+    moduleLogger.error(diagnostic.message)
+    state = { status: "error", diagnostics: [diagnostic] }
+    return state
+  }
+
+  // Requirements exported next to the function are reported by /ready, so the Go
+  // server can request their resources without running the function
+  const requirements = module.requirements
+  const requirementsType = Array.isArray(requirements)
+    ? "array"
+    : requirements === null
+      ? "null"
+      : typeof requirements
+  if (requirements !== undefined && requirementsType !== "object") {
+    const diagnostic: ModuleDiagnostic = {
+      kind: "export",
+      message: `Module exports requirements that are not an object (got ${requirementsType})`,
+      file: codeFilePath,
+    }
+    moduleLogger.error(diagnostic.message)
+    state = { status: "error", diagnostics: [diagnostic] }
+    return state
+  }
+
+  loadedFunction = module.default
+  state = requirements ? { status: "ready", requirements } : { status: "ready" }
+  moduleLogger.info("Module loaded and validated")
+  return state
+}
+
+/**
+ * @returns The current loading state of the user module
+ */
+export function getModuleState(): ModuleLoadState {
+  return state
+}
+
+/**
+ * @returns The default exported function of the user module
+ * @throws If the module is not loaded
+ */
+export function getLoadedFunction(): (req: unknown) => unknown {
+  if (!loadedFunction) {
+    throw new Error(`Module is not loaded (status: ${state.status})`)
+  }
+  return loadedFunction
+}
+
+/**
+ * Converts an import error into a diagnostic, extracting the location of the
+ * error from the stack trace when possible
+ */
+function toDiagnostic(err: unknown, codeFilePath: string): ModuleDiagnostic {
+  const error = err instanceof Error ? err : new Error(String(err))
+  const diagnostic: ModuleDiagnostic = {
+    kind: error instanceof SyntaxError ? "syntax" : "import",
+    message: error.message,
+    stack: error.stack,
+  }
+
+  const match = error.stack?.match(locationPattern)
+  if (match) {
+    diagnostic.file = match[1]
+    diagnostic.line = parseInt(match[2], 10)
+    if (match[3]) {
+      diagnostic.column = parseInt(match[3], 10)
+    }
+  } else {
+    diagnostic.file = codeFilePath
+  }
+
+  return diagnostic
+}
@@ -1,8 +1,9 @@
-import { createLogger } from "@crossplane-js/libs"
+import { createLogger, runWithRequestId, setLogLevel } from "@crossplane-js/libs"
 import express from "express"
 import type { Request, Response, NextFunction, RequestHandler } from "express"
 
 import { executeCode } from "./executor.ts"
+import { getModuleState, loadModule } from "./module.ts"
 import type { NodeRequest } from "./types.ts"
 
 // Create a logger for this module
@@ -25,16 +26,49 @@ export function createServer(port: number, codeFilePath: string) {
     next()
   })
 
-  // Readiness endpoint - used by Go server to check if Node.js server is ready
+  // Import and validate the user module right away, so that errors in the code
+  // are reported by the readiness check instead of the first execution
+  void loadModule(codeFilePath)
+
+  // Readiness endpoint - used by Go server to check if Node.js server is ready.
+  // Answers 503 while the module is loading and 422 with diagnostics if it failed to load.
   app.get("/ready", (req: Request, res: Response) => {
-    res.status(200).json({
-      status: "ready",
+    const state = getModuleState()
+    const statusCode = state.status === "ready" ? 200 : state.status === "error" ? 422 : 503
+    res.status(statusCode).json({
+      ...state,
       timestamp: new Date().toISOString(),
     })
   })
 
+  // Log level endpoint - used by Go server to change the log level at runtime
+  app.post("/log-level", (req: Request, res: Response) => {
+    const { level } = (req.body || {}) as { level?: string }
+    try {
+      setLogLevel(String(level))
+    } catch (err: unknown) {
+      res.status(400).json({ error: { code: 400, message: (err as Error).message } })
+      return
+    }
+    moduleLogger.info(`Log level set to ${level}`)
+    res.status(200).json({ level })
+  })
+
   // Execute code endpoint
-  const executeHandler: RequestHandler = async (req, res, _next) => {
+  // Everything logged while the request runs is tagged with the X-Request-ID header
+  // sent by the Go server, which uses it to attach the output to failed executions
+  const executeHandler: RequestHandler = (req, res, next) => {
+    const requestId = req.get("x-request-id")
+    return runWithRequestId(requestId, async () => {
+      try {
+        await handleExecute(req, res, next)
+      } finally {
+        markRequestEnd(requestId)
+      }
+    })
+  }
+
+  const handleExecute: RequestHandler = async (req, res, _next) => {
     try {
       const { input } = req.body as NodeRequest
 
@@ -83,7 +117,7 @@ export function createServer(port: number, codeFilePath: string) {
 
       moduleLogger.info("=== EXECUTING CODE ===")
 
-      const result = await executeCode(codeFilePath, input)
+      const result = await executeCode(input)
 
       moduleLogger.info("=== CODE EXECUTION COMPLETED ===")
 
@@ -145,6 +179,17 @@ export function createServer(port: number, codeFilePath: string) {
   return server
 }
 
+/**
+ * Writes the end-of-request marker the Go server waits for before collecting
+ * the output captured for a request. It bypasses the logger on purpose so it
+ * is emitted whatever the configured log level.
+ */
+function markRequestEnd(requestId: string | undefined) {
+  if (requestId) {
+    process.stdout.write(`${JSON.stringify({ xfuncjsRequestEnd: requestId })}\n`)
+  }
+}
+
 /**
  * Gracefully shuts down the server
  * @param server The server to shut down
@@ -37,6 +37,37 @@ export interface ExtraResourceRequirement {
   namespace?: string
 }
 
+/**
+ * Secret holding the connection details of a namespaced composite resource
+ */
+export interface ConnectionSecret {
+  /**
+   * Defaults to <composite name>-connection
+   */
+  name?: string
+
+  /**
+   * Stops generating the Secret
+   */
+  disabled?: boolean
+}
+
+/**
+ * How a returned resource is combined with the desired resource of the same name
+ * produced by earlier pipeline steps
+ */
+export interface MergeStrategy {
+  /**
+   * Defaults to replace
+   */
+  strategy?: "replace" | "mergePatch" | "deepMerge"
+
+  /**
+   * How deepMerge combines lists, defaults to replace
+   */
+  lists?: "replace" | "append" | "mergeByName"
+}
+
 /**
  * Desired composite resource entry returned by a composition function
  */
@@ -50,6 +81,11 @@ export interface CompositeResourceEntry {
    * Connection details to expose on the composite resource
    */
   connectionDetails?: Record<string, string>
+
+  /**
+   * Defaults to the merge strategy of the response
+   */
+  merge?: MergeStrategy
 }
 
 /**
@@ -74,7 +110,14 @@ export interface CrossplaneObservedResources {
 export interface CrossplaneResourceEntry {
   resource: KubernetesResource
   ready?: boolean
-  connectionDetails?: string[]
+  /**
+   * Keys copied from the observed connection details of the resource, or values
+   */
+  connectionDetails?: string[] | Record<string, string>
+  /**
+   * Defaults to the merge strategy of the response
+   */
+  merge?: MergeStrategy
 }
 
 /**
@@ -95,6 +138,53 @@ export interface CrossplaneDesiredResources {
    * Extra resource requirements requested by the function
    */
   extraResourceRequirements?: Record<string, ExtraResourceRequirement>
+
+  /**
+   * Default merge strategy of the resources, spec.merge of the input when not set
+   */
+  merge?: MergeStrategy
+
+  /**
+   * Desired resources of earlier pipeline steps to remove
+   */
+  removeResources?: string[]
+
+  /**
+   * Secret generated for a namespaced composite resource, spec.connectionSecret of the
+   * input when not set
+   */
+  connectionSecret?: ConnectionSecret
+
+  /**
+   * Output of an operation function, recorded in the Operation status
+   */
+  output?: Record<string, unknown>
+
+  /**
+   * Results reported to Crossplane; a Fatal result fails the function
+   */
+  results?: FunctionResultEntry[]
+
+  /**
+   * How long Crossplane may cache the response, e.g. "30s", clamped by the server
+   */
+  ttl?: string
+}
+
+/**
+ * Result reported to Crossplane with an explicit severity
+ */
+export interface FunctionResultEntry {
+  /**
+   * Defaults to Normal
+   */
+  severity?: "Fatal" | "Warning" | "Normal"
+  reason?: string
+  /**
+   * Defaults to Composite
+   */
+  target?: "Composite" | "CompositeAndClaim"
+  message: string
 }
 
 /**
@@ -139,12 +229,28 @@ export interface RunFunctionRequest<
   TExtraResources = Record<string, unknown[]>,
   TContext = Record<string, unknown>,
 > {
+  /**
+   * "operation" when the function runs in an Operation, CronOperation or WatchOperation
+   */
+  mode: FunctionMode
+  /**
+   * The composite resource, undefined in operation mode
+   */
   composite: TComposite
   observed: TObservedResources
   extraResources?: TExtraResources
+  /**
+   * The resource that triggered a WatchOperation
+   */
+  watchedResource?: Record<string, unknown>
   context: TContext
 }
 
+/**
+ * Whether a function runs in a Composition or in an Operation
+ */
+export type FunctionMode = "composition" | "operation"
+
 /**
  * Type for composition functions
  */
@@ -211,3 +317,39 @@ export interface NodeRequest {
    */
   input: FunctionInput
 }
+
+/**
+ * Problem found while importing or validating the user module
+ */
+export interface ModuleDiagnostic {
+  /**
+   * What went wrong: the module does not parse, an import failed, or the
+   * module does not default-export a function
+   */
+  kind: "syntax" | "import" | "export"
+
+  /**
+   * Error message
+   */
+  message: string
+
+  /**
+   * Location of the error, when known
+   */
+  file?: string
+  line?: number
+  column?: number
+
+  /**
+   * Stack trace if available
+   */
+  stack?: string
+}
+
+/**
+ * Loading state of the user module, reported by the /ready endpoint
+ */
+export type ModuleLoadState =
+  | { status: "loading" }
+  | { status: "ready"; requirements?: Record<string, ExtraResourceRequirement> }
+  | { status: "error"; diagnostics: ModuleDiagnostic[] }
@@ -2,11 +2,15 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
 )
 
 // Config holds the configuration for the server
@@ -34,9 +38,53 @@ type Config struct {
 	HealthCheckWait     time.Duration `envconfig:"HEALTH_CHECK_WAIT" default:"900s" description:"Timeout for health check"`
 	HealthCheckInterval time.Duration `envconfig:"HEALTH_CHECK_INTERVAL" default:"1s" description:"Interval for health check polling"`
 	NodeRequestTimeout  time.Duration `envconfig:"NODE_REQUEST_TIMEOUT" default:"5s" description:"Timeout for Node.js requests"`
+	NodeLogLevel        string        `envconfig:"NODE_LOG_LEVEL" default:"info" description:"Log level of the Node.js processes (trace, debug, info, warn, error, fatal, silent)"`
+
+	// Response TTL configuration
+	ResponseTTL    time.Duration `envconfig:"RESPONSE_TTL" default:"1m" description:"TTL of the function responses, after which Crossplane calls the function again"`
+	ResponseTTLMin time.Duration `envconfig:"RESPONSE_TTL_MIN" default:"10s" description:"Minimum TTL a function can request"`
+	ResponseTTLMax time.Duration `envconfig:"RESPONSE_TTL_MAX" default:"24h" description:"Maximum TTL a function can request"`
+
+	// Function output attached to failed executions
+	FailureLogLines    int    `envconfig:"FAILURE_LOG_LINES" default:"20" description:"Number of function output lines attached to failed executions (0 disables)"`
+	FailureLogMaxBytes int    `envconfig:"FAILURE_LOG_MAX_BYTES" default:"4096" description:"Maximum size of the function output attached to failed executions"`
+	FailureLogMode     string `envconfig:"FAILURE_LOG_MODE" default:"message" description:"Where to attach function output on failure (message, warning)"`
 
 	// Yarn configuration
-	MaxConcurrentYarnInstalls int `envconfig:"MAX_CONCURRENT_YARN_INSTALLS" default:"3" description:"Maximum concurrent yarn install operations"`
+	MaxConcurrentYarnInstalls int           `envconfig:"MAX_CONCURRENT_YARN_INSTALLS" default:"3" description:"Maximum concurrent yarn install operations"`
+	WorkspaceManifest         string        `envconfig:"WORKSPACE_MANIFEST" default:"/app/workspaces.json" description:"Manifest of the workspace packages bundled in the image, yarn is used when it does not exist"`
+	YarnDirStrategy           string        `envconfig:"YARN_DIR_STRATEGY" default:"reference" description:"How the shared parts of /app/.yarn are provided to each workspace (reference, hardlink, copy)"`
+	InstallTimeout            time.Duration `envconfig:"INSTALL_TIMEOUT" default:"10m" description:"Timeout of a single dependency install, not counting the time spent in the queue"`
+
+	// Package registry configuration, for private registries and air-gapped clusters
+	NpmRegistryServer string `envconfig:"NPM_REGISTRY_SERVER" description:"npm registry URL used by yarn installs"`
+	NpmAuthTokenFile  string `envconfig:"NPM_AUTH_TOKEN_FILE" description:"Path to a file holding the npm registry auth token"`
+	YarnCacheFolder   string `envconfig:"YARN_CACHE_FOLDER" description:"Offline mirror or pre-populated cache of package archives used by yarn installs"`
+	YarnOfflineOnly   bool   `envconfig:"YARN_OFFLINE_ONLY" default:"false" description:"Disable network access during yarn installs, packages must be in the cache folder"`
+	YarnStrictInstall bool   `envconfig:"YARN_STRICT_INSTALL" default:"false" description:"Fail functions whose dependency installation fails instead of starting them anyway"`
+	LockfileStrict    bool   `envconfig:"LOCKFILE_STRICT" default:"false" description:"Fail functions whose yarnLock does not match the declared dependencies instead of warning"`
+
+	// Remote source configuration, for spec.source.remote
+	RemoteSourceCacheDir        string `envconfig:"REMOTE_SOURCE_CACHE_DIR" description:"Cache of the fetched remote sources, remote-sources in the temp directory by default"`
+	RemoteSourceRegistryMirror  string `envconfig:"REMOTE_SOURCE_REGISTRY_MIRROR" description:"Registry replacing the registry of every OCI source, e.g. registry.internal:5000 or http://localhost:5000"`
+	RemoteSourceProxy           string `envconfig:"REMOTE_SOURCE_PROXY" description:"HTTP(S) proxy URL used to fetch remote sources, the proxy environment variables by default"`
+	RemoteSourceCredentialsFile string `envconfig:"REMOTE_SOURCE_CREDENTIALS_FILE" description:"Path to a docker config.json holding the credentials of the remote source hosts"`
+	RemoteSourceMaxSize         int64  `envconfig:"REMOTE_SOURCE_MAX_SIZE" default:"33554432" description:"Maximum size in bytes of a remote source archive and of its content"`
+
+	// Source signature configuration
+	SignatureKeysDir string `envconfig:"SIGNATURE_KEYS_DIR" description:"Directory of the PEM public keys (ed25519, ECDSA) trusted to sign function sources"`
+	RequireSignature bool   `envconfig:"REQUIRE_SIGNATURE" default:"false" description:"Reject unsigned function sources and sources whose signature is invalid"`
+
+	// Dependency policy configuration
+	DependencyPolicyFile string `envconfig:"DEPENDENCY_POLICY_FILE" description:"Path to a YAML or JSON dependency policy file"`
+
+	// Readiness configuration
+	ReadinessRulesFile string `envconfig:"READINESS_RULES_FILE" description:"Path to a YAML or JSON file of per-kind readiness rules, added to the default rules"`
+
+	// Admin API configuration
+	AdminEnabled     bool          `envconfig:"ADMIN_ENABLED" default:"false" description:"Enable the process administration HTTP API"`
+	AdminToken       string        `envconfig:"ADMIN_TOKEN" json:"-" description:"Bearer token required by the process administration HTTP API"`
+	AdminRollStagger time.Duration `envconfig:"ADMIN_ROLL_STAGGER" default:"5s" description:"Default delay between two process restarts when rolling all processes"`
 }
 
 // LoadConfig loads configuration from environment variables and returns a Config
@@ -99,8 +147,60 @@ func (c *Config) Validate() error {
 	if c.NodeRequestTimeout <= 0 {
 		return fmt.Errorf("node request timeout must be positive")
 	}
+	if c.ResponseTTLMin <= 0 {
+		return fmt.Errorf("response TTL min must be positive")
+	}
+	if c.ResponseTTLMax < c.ResponseTTLMin {
+		return fmt.Errorf("response TTL max must not be lower than response TTL min")
+	}
+	if c.ResponseTTL < c.ResponseTTLMin || c.ResponseTTL > c.ResponseTTLMax {
+		return fmt.Errorf("response TTL must be between response TTL min and max")
+	}
+	if !types.IsValidNodeLogLevel(c.NodeLogLevel) {
+		return fmt.Errorf("node log level must be one of: %s", strings.Join(types.NodeLogLevels, ", "))
+	}
 	if c.MaxConcurrentYarnInstalls <= 0 {
 		return fmt.Errorf("max concurrent yarn installs must be positive")
 	}
+	if !types.IsValidYarnDirStrategy(c.YarnDirStrategy) {
+		return fmt.Errorf("yarn dir strategy must be one of: %s", strings.Join(types.YarnDirStrategies, ", "))
+	}
+	if c.InstallTimeout <= 0 {
+		return fmt.Errorf("install timeout must be positive")
+	}
+	if c.NpmRegistryServer != "" {
+		if u, err := url.Parse(c.NpmRegistryServer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("npm registry server must be an http(s) URL")
+		}
+	}
+	if c.RemoteSourceProxy != "" {
+		if u, err := url.Parse(c.RemoteSourceProxy); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("remote source proxy must be a URL")
+		}
+	}
+	if c.RemoteSourceMaxSize <= 0 {
+		return fmt.Errorf("remote source max size must be positive")
+	}
+	if c.RequireSignature && c.SignatureKeysDir == "" {
+		return fmt.Errorf("signature keys directory is required when signatures are required")
+	}
+	if c.YarnOfflineOnly && c.YarnCacheFolder == "" {
+		return fmt.Errorf("yarn cache folder is required when yarn offline mode is enabled")
+	}
+	if c.FailureLogLines < 0 {
+		return fmt.Errorf("failure log lines must not be negative")
+	}
+	if c.FailureLogLines > 0 && c.FailureLogMaxBytes <= 0 {
+		return fmt.Errorf("failure log max bytes must be positive")
+	}
+	if c.FailureLogMode != "message" && c.FailureLogMode != "warning" {
+		return fmt.Errorf("failure log mode must be one of: message, warning")
+	}
+	if c.AdminEnabled && c.AdminToken == "" {
+		return fmt.Errorf("admin token is required when the admin API is enabled")
+	}
+	if c.AdminRollStagger < 0 {
+		return fmt.Errorf("admin roll stagger must not be negative")
+	}
 	return nil
 }
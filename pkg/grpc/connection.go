@@ -0,0 +1,147 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	"github.com/crossplane/function-sdk-go/resource"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+const (
+	// connectionSecretResource is the name of the desired composed Secret holding the
+	// connection details of a namespaced composite resource
+	connectionSecretResource = "xfuncjs-connection-secret"
+	// connectionSecretType is the type of the Secrets Crossplane writes connection details to
+	connectionSecretType = "connection.crossplane.io/v1alpha1"
+	// connectionSecretSuffix is appended to the composite name for the default Secret name
+	connectionSecretSuffix = "-connection"
+	// compositeLabel is the label Crossplane sets on the resources of a composite
+	compositeLabel = "crossplane.io/composite"
+	// maxSecretNameLength is the maximum length of a Secret name
+	maxSecretNameLength = 253
+)
+
+// ConnectionDetails selects the connection details of a resource: the keys to copy from
+// the observed connection details of a composed resource, or values set by the function
+type ConnectionDetails struct {
+	// Keys of the observed connection details
+	Keys []string
+	// Values of the connection details
+	Values map[string]string
+}
+
+// UnmarshalJSON reads a list of keys or a map of values
+func (c *ConnectionDetails) UnmarshalJSON(data []byte) error {
+	var keys []string
+	if err := json.Unmarshal(data, &keys); err == nil {
+		*c = ConnectionDetails{Keys: keys}
+		return nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return errors.New("connectionDetails must be a list of keys or a map of string values")
+	}
+	*c = ConnectionDetails{Values: values}
+	return nil
+}
+
+// setConnectionDetails aggregates the connection details of the composed resources into
+// the desired composite resource, the values set on the composite taking precedence. A
+// namespaced composite resource, whose connection details Crossplane v2 no longer
+// writes, also gets a desired composed Secret holding them.
+func setConnectionDetails(jsResponse *JSResponse, resources *resourceBundle, log logger.Logger) error {
+	if _, ok := jsResponse.Resources[connectionSecretResource]; ok {
+		return errors.Errorf("resource name %s is reserved for the connection secret", connectionSecretResource)
+	}
+
+	// Resources are read in name order so that a key set by several resources always
+	// gets the same value
+	names := make([]string, 0, len(jsResponse.Resources))
+	for name := range jsResponse.Resources {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	details := resource.ConnectionDetails{}
+	for _, name := range names {
+		selected := jsResponse.Resources[name].ConnectionDetails
+		observed := resources.observed[resource.Name(name)].ConnectionDetails
+		for _, key := range selected.Keys {
+			// The details are not observed until the resource is created
+			if value, ok := observed[key]; ok {
+				details[key] = value
+			}
+		}
+		for key, value := range selected.Values {
+			details[key] = []byte(value)
+		}
+	}
+
+	if len(details) > 0 {
+		if resources.dxr.ConnectionDetails == nil {
+			resources.dxr.ConnectionDetails = resource.ConnectionDetails{}
+		}
+		for key, value := range details {
+			if _, ok := resources.dxr.ConnectionDetails[key]; !ok {
+				resources.dxr.ConnectionDetails[key] = value
+			}
+		}
+	}
+
+	namespace := resources.oxr.Resource.GetNamespace()
+	config := jsResponse.ConnectionSecret
+	if namespace == "" || len(resources.dxr.ConnectionDetails) == 0 || (config != nil && config.Disabled) {
+		return nil
+	}
+	if config != nil {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+	}
+
+	secret := connectionSecret(resources.oxr.Resource.GetName(), namespace, config, resources.dxr.ConnectionDetails)
+	log.Debug("Generating the connection secret of the composite resource", "name", secret.Resource.GetName(), "namespace", namespace)
+	resources.desired[connectionSecretResource] = secret
+	return nil
+}
+
+// connectionSecret returns the desired Secret holding the connection details of the
+// composite resource named composite
+func connectionSecret(composite, namespace string, config *types.ConnectionSecret, details resource.ConnectionDetails) *resource.DesiredComposed {
+	name := composite
+	if len(name) > maxSecretNameLength-len(connectionSecretSuffix) {
+		name = name[:maxSecretNameLength-len(connectionSecretSuffix)]
+	}
+	name += connectionSecretSuffix
+	if config != nil && config.Name != "" {
+		name = config.Name
+	}
+
+	data := make(map[string]interface{}, len(details))
+	for key, value := range details {
+		data[key] = base64.StdEncoding.EncodeToString(value)
+	}
+
+	secret := resource.NewDesiredComposed()
+	secret.Resource.Object = map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+			"labels": map[string]interface{}{
+				compositeLabel: composite,
+			},
+		},
+		"type": connectionSecretType,
+		"data": data,
+	}
+	// A Secret is usable as soon as it exists
+	secret.Ready = resource.ReadyTrue
+	return secret
+}
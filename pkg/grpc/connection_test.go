@@ -0,0 +1,135 @@
+package grpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func newConnectionBundle(namespace string) *resourceBundle {
+	oxr := &resource.Composite{Resource: composite.New()}
+	oxr.Resource.SetName("db")
+	oxr.Resource.SetNamespace(namespace)
+	return &resourceBundle{
+		oxr: oxr,
+		dxr: &resource.Composite{Resource: composite.New()},
+		observed: map[resource.Name]resource.ObservedComposed{
+			"instance": {
+				Resource:          composed.New(),
+				ConnectionDetails: resource.ConnectionDetails{"password": []byte("s3cr3t"), "port": []byte("5432")},
+			},
+		},
+		desired: map[resource.Name]*resource.DesiredComposed{},
+	}
+}
+
+func TestConnectionDetailsUnmarshal(t *testing.T) {
+	var resources map[string]JSResource
+	if err := json.Unmarshal([]byte(`{
+		"keys": {"resource": {}, "connectionDetails": ["password"]},
+		"values": {"resource": {}, "connectionDetails": {"host": "db.local"}}
+	}`), &resources); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if keys := resources["keys"].ConnectionDetails.Keys; len(keys) != 1 || keys[0] != "password" {
+		t.Errorf("keys = %v, want [password]", keys)
+	}
+	if values := resources["values"].ConnectionDetails.Values; values["host"] != "db.local" {
+		t.Errorf("values = %v, want host", values)
+	}
+
+	var invalid JSResource
+	if err := json.Unmarshal([]byte(`{"connectionDetails": 42}`), &invalid); err == nil {
+		t.Error("json.Unmarshal() accepted a number")
+	}
+}
+
+func TestSetConnectionDetailsNamespaced(t *testing.T) {
+	resources := newConnectionBundle("team-a")
+	resources.dxr.ConnectionDetails = resource.ConnectionDetails{"host": []byte("composite")}
+	jsResponse := &JSResponse{
+		Resources: map[string]JSResource{
+			"instance": {ConnectionDetails: ConnectionDetails{Keys: []string{"password", "missing"}}},
+			"service":  {ConnectionDetails: ConnectionDetails{Values: map[string]string{"host": "service", "user": "admin"}}},
+		},
+	}
+	if err := setConnectionDetails(jsResponse, resources, logger.NewLogrusLogger("error", "text")); err != nil {
+		t.Fatalf("setConnectionDetails() error = %v", err)
+	}
+
+	secret, ok := resources.desired[connectionSecretResource]
+	if !ok {
+		t.Fatal("setConnectionDetails() did not generate the connection secret")
+	}
+	if secret.Resource.GetName() != "db-connection" || secret.Resource.GetNamespace() != "team-a" {
+		t.Errorf("secret = %s/%s, want team-a/db-connection", secret.Resource.GetNamespace(), secret.Resource.GetName())
+	}
+	if secret.Resource.GetLabels()[compositeLabel] != "db" {
+		t.Errorf("labels = %v, want the composite label", secret.Resource.GetLabels())
+	}
+	data, _, _ := unstructured.NestedStringMap(secret.Resource.Object, "data")
+	want := map[string]string{"password": "czNjcjN0", "host": "Y29tcG9zaXRl", "user": "YWRtaW4="}
+	if len(data) != len(want) {
+		t.Errorf("data = %v, want %v", data, want)
+	}
+	for key, value := range want {
+		if data[key] != value {
+			t.Errorf("data[%s] = %q, want %q", key, data[key], value)
+		}
+	}
+}
+
+func TestSetConnectionDetailsClusterScoped(t *testing.T) {
+	resources := newConnectionBundle("")
+	jsResponse := &JSResponse{
+		Resources: map[string]JSResource{
+			"instance": {ConnectionDetails: ConnectionDetails{Keys: []string{"port"}}},
+		},
+	}
+	if err := setConnectionDetails(jsResponse, resources, logger.NewLogrusLogger("error", "text")); err != nil {
+		t.Fatalf("setConnectionDetails() error = %v", err)
+	}
+	if string(resources.dxr.ConnectionDetails["port"]) != "5432" {
+		t.Errorf("composite connection details = %v, want port", resources.dxr.ConnectionDetails)
+	}
+	if _, ok := resources.desired[connectionSecretResource]; ok {
+		t.Error("setConnectionDetails() generated a secret for a cluster-scoped composite resource")
+	}
+}
+
+func TestSetConnectionDetailsConfig(t *testing.T) {
+	log := logger.NewLogrusLogger("error", "text")
+	selected := map[string]JSResource{
+		"instance": {ConnectionDetails: ConnectionDetails{Keys: []string{"password"}}},
+	}
+
+	resources := newConnectionBundle("team-a")
+	jsResponse := &JSResponse{Resources: selected, ConnectionSecret: &types.ConnectionSecret{Name: "db-credentials"}}
+	if err := setConnectionDetails(jsResponse, resources, log); err != nil {
+		t.Fatalf("setConnectionDetails() error = %v", err)
+	}
+	if name := resources.desired[connectionSecretResource].Resource.GetName(); name != "db-credentials" {
+		t.Errorf("secret name = %s, want db-credentials", name)
+	}
+
+	resources = newConnectionBundle("team-a")
+	jsResponse = &JSResponse{Resources: selected, ConnectionSecret: &types.ConnectionSecret{Disabled: true}}
+	if err := setConnectionDetails(jsResponse, resources, log); err != nil {
+		t.Fatalf("setConnectionDetails() error = %v", err)
+	}
+	if _, ok := resources.desired[connectionSecretResource]; ok {
+		t.Error("setConnectionDetails() generated a disabled secret")
+	}
+
+	jsResponse = &JSResponse{Resources: map[string]JSResource{connectionSecretResource: {}}}
+	if err := setConnectionDetails(jsResponse, newConnectionBundle("team-a"), log); err == nil {
+		t.Error("setConnectionDetails() accepted a resource with the reserved name")
+	}
+}
@@ -6,21 +6,40 @@ import (
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
 	"github.com/socialgouv/xfuncjs-server/pkg/node"
+	"github.com/socialgouv/xfuncjs-server/pkg/readiness"
+	"github.com/socialgouv/xfuncjs-server/pkg/signature"
+	"github.com/socialgouv/xfuncjs-server/pkg/source"
 )
 
 // Function implements the Crossplane Function interface
 type Function struct {
 	fnv1.UnimplementedFunctionRunnerServiceServer
-	processManager *node.ProcessManager
-	logger         logger.Logger
-	logCrossplaneIO bool
+	processManager     *node.ProcessManager
+	logger             logger.Logger
+	logCrossplaneIO    bool
+	failureLogLines    int    // Function output lines attached to failed executions
+	failureLogMaxBytes int    // Size cap of the attached function output
+	failureLogMode     string // FailureLogModeMessage or FailureLogModeWarning
+	remoteSources      *source.Fetcher
+	signatures         *signature.Verifier
+	responseTTL        time.Duration // TTL of the responses, unless the function requests one
+	responseTTLMin     time.Duration // Bounds of the TTL requested by the function
+	responseTTLMax     time.Duration
+	readinessRules     *readiness.Rules // Derive the readiness of the composed resources
 }
 
 // NewFunction creates a new Function
 func NewFunction(processManager *node.ProcessManager, logger logger.Logger) *Function {
 	return &Function{
-		processManager: processManager,
-		logger:         logger,
+		processManager:     processManager,
+		logger:             logger,
+		failureLogLines:    defaultFailureLogLines,
+		failureLogMaxBytes: defaultFailureLogMaxBytes,
+		failureLogMode:     FailureLogModeMessage,
+		responseTTL:        defaultResponseTTL,
+		responseTTLMin:     defaultResponseTTLMin,
+		responseTTLMax:     defaultResponseTTLMax,
+		readinessRules:     readiness.DefaultRules(),
 	}
 }
 
@@ -30,6 +49,41 @@ func (f *Function) SetLogCrossplaneIO(enabled bool) {
 	f.logCrossplaneIO = enabled
 }
 
+// SetFailureLogs configures how much of the function output is attached to failed
+// executions and whether it goes into the fatal message or a separate Warning result.
+// A zero lines count disables it.
+func (f *Function) SetFailureLogs(lines, maxBytes int, mode string) {
+	f.failureLogLines = lines
+	f.failureLogMaxBytes = maxBytes
+	f.failureLogMode = mode
+}
+
+// SetRemoteSources sets the fetcher of the sources referenced with spec.source.remote.
+// Remote sources are rejected when it is not set.
+func (f *Function) SetRemoteSources(fetcher *source.Fetcher) {
+	f.remoteSources = fetcher
+}
+
+// SetSignatureVerifier sets the verifier of spec.source.signature. Signatures are not
+// checked when it is not set.
+func (f *Function) SetSignatureVerifier(verifier *signature.Verifier) {
+	f.signatures = verifier
+}
+
+// SetResponseTTL sets the TTL of the responses, after which Crossplane calls the function
+// again, and the bounds of the TTL a function can request with its ttl field
+func (f *Function) SetResponseTTL(ttl, minTTL, maxTTL time.Duration) {
+	f.responseTTL = ttl
+	f.responseTTLMin = minTTL
+	f.responseTTLMax = maxTTL
+}
+
+// SetReadinessRules sets the rules deriving the readiness of the composed resources a
+// function leaves unset
+func (f *Function) SetReadinessRules(rules *readiness.Rules) {
+	f.readinessRules = rules
+}
+
 // SetNodeHealthCheckConfig sets the health check configuration for the Node.js HTTP server
 func (f *Function) SetNodeHealthCheckConfig(wait, interval time.Duration) {
 	f.processManager.SetHealthCheckWait(wait)
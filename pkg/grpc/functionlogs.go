@@ -0,0 +1,120 @@
+package grpc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/node"
+)
+
+// Where the function output is attached when an execution fails
+const (
+	// FailureLogModeMessage appends the output to the fatal result message
+	FailureLogModeMessage = "message"
+	// FailureLogModeWarning adds the output as a separate Warning result
+	FailureLogModeWarning = "warning"
+)
+
+// Default limits for the function output attached to failed executions
+const (
+	defaultFailureLogLines    = 20
+	defaultFailureLogMaxBytes = 4096
+)
+
+// failureLogReason is the reason of the Warning result carrying function output
+const failureLogReason = "FunctionOutput"
+
+var (
+	// sensitiveAssignment matches key=value, key: value and "key":"value" pairs whose
+	// key looks sensitive (see sensitiveKeyFragments)
+	sensitiveAssignment = regexp.MustCompile(`(?i)("?[\w.-]*(?:` + strings.Join(sensitiveKeyFragments, "|") + `)[\w.-]*"?\s*[:=]\s*)("[^"]*"|'[^']*'|[^\s,;}]+)`)
+	// bearerToken matches HTTP authorization values
+	bearerToken = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]+`)
+)
+
+// fatalWithLogs sets a fatal result for err, attaching the tail of the function
+// output according to the configured failure log mode
+func (f *Function) fatalWithLogs(rsp *fnv1.RunFunctionResponse, err error, logs []string) {
+	tail := tailLogs(logs, f.failureLogLines, f.failureLogMaxBytes)
+	if len(tail) == 0 {
+		response.Fatal(rsp, err)
+		return
+	}
+
+	output := strings.Join(tail, "\n")
+	header := fmt.Sprintf("function output (last %d lines)", len(tail))
+
+	if f.failureLogMode == FailureLogModeWarning {
+		response.Warning(rsp, errors.Errorf("%s:\n%s", header, output)).WithReason(failureLogReason)
+		response.Fatal(rsp, err)
+		return
+	}
+
+	response.Fatal(rsp, errors.Errorf("%s\n--- %s ---\n%s", err.Error(), header, output))
+}
+
+// executionLogs returns the function output carried by an execution error, if any
+func executionLogs(err error) []string {
+	var execErr *node.ExecutionError
+	if errors.As(err, &execErr) {
+		return execErr.Logs
+	}
+	return nil
+}
+
+// tailLogs returns the last maxLines redacted lines, dropping older lines until
+// the total size fits in maxBytes
+func tailLogs(logs []string, maxLines, maxBytes int) []string {
+	if maxLines <= 0 || len(logs) == 0 {
+		return nil
+	}
+	if len(logs) > maxLines {
+		logs = logs[len(logs)-maxLines:]
+	}
+
+	tail := make([]string, 0, len(logs))
+	size := 0
+	for i := len(logs) - 1; i >= 0; i-- {
+		line := redactLogLine(logs[i])
+		if maxBytes > 0 && size+len(line)+1 > maxBytes {
+			// Keep at least the most recent line, truncated
+			if len(tail) == 0 && maxBytes > 3 {
+				tail = append(tail, truncateLine(line, maxBytes-3)+"...")
+			}
+			break
+		}
+		size += len(line) + 1
+		tail = append(tail, line)
+	}
+
+	// Lines were collected newest first
+	for i, j := 0, len(tail)-1; i < j; i, j = i+1, j-1 {
+		tail[i], tail[j] = tail[j], tail[i]
+	}
+	return tail
+}
+
+// truncateLine returns the longest prefix of line of at most maxBytes bytes that does
+// not split a multi-byte UTF-8 character
+func truncateLine(line string, maxBytes int) string {
+	if len(line) <= maxBytes {
+		return line
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	return line[:cut]
+}
+
+// redactLogLine masks values that look like secrets in a line of function output
+func redactLogLine(line string) string {
+	line = bearerToken.ReplaceAllString(line, "$1 REDACTED")
+	return sensitiveAssignment.ReplaceAllString(line, "${1}REDACTED")
+}
@@ -0,0 +1,50 @@
+package grpc
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTailLogs(t *testing.T) {
+	logs := []string{
+		"INFO starting",
+		`INFO connecting {"password":"s3cr3t"}`,
+		"ERROR request failed with Authorization: Bearer abc.def.ghi",
+		"ERROR api_token=xyz123 rejected",
+		"ERROR boom",
+	}
+
+	tail := tailLogs(logs, 4, 4096)
+	if len(tail) != 4 {
+		t.Fatalf("expected 4 lines, got %d: %v", len(tail), tail)
+	}
+	if tail[len(tail)-1] != "ERROR boom" {
+		t.Fatalf("expected the most recent line last, got %v", tail)
+	}
+
+	out := strings.Join(tail, "\n")
+	for _, wantAbsent := range []string{"s3cr3t", "abc.def.ghi", "xyz123", "starting"} {
+		if strings.Contains(out, wantAbsent) {
+			t.Fatalf("expected %q to be absent from output: %s", wantAbsent, out)
+		}
+	}
+
+	// The size cap drops the oldest lines first
+	capped := tailLogs(logs, 10, 20)
+	if len(capped) != 1 || capped[0] != "ERROR boom" {
+		t.Fatalf("expected only the most recent line to fit, got %v", capped)
+	}
+
+	// Truncation does not split multi-byte characters
+	for maxBytes := 4; maxBytes < 16; maxBytes++ {
+		truncated := tailLogs([]string{"ERROR échec: données invalides ✗"}, 1, maxBytes)
+		if len(truncated) != 1 || !utf8.ValidString(truncated[0]) || len(truncated[0]) > maxBytes {
+			t.Fatalf("expected a valid UTF-8 line of at most %d bytes, got %q", maxBytes, truncated)
+		}
+	}
+
+	if got := tailLogs(logs, 0, 4096); got != nil {
+		t.Fatalf("expected no output when disabled, got %v", got)
+	}
+}
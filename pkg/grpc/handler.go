@@ -8,6 +8,7 @@ import (
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/request"
 	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
 	"github.com/crossplane/function-sdk-go/response"
 	"google.golang.org/protobuf/types/known/structpb"
 
@@ -15,6 +16,11 @@ import (
 	"github.com/socialgouv/xfuncjs-server/pkg/context/fncontext"
 	"github.com/socialgouv/xfuncjs-server/pkg/events"
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/node"
+	"github.com/socialgouv/xfuncjs-server/pkg/readiness"
+	"github.com/socialgouv/xfuncjs-server/pkg/results"
+	"github.com/socialgouv/xfuncjs-server/pkg/signature"
+	"github.com/socialgouv/xfuncjs-server/pkg/source"
 	"github.com/socialgouv/xfuncjs-server/pkg/types"
 )
 
@@ -27,8 +33,8 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		logCrossplaneRequest(log, req)
 	}
 
-	// Create a response with default TTL
-	rsp := response.To(req, response.DefaultTTL)
+	// Create a response with the default TTL of the server
+	rsp := response.To(req, f.responseTTL)
 
 	// Parse and validate input
 	xfuncjsInput, err := parseInput(req)
@@ -46,6 +52,55 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		return rsp, nil
 	}
 
+	// A source in a ConfigMap or Secret is requested on every call, as Crossplane only
+	// supplies the required resources of the previous response
+	selector, err := sourceSelector(xfuncjsInput, resources)
+	if err != nil {
+		log.WithField(logger.FieldError, err.Error()).Error("Fatal: invalid function source")
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+	if selector != nil {
+		requireResources(rsp, map[string]*fnv1.ResourceSelector{sourceRequirement: selector})
+	}
+	resolved, err := resolveSource(xfuncjsInput, resources)
+	if err != nil {
+		log.WithField(logger.FieldError, err.Error()).Error("Fatal: cannot load function source")
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+	if !resolved {
+		log.Debug("Requesting function source", "kind", selector.GetKind(), "name", selector.GetMatchName())
+		return rsp, nil
+	}
+
+	if err := f.resolveRemoteSource(ctx, xfuncjsInput); err != nil {
+		log.WithField(logger.FieldError, err.Error()).Error("Fatal: cannot load remote function source")
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+
+	// Decode the source before it is hashed and written to the workspace
+	if err := source.Decode(xfuncjsInput); err != nil {
+		log.WithField(logger.FieldError, err.Error()).Error("Fatal: cannot decode function source")
+		response.Fatal(rsp, errors.Wrap(err, "cannot decode function source"))
+		return rsp, nil
+	}
+
+	// Verify the signature of the decoded source before any process is created
+	if f.signatures != nil {
+		if err := f.signatures.Verify(xfuncjsInput); err != nil {
+			var verifyErr *signature.VerificationError
+			if !errors.As(err, &verifyErr) || f.signatures.Required() {
+				log.WithField(logger.FieldError, err.Error()).Error("Fatal: function source rejected")
+				response.Fatal(rsp, err)
+				return rsp, nil
+			}
+			log.WithField(logger.FieldError, err.Error()).Warn("Function source signature is invalid")
+			response.Warning(rsp, err).WithReason("SourceSignatureInvalid")
+		}
+	}
+
 	// Create enhanced input for JavaScript function
 	enhancedInput, err := createEnhancedInput(xfuncjsInput, resources)
 	if err != nil {
@@ -54,24 +109,63 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		return rsp, nil
 	}
 
-	// Execute function
-	result, err := f.executeFunction(ctx, xfuncjsInput, enhancedInput)
+	// Requirements exported by the module are requested on every call. Until Crossplane
+	// supplies their resources, the function answers without running the user code.
+	var result *node.ExecutionResult
+	selectors, err := f.staticRequirements(ctx, xfuncjsInput)
+	if err == nil {
+		requireResources(rsp, selectors)
+		if missing := missingRequirements(selectors, resources); len(missing) > 0 {
+			log.Debug("Requesting the resources required by the function", "requirements", missing)
+			return rsp, nil
+		}
+
+		// Execute function
+		result, err = f.executeFunction(ctx, xfuncjsInput, enhancedInput)
+	}
 	if err != nil {
 		log.WithField(logger.FieldError, err.Error()).Error("Fatal: function execution failed")
-		response.Fatal(rsp, err)
+
+		// Errors in the function itself are reported with their details only
+		if defect := functionDefect(err); defect != nil {
+			response.Fatal(rsp, defect)
+			return rsp, nil
+		}
+
+		f.fatalWithLogs(rsp, err, executionLogs(err))
 		return rsp, nil
 	}
 
+	// Report the problems found while setting up the function, such as lockfile drift
+	for _, warning := range result.Warnings {
+		response.Warning(rsp, errors.New(warning.Message)).WithReason(warning.Reason)
+	}
+
 	// Process result
-	jsResponse, err := processResult(result)
+	jsResponse, err := processResult(result.Output)
 	if err != nil {
 		log.WithField(logger.FieldError, err.Error()).Error("Fatal: invalid function result")
-		response.Fatal(rsp, err)
+		f.fatalWithLogs(rsp, err, result.Logs)
 		return rsp, nil
 	}
 
+	// The merge strategy and connection secret of the input apply unless the function
+	// sets its own
+	if jsResponse.Merge == nil {
+		jsResponse.Merge = xfuncjsInput.Spec.Merge
+	}
+	if jsResponse.ConnectionSecret == nil {
+		jsResponse.ConnectionSecret = xfuncjsInput.Spec.ConnectionSecret
+	}
+
+	// The readiness of the composed resources is derived unless the input disables it
+	var readinessRules *readiness.Rules
+	if xfuncjsInput.IsAutoReady() {
+		readinessRules = f.readinessRules
+	}
+
 	// Build response
-	if err := buildResponse(rsp, jsResponse, resources, f.logger); err != nil {
+	if err := buildResponse(rsp, jsResponse, resources, readinessRules, f.logger); err != nil {
 		log.WithField(logger.FieldError, err.Error()).Error("Fatal: failed to build response")
 		response.Fatal(rsp, err)
 		if f.logCrossplaneIO {
@@ -80,6 +174,12 @@ func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest
 		return rsp, nil
 	}
 
+	if err := f.setResponseTTL(rsp, jsResponse.TTL, log); err != nil {
+		log.WithField(logger.FieldError, err.Error()).Error("Fatal: invalid function result")
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+
 	f.logger.Info("Successfully processed JavaScript function resources")
 	if f.logCrossplaneIO {
 		logCrossplaneResponse(log, rsp)
@@ -102,36 +202,58 @@ func parseInput(req *fnv1.RunFunctionRequest) (*types.XFuncJSInput, error) {
 	return xfuncjsInput, nil
 }
 
+// watchedResourceRequirement is the required resource through which a WatchOperation
+// supplies the resource that triggered it
+const watchedResourceRequirement = "ops.crossplane.io/watched-resource"
+
+// Modes of a function call, passed to the JavaScript function
+const (
+	modeComposition = "composition"
+	modeOperation   = "operation"
+)
+
 // prepareResources prepares the resources from the request
 type resourceBundle struct {
-	oxr            *resource.Composite
-	dxr            *resource.Composite
-	observed       map[resource.Name]resource.ObservedComposed
-	desired        map[resource.Name]*resource.DesiredComposed
-	extraResources map[string][]resource.Required
-	credentials    map[string]resource.Credentials
-	context        *structpb.Struct
+	// operation is set when the function runs in an Operation, CronOperation or
+	// WatchOperation: there is no composite resource
+	operation       bool
+	oxr             *resource.Composite
+	dxr             *resource.Composite
+	observed        map[resource.Name]resource.ObservedComposed
+	desired         map[resource.Name]*resource.DesiredComposed
+	extraResources  map[string][]resource.Required
+	watchedResource map[string]interface{}
+	credentials     map[string]resource.Credentials
+	context         *structpb.Struct
 }
 
 func prepareResources(req *fnv1.RunFunctionRequest) (*resourceBundle, error) {
 	// Get context
 	context := req.GetContext()
 
-	// Get the observed composite resource
-	oxr, err := request.GetObservedCompositeResource(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot get observed composite resource")
-	}
+	// Operations call functions without any composite resource
+	operation := req.GetObserved().GetComposite() == nil
 
-	// Get the desired composite resource
-	dxr, err := request.GetDesiredCompositeResource(req)
-	if err != nil {
-		return nil, errors.Wrap(err, "cannot get desired composite resource")
-	}
+	oxr := &resource.Composite{Resource: composite.New(), ConnectionDetails: resource.ConnectionDetails{}}
+	dxr := &resource.Composite{Resource: composite.New(), ConnectionDetails: resource.ConnectionDetails{}}
+	if !operation {
+		// Get the observed composite resource
+		var err error
+		oxr, err = request.GetObservedCompositeResource(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot get observed composite resource")
+		}
 
-	// Set API version and kind from observed to desired
-	dxr.Resource.SetAPIVersion(oxr.Resource.GetAPIVersion())
-	dxr.Resource.SetKind(oxr.Resource.GetKind())
+		// Get the desired composite resource
+		dxr, err = request.GetDesiredCompositeResource(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot get desired composite resource")
+		}
+
+		// Set API version and kind from observed to desired
+		dxr.Resource.SetAPIVersion(oxr.Resource.GetAPIVersion())
+		dxr.Resource.SetKind(oxr.Resource.GetKind())
+	}
 
 	// Get the desired composed resources
 	desired, err := request.GetDesiredComposedResources(req)
@@ -147,11 +269,20 @@ func prepareResources(req *fnv1.RunFunctionRequest) (*resourceBundle, error) {
 
 	// Get required resources from the new required_resources field and merge any
 	// legacy extra_resources for backwards compatibility.
-	extraResources, err := request.GetRequiredResources(req)
+	extraResources, err := requiredResources(req)
 	if err != nil {
 		return nil, errors.Wrapf(err, "cannot get required/extra resources from %T", req)
 	}
 
+	// The resource that triggered a WatchOperation is passed on its own
+	var watchedResource map[string]interface{}
+	if operation {
+		if watched := extraResources[watchedResourceRequirement]; len(watched) > 0 {
+			watchedResource = watched[0].Resource.UnstructuredContent()
+		}
+		delete(extraResources, watchedResourceRequirement)
+	}
+
 	// Initialize credentials map
 	credentials := make(map[string]resource.Credentials)
 
@@ -165,13 +296,15 @@ func prepareResources(req *fnv1.RunFunctionRequest) (*resourceBundle, error) {
 	}
 
 	return &resourceBundle{
-		oxr:            oxr,
-		dxr:            dxr,
-		observed:       observed,
-		desired:        desired,
-		extraResources: extraResources,
-		credentials:    credentials,
-		context:        context,
+		operation:       operation,
+		oxr:             oxr,
+		dxr:             dxr,
+		observed:        observed,
+		desired:         desired,
+		extraResources:  extraResources,
+		watchedResource: watchedResource,
+		credentials:     credentials,
+		context:         context,
 	}, nil
 }
 
@@ -184,6 +317,7 @@ func createEnhancedInput(xfuncjsInput *types.XFuncJSInput, resources *resourceBu
 			"kind":       xfuncjsInput.Kind,
 			"spec":       xfuncjsInput.Spec,
 		},
+		"mode": modeComposition,
 		"observed": map[string]interface{}{
 			"composite": map[string]interface{}{
 				"resource": resources.oxr.Resource.UnstructuredContent(),
@@ -192,8 +326,18 @@ func createEnhancedInput(xfuncjsInput *types.XFuncJSInput, resources *resourceBu
 		},
 	}
 
+	// Operations have no composite resource, only the resource that triggered a
+	// WatchOperation
+	if resources.operation {
+		enhancedInput["mode"] = modeOperation
+		delete(enhancedInput["observed"].(map[string]interface{}), "composite")
+		if resources.watchedResource != nil {
+			enhancedInput["watchedResource"] = resources.watchedResource
+		}
+	}
+
 	// Add composite resource connection details if present
-	if len(resources.oxr.ConnectionDetails) > 0 {
+	if !resources.operation && len(resources.oxr.ConnectionDetails) > 0 {
 		compositeMap := enhancedInput["observed"].(map[string]interface{})["composite"].(map[string]interface{})
 		connectionDetails := make(map[string]string)
 		for k, v := range resources.oxr.ConnectionDetails {
@@ -248,12 +392,46 @@ func createEnhancedInput(xfuncjsInput *types.XFuncJSInput, resources *resourceBu
 	return string(enhancedInputJSON), nil
 }
 
+// resolveRemoteSource replaces spec.source.remote with the fetched files
+func (f *Function) resolveRemoteSource(ctx context.Context, xfuncjsInput *types.XFuncJSInput) error {
+	if xfuncjsInput.Spec.Source.Remote == nil {
+		return nil
+	}
+	if f.remoteSources == nil {
+		return errors.New("remote sources are not enabled on this server")
+	}
+	return errors.Wrap(f.remoteSources.Resolve(ctx, xfuncjsInput), "cannot load remote source")
+}
+
+// functionDefect returns the error describing a defect of the function itself (code,
+// dependencies or lockfile), reported without retry context or output, or nil for any
+// other error
+func functionDefect(err error) error {
+	var loadErr *node.ModuleLoadError
+	if errors.As(err, &loadErr) {
+		return loadErr
+	}
+	var policyErr *node.PolicyViolationError
+	if errors.As(err, &policyErr) {
+		return policyErr
+	}
+	var driftErr *node.LockfileDriftError
+	if errors.As(err, &driftErr) {
+		return driftErr
+	}
+	var installErr *node.YarnInstallError
+	if errors.As(err, &installErr) {
+		return installErr
+	}
+	return nil
+}
+
 // executeFunction executes the JavaScript function
-func (f *Function) executeFunction(ctx context.Context, xfuncjsInput *types.XFuncJSInput, enhancedInput string) (string, error) {
+func (f *Function) executeFunction(ctx context.Context, xfuncjsInput *types.XFuncJSInput, enhancedInput string) (*node.ExecutionResult, error) {
 	// Execute the function using the process manager with the enhanced input
 	result, err := f.processManager.ExecuteFunction(ctx, xfuncjsInput, enhancedInput)
 	if err != nil {
-		return "", errors.Wrap(err, "error executing function")
+		return nil, errors.Wrap(err, "error executing function")
 	}
 
 	return result, nil
@@ -288,13 +466,39 @@ func processResult(result string) (*JSResponse, error) {
 	return jsResponse, nil
 }
 
-// buildResponse builds the final response
-func buildResponse(rsp *fnv1.RunFunctionResponse, jsResponse *JSResponse, resources *resourceBundle, log logger.Logger) error {
+// buildResponse builds the final response. The readiness of the composed resources left
+// unset is derived with readinessRules, unless it is nil.
+func buildResponse(rsp *fnv1.RunFunctionResponse, jsResponse *JSResponse, resources *resourceBundle, readinessRules *readiness.Rules, log logger.Logger) error {
+	if resources.operation && jsResponse.Composite != nil {
+		return errors.New("an operation function cannot return a composite resource")
+	}
+
+	// A Fatal result fails the function like response.Fatal: the desired state of the
+	// request is passed through unchanged
+	if err := results.SetResults(rsp, jsResponse.Results); err != nil {
+		return errors.Wrapf(err, "failed to process results")
+	}
+	if jsResponse.Results.HasFatal() {
+		log.Debug("The function returned a Fatal result, ignoring its desired state")
+		return nil
+	}
+
 	// Process resources
 	if err := ProcessResources(rsp, resources.dxr, resources.desired, jsResponse); err != nil {
 		return errors.Wrapf(err, "failed to process resources")
 	}
 
+	// Operations have no composite resource to expose connection details on, nor to
+	// become ready with its composed resources
+	if !resources.operation {
+		if err := setConnectionDetails(jsResponse, resources, log); err != nil {
+			return errors.Wrapf(err, "failed to process connection details")
+		}
+		if readinessRules != nil {
+			setReadiness(jsResponse, resources, readinessRules)
+		}
+	}
+
 	// Process events if present
 	if len(jsResponse.Events) > 0 {
 		// Convert our events to the events package format
@@ -321,17 +525,14 @@ func buildResponse(rsp *fnv1.RunFunctionResponse, jsResponse *JSResponse, resour
 
 	// Process extra resource requirements if present
 	if len(jsResponse.ExtraResourceRequirements) > 0 {
-		extraResources := make(map[string]*fnv1.ResourceSelector)
-		for name, requirement := range jsResponse.ExtraResourceRequirements {
-			extraResources[name] = requirement.ToResourceSelector()
-			log.Debug("Requesting ExtraResources", "name", name, "selector", extraResources[name])
+		selectors, err := requirementSelectors(jsResponse.ExtraResourceRequirements)
+		if err != nil {
+			return err
 		}
-
-		// Set requirements in the response
-		if rsp.Requirements == nil {
-			rsp.Requirements = &fnv1.Requirements{}
+		for name, selector := range selectors {
+			log.Debug("Requesting ExtraResources", "name", name, "selector", selector)
 		}
-		rsp.Requirements.Resources = extraResources
+		requireResources(rsp, selectors)
 	}
 
 	// Process conditions if present
@@ -346,9 +547,22 @@ func buildResponse(rsp *fnv1.RunFunctionResponse, jsResponse *JSResponse, resour
 		return err
 	}
 
-	// Set desired composite resource
-	if err := response.SetDesiredCompositeResource(rsp, resources.dxr); err != nil {
-		return errors.Wrapf(err, "cannot set desired composite resource in %T", rsp)
+	// Operations return an output instead of a desired composite resource
+	if resources.operation {
+		if jsResponse.Output != nil {
+			if err := response.SetOutput(rsp, jsResponse.Output); err != nil {
+				return errors.Wrapf(err, "cannot set operation output in %T", rsp)
+			}
+		}
+	} else {
+		if jsResponse.Output != nil {
+			log.Debug("Ignoring the output of a composition function")
+		}
+
+		// Set desired composite resource
+		if err := response.SetDesiredCompositeResource(rsp, resources.dxr); err != nil {
+			return errors.Wrapf(err, "cannot set desired composite resource in %T", rsp)
+		}
 	}
 
 	// Set desired composed resources
@@ -7,6 +7,9 @@ import (
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/resource"
 	"github.com/socialgouv/xfuncjs-server/pkg/conditions"
+	"github.com/socialgouv/xfuncjs-server/pkg/merge"
+	"github.com/socialgouv/xfuncjs-server/pkg/results"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
 )
 
 // JSResponse represents the response from a JavaScript function
@@ -17,60 +20,29 @@ type JSResponse struct {
 	Resources map[string]JSResource `json:"resources"`
 	// Events is a list of events to create
 	Events []CreateEvent `json:"events,omitempty"`
+	// Results is a list of results with an explicit severity. A Fatal result stops the
+	// processing of the desired state.
+	Results results.JSResults `json:"results,omitempty"`
 	// Conditions is a list of conditions to create
 	Conditions []conditions.ConditionResource `json:"conditions,omitempty"`
 	// Context is a map of context data to add to the response
 	Context map[string]interface{} `json:"context,omitempty"`
 	// ExtraResourceRequirements is a map of resource name to resource requirements
 	ExtraResourceRequirements map[string]ExtraResourceRequirement `json:"extraResourceRequirements,omitempty"`
-}
-
-// ExtraResourceRequirement defines a requirement for extra resources
-type ExtraResourceRequirement struct {
-	// APIVersion of the resource
-	APIVersion string `json:"apiVersion"`
-	// Kind of the resource
-	Kind string `json:"kind"`
-	// MatchLabels defines the labels to match the resource
-	MatchLabels map[string]string `json:"matchLabels,omitempty"`
-	// MatchName defines the name to match the resource
-	MatchName string `json:"matchName,omitempty"`
-	// Namespace optionally constrains the selector to a single namespace.
-	//
-	// When omitted, Crossplane will:
-	//   - match cluster-scoped resources, or
-	//   - match namespaced resources by labels across all namespaces
-	//     (per the v1beta1 ResourceSelector semantics).
-	Namespace string `json:"namespace,omitempty"`
-}
-
-// ToResourceSelector converts the ExtraResourceRequirement to a fnv1.ResourceSelector
-func (e *ExtraResourceRequirement) ToResourceSelector() *fnv1.ResourceSelector {
-	out := &fnv1.ResourceSelector{
-		ApiVersion: e.APIVersion,
-		Kind:       e.Kind,
-	}
-
-	if e.MatchName == "" && len(e.MatchLabels) > 0 {
-		out.Match = &fnv1.ResourceSelector_MatchLabels{
-			MatchLabels: &fnv1.MatchLabels{Labels: e.MatchLabels},
-		}
-	} else if e.MatchName != "" {
-		out.Match = &fnv1.ResourceSelector_MatchName{
-			MatchName: e.MatchName,
-		}
-	}
-
-	// Note: the v1 ResourceSelector type from function-sdk-go currently has no
-	// explicit Namespace field in this environment, so we can't forward
-	// ExtraResourceRequirement.Namespace yet. Leaving it here keeps the JSON
-	// contract ready for future SDKs without breaking compilation today.
-
-	if e.Namespace != "" {
-		out.Namespace = &e.Namespace
-	}
-
-	return out
+	// TTL is how long Crossplane may cache the response before calling the function again,
+	// as a duration string such as "30s". It is clamped to the bounds of the server.
+	TTL string `json:"ttl,omitempty"`
+	// Merge is the default merge strategy of the returned resources and composite,
+	// spec.merge of the input when not set
+	Merge *types.MergeStrategy `json:"merge,omitempty"`
+	// RemoveResources lists the desired composed resources of earlier pipeline steps to
+	// remove
+	RemoveResources []string `json:"removeResources,omitempty"`
+	// ConnectionSecret configures the Secret holding the connection details of a
+	// namespaced composite resource, spec.connectionSecret of the input when not set
+	ConnectionSecret *types.ConnectionSecret `json:"connectionSecret,omitempty"`
+	// Output is the output of an operation function, recorded in the Operation status
+	Output map[string]interface{} `json:"output,omitempty"`
 }
 
 // JSResource represents a resource in the JavaScript function response
@@ -79,8 +51,24 @@ type JSResource struct {
 	Resource json.RawMessage `json:"resource"`
 	// Ready indicates if the resource is ready
 	Ready *bool `json:"ready,omitempty"`
-	// ConnectionDetails contains connection details for the resource
-	ConnectionDetails map[string]string `json:"connectionDetails,omitempty"`
+	// ConnectionDetails contains connection details for the resource: values for the
+	// composite, observed keys or values for a composed resource
+	ConnectionDetails ConnectionDetails `json:"connectionDetails,omitempty"`
+	// Merge is how the resource is combined with the desired resource of the same name
+	// produced by earlier pipeline steps, the merge strategy of the response when not set
+	Merge *types.MergeStrategy `json:"merge,omitempty"`
+}
+
+// mergeStrategy returns the merge strategy of the resource, falling back to the one of
+// the response
+func (r *JSResource) mergeStrategy(defaults *types.MergeStrategy) types.MergeStrategy {
+	switch {
+	case r.Merge != nil:
+		return *r.Merge
+	case defaults != nil:
+		return *defaults
+	}
+	return types.MergeStrategy{}
 }
 
 // CreateEvent will create an event for the target(s).
@@ -146,14 +134,21 @@ func ProcessResources(rsp *fnv1.RunFunctionResponse, dxr *resource.Composite, de
 		}
 
 		// Set the desired composite resource object (spec, status, metadata, etc.)
-		dxr.Resource.Object = compositeMap
+		merged, err := merge.Apply(dxr.Resource.Object, compositeMap, jsResponse.Composite.mergeStrategy(jsResponse.Merge))
+		if err != nil {
+			return errors.Wrapf(err, "cannot merge composite resource")
+		}
+		dxr.Resource.Object = merged
 
 		// Apply connection details for the composite if provided
-		if len(jsResponse.Composite.ConnectionDetails) > 0 {
+		if len(jsResponse.Composite.ConnectionDetails.Keys) > 0 {
+			return errors.New("the connection details of the composite resource must be values")
+		}
+		if len(jsResponse.Composite.ConnectionDetails.Values) > 0 {
 			if dxr.ConnectionDetails == nil {
 				dxr.ConnectionDetails = make(map[string][]byte)
 			}
-			for k, v := range jsResponse.Composite.ConnectionDetails {
+			for k, v := range jsResponse.Composite.ConnectionDetails.Values {
 				dxr.ConnectionDetails[k] = []byte(v)
 			}
 		}
@@ -167,8 +162,20 @@ func ProcessResources(rsp *fnv1.RunFunctionResponse, dxr *resource.Composite, de
 			return errors.Wrapf(err, "error unmarshaling resource %s", name)
 		}
 
-		// Create a new desired composed resource
+		// Create a new desired composed resource, combined with the one of earlier steps
 		cd := resource.NewDesiredComposed()
+		strategy := res.mergeStrategy(jsResponse.Merge)
+		if err := strategy.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid merge strategy of resource %s", name)
+		}
+		if existing, ok := desired[resource.Name(name)]; ok && strategy.Strategy != "" && strategy.Strategy != types.MergeStrategyReplace {
+			merged, err := merge.Apply(existing.Resource.Object, resourceMap, strategy)
+			if err != nil {
+				return errors.Wrapf(err, "cannot merge resource %s", name)
+			}
+			resourceMap = merged
+			cd.Ready = existing.Ready
+		}
 		cd.Resource.Object = resourceMap
 
 		// Set ready status if provided
@@ -180,24 +187,18 @@ func ProcessResources(rsp *fnv1.RunFunctionResponse, dxr *resource.Composite, de
 			}
 		}
 
-		// Process connection details if provided
-		if len(res.ConnectionDetails) > 0 {
-			// For composed resources, we don't set connection details directly
-			// They will be collected by Crossplane from the actual resources
-			// But for the composite resource (XR), we can set connection details
-			if string(name) == "composite" {
-				if dxr.ConnectionDetails == nil {
-					dxr.ConnectionDetails = make(map[string][]byte)
-				}
-				for k, v := range res.ConnectionDetails {
-					dxr.ConnectionDetails[k] = []byte(v)
-				}
-			}
-		}
-
 		// Add the resource to the desired resources
 		desired[resource.Name(name)] = cd
 	}
 
+	// Remove the desired resources of earlier steps, which are also in the response
+	for _, name := range jsResponse.RemoveResources {
+		if _, returned := jsResponse.Resources[name]; returned {
+			return errors.Errorf("resource %s cannot be both returned and removed", name)
+		}
+		delete(desired, resource.Name(name))
+		delete(rsp.GetDesired().GetResources(), name)
+	}
+
 	return nil
 }
@@ -0,0 +1,75 @@
+package grpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func TestProcessResourcesMergesAndRemoves(t *testing.T) {
+	// Desired state of earlier pipeline steps
+	bucket := resource.NewDesiredComposed()
+	bucket.Resource.Object = map[string]interface{}{
+		"apiVersion": "s3.aws.upbound.io/v1beta1",
+		"kind":       "Bucket",
+		"metadata":   map[string]interface{}{"labels": map[string]interface{}{"team": "a"}},
+	}
+	bucket.Ready = resource.ReadyTrue
+	legacy := resource.NewDesiredComposed()
+	desired := map[resource.Name]*resource.DesiredComposed{"bucket": bucket, "legacy": legacy}
+	legacyStruct, err := structpb.NewStruct(map[string]interface{}{"kind": "ConfigMap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp := &fnv1.RunFunctionResponse{Desired: &fnv1.State{Resources: map[string]*fnv1.Resource{"legacy": {Resource: legacyStruct}}}}
+	dxr := &resource.Composite{Resource: composite.New()}
+	dxr.Resource.Object = map[string]interface{}{"spec": map[string]interface{}{"size": "small"}}
+
+	jsResponse := &JSResponse{
+		Merge: &types.MergeStrategy{Strategy: types.MergeStrategyMergePatch},
+		Composite: &JSResource{
+			Resource: json.RawMessage(`{"status":{"bucketName":"team-a"}}`),
+		},
+		Resources: map[string]JSResource{
+			"bucket": {Resource: json.RawMessage(`{"metadata":{"labels":{"env":"prod"}}}`)},
+			"queue": {
+				Resource: json.RawMessage(`{"kind":"Queue"}`),
+				Merge:    &types.MergeStrategy{Strategy: types.MergeStrategyReplace},
+			},
+		},
+		RemoveResources: []string{"legacy"},
+	}
+	if err := ProcessResources(rsp, dxr, desired, jsResponse); err != nil {
+		t.Fatalf("ProcessResources() error = %v", err)
+	}
+
+	labels := desired["bucket"].Resource.GetLabels()
+	if labels["team"] != "a" || labels["env"] != "prod" || desired["bucket"].Resource.GetKind() != "Bucket" {
+		t.Errorf("bucket = %v, want the merged resource", desired["bucket"].Resource.Object)
+	}
+	if desired["bucket"].Ready != resource.ReadyTrue {
+		t.Errorf("bucket ready = %v, want the readiness of the earlier step", desired["bucket"].Ready)
+	}
+	if size, _, _ := unstructured.NestedString(dxr.Resource.Object, "spec", "size"); size != "small" {
+		t.Errorf("composite = %v, want the merged composite", dxr.Resource.Object)
+	}
+	if _, ok := desired["legacy"]; ok {
+		t.Error("the removed resource is still desired")
+	}
+	if _, ok := rsp.GetDesired().GetResources()["legacy"]; ok {
+		t.Error("the removed resource is still in the response")
+	}
+
+	// A resource cannot be returned and removed
+	jsResponse.RemoveResources = []string{"queue"}
+	if err := ProcessResources(rsp, dxr, desired, jsResponse); err == nil {
+		t.Error("ProcessResources() accepted a resource both returned and removed")
+	}
+}
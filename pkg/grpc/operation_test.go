@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func newOperationRequest(t *testing.T) *fnv1.RunFunctionRequest {
+	t.Helper()
+	watched, err := structpb.NewStruct(map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web", "namespace": "team-a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fnv1.RunFunctionRequest{
+		Meta: &fnv1.RequestMeta{Tag: "operation"},
+		RequiredResources: map[string]*fnv1.Resources{
+			watchedResourceRequirement: {Items: []*fnv1.Resource{{Resource: watched}}},
+		},
+	}
+}
+
+func TestOperationInput(t *testing.T) {
+	resources, err := prepareResources(newOperationRequest(t))
+	if err != nil {
+		t.Fatalf("prepareResources() error = %v", err)
+	}
+	if !resources.operation {
+		t.Fatal("prepareResources() did not detect an operation")
+	}
+	if _, ok := resources.extraResources[watchedResourceRequirement]; ok {
+		t.Error("the watched resource is passed as an extra resource")
+	}
+
+	enhancedInput, err := createEnhancedInput(&types.XFuncJSInput{}, resources)
+	if err != nil {
+		t.Fatalf("createEnhancedInput() error = %v", err)
+	}
+	var input struct {
+		Mode            string                     `json:"mode"`
+		Observed        map[string]json.RawMessage `json:"observed"`
+		WatchedResource map[string]interface{}     `json:"watchedResource"`
+	}
+	if err := json.Unmarshal([]byte(enhancedInput), &input); err != nil {
+		t.Fatal(err)
+	}
+	if input.Mode != modeOperation {
+		t.Errorf("mode = %q, want %q", input.Mode, modeOperation)
+	}
+	if _, ok := input.Observed["composite"]; ok {
+		t.Error("the input of an operation has a composite resource")
+	}
+	if input.WatchedResource["kind"] != "Deployment" {
+		t.Errorf("watchedResource = %v, want the Deployment", input.WatchedResource)
+	}
+}
+
+func TestOperationResponse(t *testing.T) {
+	req := newOperationRequest(t)
+	resources, err := prepareResources(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.NewLogrusLogger("error", "text")
+
+	rsp := response.To(req, response.DefaultTTL)
+	jsResponse := &JSResponse{
+		Resources: map[string]JSResource{
+			"config": {Resource: json.RawMessage(`{"apiVersion":"v1","kind":"ConfigMap","metadata":{"name":"web"}}`)},
+		},
+		Output: map[string]interface{}{"restarted": true},
+	}
+	if err := buildResponse(rsp, jsResponse, resources, nil, log); err != nil {
+		t.Fatalf("buildResponse() error = %v", err)
+	}
+	if !rsp.GetOutput().AsMap()["restarted"].(bool) {
+		t.Errorf("output = %v, want the function output", rsp.GetOutput())
+	}
+	if rsp.GetDesired().GetComposite() != nil {
+		t.Error("the response of an operation has a desired composite resource")
+	}
+	if _, ok := rsp.GetDesired().GetResources()["config"]; !ok {
+		t.Error("the resources of an operation are not applied")
+	}
+
+	// Operations have no composite resource to update
+	jsResponse.Composite = &JSResource{Resource: json.RawMessage(`{}`)}
+	if err := buildResponse(response.To(req, response.DefaultTTL), jsResponse, resources, nil, log); err == nil {
+		t.Error("buildResponse() accepted a composite resource from an operation")
+	}
+}
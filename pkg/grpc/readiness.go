@@ -0,0 +1,23 @@
+package grpc
+
+import (
+	"github.com/crossplane/function-sdk-go/resource"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/readiness"
+)
+
+// setReadiness derives the readiness of the resources returned by the function without
+// ready from the matching observed resources. A resource not observed yet is not ready.
+// The resources of other steps of the pipeline are left as they are.
+func setReadiness(jsResponse *JSResponse, resources *resourceBundle, rules *readiness.Rules) {
+	for name, res := range jsResponse.Resources {
+		cd, ok := resources.desired[resource.Name(name)]
+		if !ok || res.Ready != nil || (cd.Ready != "" && cd.Ready != resource.ReadyUnspecified) {
+			continue
+		}
+		cd.Ready = resource.ReadyFalse
+		if observed, ok := resources.observed[resource.Name(name)]; ok && observed.Resource != nil && rules.Ready(observed.Resource.Object) {
+			cd.Ready = resource.ReadyTrue
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package grpc
+
+import (
+	"testing"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composed"
+	"k8s.io/utils/ptr"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/readiness"
+)
+
+func TestSetReadiness(t *testing.T) {
+	observed := func(object map[string]interface{}) resource.ObservedComposed {
+		u := composed.New()
+		u.Object = object
+		return resource.ObservedComposed{Resource: u}
+	}
+	explicit := resource.NewDesiredComposed()
+	explicit.Ready = resource.ReadyFalse
+	resources := &resourceBundle{
+		observed: map[resource.Name]resource.ObservedComposed{
+			"bucket": observed(map[string]interface{}{
+				"apiVersion": "s3.aws.upbound.io/v1beta1",
+				"kind":       "Bucket",
+				"status": map[string]interface{}{"conditions": []interface{}{
+					map[string]interface{}{"type": "Ready", "status": "True"},
+				}},
+			}),
+			"config":   observed(map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}),
+			"explicit": observed(map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}),
+			"unready":  observed(map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}),
+			"network":  observed(map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}),
+		},
+		desired: map[resource.Name]*resource.DesiredComposed{
+			"bucket":   resource.NewDesiredComposed(),
+			"config":   resource.NewDesiredComposed(),
+			"explicit": explicit,
+			"queue":    resource.NewDesiredComposed(),
+			"unready":  resource.NewDesiredComposed(),
+			// A resource of an earlier step of the pipeline, observed but not returned
+			"network": resource.NewDesiredComposed(),
+		},
+	}
+	jsResponse := &JSResponse{Resources: map[string]JSResource{
+		"bucket":   {},
+		"config":   {},
+		"explicit": {},
+		"queue":    {},
+		// Set by the function, the readiness is left to ProcessResources
+		"unready": {Ready: ptr.To(false)},
+	}}
+
+	setReadiness(jsResponse, resources, readiness.DefaultRules())
+
+	for name, want := range map[resource.Name]resource.Ready{
+		"bucket":   resource.ReadyTrue,
+		"config":   resource.ReadyTrue,
+		"explicit": resource.ReadyFalse,
+		"queue":    resource.ReadyFalse,
+		"unready":  "",
+		"network":  "",
+	} {
+		if got := resources.desired[name].Ready; got != want {
+			t.Errorf("%s ready = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/resource"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// ExtraResourceRequirement defines a requirement for extra resources
+type ExtraResourceRequirement struct {
+	// APIVersion of the resource
+	APIVersion string `json:"apiVersion"`
+	// Kind of the resource
+	Kind string `json:"kind"`
+	// MatchLabels defines the labels to match the resource
+	MatchLabels map[string]string `json:"matchLabels,omitempty"`
+	// MatchName defines the name to match the resource
+	MatchName string `json:"matchName,omitempty"`
+	// Namespace optionally constrains the selector to a single namespace.
+	//
+	// When omitted, Crossplane will:
+	//   - match cluster-scoped resources, or
+	//   - match namespaced resources by labels across all namespaces
+	//     (per the v1beta1 ResourceSelector semantics).
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// Validate checks that the requirement selects resources by name or by labels. A
+// selector matches one or the other, never both.
+func (e *ExtraResourceRequirement) Validate() error {
+	if e.APIVersion == "" || e.Kind == "" {
+		return errors.New("apiVersion and kind are required")
+	}
+	if e.MatchName != "" && len(e.MatchLabels) > 0 {
+		return errors.New("matchName and matchLabels are mutually exclusive")
+	}
+	if e.MatchName == "" && len(e.MatchLabels) == 0 {
+		return errors.New("one of matchName or matchLabels is required")
+	}
+	return nil
+}
+
+// ToResourceSelector converts the ExtraResourceRequirement to a fnv1.ResourceSelector.
+// The namespace is forwarded when set: without it, a name matches a cluster-scoped
+// resource and labels match resources of every namespace.
+func (e *ExtraResourceRequirement) ToResourceSelector() *fnv1.ResourceSelector {
+	out := &fnv1.ResourceSelector{
+		ApiVersion: e.APIVersion,
+		Kind:       e.Kind,
+	}
+
+	if e.MatchName != "" {
+		out.Match = &fnv1.ResourceSelector_MatchName{
+			MatchName: e.MatchName,
+		}
+	} else if len(e.MatchLabels) > 0 {
+		out.Match = &fnv1.ResourceSelector_MatchLabels{
+			MatchLabels: &fnv1.MatchLabels{Labels: e.MatchLabels},
+		}
+	}
+
+	if e.Namespace != "" {
+		namespace := e.Namespace
+		out.Namespace = &namespace
+	}
+
+	return out
+}
+
+// requirementSelectors validates the requirements returned by the function and converts
+// them to resource selectors
+func requirementSelectors(requirements map[string]ExtraResourceRequirement) (map[string]*fnv1.ResourceSelector, error) {
+	selectors := make(map[string]*fnv1.ResourceSelector, len(requirements))
+	for name, requirement := range requirements {
+		if name == sourceRequirement {
+			return nil, errors.Errorf("extra resource requirement name %s is reserved for the function source", sourceRequirement)
+		}
+		if err := requirement.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid extra resource requirement %s", name)
+		}
+		selectors[name] = requirement.ToResourceSelector()
+	}
+	return selectors, nil
+}
+
+// requireResources adds resource requirements to the response, keeping the ones already
+// set. Requirements are set in both the resources field and the deprecated
+// extra_resources field, read by Crossplane versions before v2.
+func requireResources(rsp *fnv1.RunFunctionResponse, selectors map[string]*fnv1.ResourceSelector) {
+	if len(selectors) == 0 {
+		return
+	}
+	if rsp.Requirements == nil {
+		rsp.Requirements = &fnv1.Requirements{}
+	}
+	if rsp.Requirements.Resources == nil {
+		rsp.Requirements.Resources = make(map[string]*fnv1.ResourceSelector, len(selectors))
+	}
+	if rsp.Requirements.ExtraResources == nil {
+		rsp.Requirements.ExtraResources = make(map[string]*fnv1.ResourceSelector, len(selectors))
+	}
+	for name, selector := range selectors {
+		rsp.Requirements.Resources[name] = selector
+		rsp.Requirements.ExtraResources[name] = selector
+	}
+}
+
+// requiredResources returns the resources supplied for the requirements of the previous
+// response: the required_resources of Crossplane v2, merged with the deprecated
+// extra_resources of older versions
+func requiredResources(req *fnv1.RunFunctionRequest) (map[string][]resource.Required, error) {
+	required, err := request.GetRequiredResources(req)
+	if err != nil {
+		return nil, err
+	}
+	extra, err := request.GetExtraResources(req)
+	if err != nil {
+		return nil, err
+	}
+	for name, resources := range extra {
+		if _, ok := required[name]; !ok {
+			required[name] = resources
+		}
+	}
+	return required, nil
+}
+
+// staticRequirements returns the selectors of the requirements exported by the user
+// module as `requirements`. The process of the function is created if needed. When it
+// cannot be created for a reason other than a defect of the function, nil is returned
+// so the execution retries it.
+func (f *Function) staticRequirements(ctx context.Context, input *types.XFuncJSInput) (map[string]*fnv1.ResourceSelector, error) {
+	raw, err := f.processManager.StaticRequirements(ctx, input)
+	if err != nil {
+		if functionDefect(err) != nil {
+			return nil, err
+		}
+		f.logger.WithField(logger.FieldError, err.Error()).Debug("Cannot read the requirements exported by the function")
+		return nil, nil
+	}
+
+	requirements := make(map[string]ExtraResourceRequirement, len(raw))
+	for name, data := range raw {
+		var requirement ExtraResourceRequirement
+		if err := json.Unmarshal(data, &requirement); err != nil {
+			return nil, errors.Wrapf(err, "invalid exported requirement %s", name)
+		}
+		requirements[name] = requirement
+	}
+	selectors, err := requirementSelectors(requirements)
+	return selectors, errors.Wrap(err, "invalid exported requirements")
+}
+
+// missingRequirements returns the names of the requirements Crossplane has not supplied
+// resources for yet. A requirement matching no resource is supplied with an empty list.
+func missingRequirements(selectors map[string]*fnv1.ResourceSelector, resources *resourceBundle) []string {
+	var missing []string
+	for name := range selectors {
+		if _, ok := resources.extraResources[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
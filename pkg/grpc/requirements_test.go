@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/utils/ptr"
+)
+
+func TestRequirementSelectors(t *testing.T) {
+	labels := map[string]string{"app": "web"}
+	tests := []struct {
+		name        string
+		requirement ExtraResourceRequirement
+		want        *fnv1.ResourceSelector
+		wantErr     bool
+	}{
+		{
+			name:        "cluster-scoped by name",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", Kind: "Namespace", MatchName: "team-a"},
+			want: &fnv1.ResourceSelector{
+				ApiVersion: "v1",
+				Kind:       "Namespace",
+				Match:      &fnv1.ResourceSelector_MatchName{MatchName: "team-a"},
+			},
+		},
+		{
+			name:        "namespaced by name",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", Kind: "ConfigMap", MatchName: "settings", Namespace: "team-a"},
+			want: &fnv1.ResourceSelector{
+				ApiVersion: "v1",
+				Kind:       "ConfigMap",
+				Match:      &fnv1.ResourceSelector_MatchName{MatchName: "settings"},
+				Namespace:  ptr.To("team-a"),
+			},
+		},
+		{
+			name:        "labels across all namespaces",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", Kind: "ConfigMap", MatchLabels: labels},
+			want: &fnv1.ResourceSelector{
+				ApiVersion: "v1",
+				Kind:       "ConfigMap",
+				Match:      &fnv1.ResourceSelector_MatchLabels{MatchLabels: &fnv1.MatchLabels{Labels: labels}},
+			},
+		},
+		{
+			name:        "labels in a namespace",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", Kind: "ConfigMap", MatchLabels: labels, Namespace: "team-a"},
+			want: &fnv1.ResourceSelector{
+				ApiVersion: "v1",
+				Kind:       "ConfigMap",
+				Match:      &fnv1.ResourceSelector_MatchLabels{MatchLabels: &fnv1.MatchLabels{Labels: labels}},
+				Namespace:  ptr.To("team-a"),
+			},
+		},
+		{
+			name:        "name and labels",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", Kind: "ConfigMap", MatchName: "settings", MatchLabels: labels},
+			wantErr:     true,
+		},
+		{
+			name:        "no match",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", Kind: "ConfigMap", Namespace: "team-a"},
+			wantErr:     true,
+		},
+		{
+			name:        "no kind",
+			requirement: ExtraResourceRequirement{APIVersion: "v1", MatchName: "settings"},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selectors, err := requirementSelectors(map[string]ExtraResourceRequirement{"r": tt.requirement})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("requirementSelectors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !proto.Equal(selectors["r"], tt.want) {
+				t.Errorf("requirementSelectors() = %v, want %v", selectors["r"], tt.want)
+			}
+		})
+	}
+
+	if _, err := requirementSelectors(map[string]ExtraResourceRequirement{sourceRequirement: tests[0].requirement}); err == nil {
+		t.Error("requirementSelectors() accepted the name reserved for the function source")
+	}
+}
+
+func TestRequireResourcesMerges(t *testing.T) {
+	rsp := &fnv1.RunFunctionResponse{}
+	source := &fnv1.ResourceSelector{ApiVersion: "v1", Kind: "ConfigMap", Match: &fnv1.ResourceSelector_MatchName{MatchName: "code"}}
+	settings := &fnv1.ResourceSelector{ApiVersion: "v1", Kind: "ConfigMap", Match: &fnv1.ResourceSelector_MatchName{MatchName: "settings"}}
+
+	requireResources(rsp, map[string]*fnv1.ResourceSelector{sourceRequirement: source})
+	requireResources(rsp, map[string]*fnv1.ResourceSelector{"settings": settings})
+
+	for field, selectors := range map[string]map[string]*fnv1.ResourceSelector{
+		"resources":       rsp.GetRequirements().GetResources(),
+		"extra_resources": rsp.GetRequirements().GetExtraResources(),
+	} {
+		if len(selectors) != 2 || selectors[sourceRequirement] != source || selectors["settings"] != settings {
+			t.Errorf("requirements.%s = %v, want both requirements", field, selectors)
+		}
+	}
+}
+
+func TestRequiredResourcesReadsLegacyField(t *testing.T) {
+	object := func(name string) *fnv1.Resources {
+		s, err := structpb.NewStruct(map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": name},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &fnv1.Resources{Items: []*fnv1.Resource{{Resource: s}}}
+	}
+	req := &fnv1.RunFunctionRequest{
+		RequiredResources: map[string]*fnv1.Resources{"settings": object("required")},
+		ExtraResources: map[string]*fnv1.Resources{
+			"settings": object("legacy"),
+			"legacy":   object("legacy"),
+		},
+	}
+
+	resources, err := requiredResources(req)
+	if err != nil {
+		t.Fatalf("requiredResources() error = %v", err)
+	}
+	if got := resources["settings"][0].Resource.GetName(); got != "required" {
+		t.Errorf("settings = %s, want the required_resources entry", got)
+	}
+	if len(resources["legacy"]) != 1 {
+		t.Errorf("requiredResources() = %v, want the extra_resources entry", resources)
+	}
+}
@@ -0,0 +1,142 @@
+package grpc
+
+import (
+	"encoding/json"
+	"testing"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/utils/ptr"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/results"
+)
+
+func TestResults(t *testing.T) {
+	req := newOperationRequest(t)
+	resources, err := prepareResources(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logger.NewLogrusLogger("error", "text")
+
+	var jsResponse JSResponse
+	err = json.Unmarshal([]byte(`{
+		"resources": {"config": {"resource": {"apiVersion": "v1", "kind": "ConfigMap"}}},
+		"results": [
+			{"severity": "Warning", "reason": "Deprecated", "message": "spec.size is deprecated"},
+			{"message": "bucket created", "target": "CompositeAndClaim"}
+		]
+	}`), &jsResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsp := response.To(req, response.DefaultTTL)
+	if err := buildResponse(rsp, &jsResponse, resources, nil, log); err != nil {
+		t.Fatalf("buildResponse() error = %v", err)
+	}
+	if len(rsp.GetResults()) != 2 {
+		t.Fatalf("results = %v, want 2 results", rsp.GetResults())
+	}
+	warning, normal := rsp.GetResults()[0], rsp.GetResults()[1]
+	if warning.GetSeverity() != fnv1.Severity_SEVERITY_WARNING || warning.GetReason() != "Deprecated" || warning.GetTarget() != fnv1.Target_TARGET_COMPOSITE {
+		t.Errorf("result = %v, want a Warning on the composite", warning)
+	}
+	if normal.GetSeverity() != fnv1.Severity_SEVERITY_NORMAL || normal.GetTarget() != fnv1.Target_TARGET_COMPOSITE_AND_CLAIM {
+		t.Errorf("result = %v, want a Normal result on the composite and claim", normal)
+	}
+	if _, ok := rsp.GetDesired().GetResources()["config"]; !ok {
+		t.Error("non-fatal results stopped the processing of the desired state")
+	}
+
+	// A Fatal result leaves the desired state of the request unchanged
+	jsResponse.Results = results.JSResults{{Severity: ptr.To(results.SeverityFatal), Message: "quota exceeded"}}
+	rsp = response.To(req, response.DefaultTTL)
+	if err := buildResponse(rsp, &jsResponse, resources, nil, log); err != nil {
+		t.Fatalf("buildResponse() error = %v", err)
+	}
+	if rsp.GetResults()[0].GetSeverity() != fnv1.Severity_SEVERITY_FATAL || rsp.GetResults()[0].GetMessage() != "quota exceeded" {
+		t.Errorf("results = %v, want the Fatal result", rsp.GetResults())
+	}
+	if len(rsp.GetDesired().GetResources()) != 0 {
+		t.Error("the desired state of a Fatal result was applied")
+	}
+
+	// Invalid severities are rejected
+	jsResponse.Results = results.JSResults{{Severity: ptr.To(results.Severity("Error")), Message: "boom"}}
+	if err := buildResponse(response.To(req, response.DefaultTTL), &jsResponse, resources, nil, log); err == nil {
+		t.Error("buildResponse() accepted an invalid severity")
+	}
+}
+
+func TestFatalResultComposition(t *testing.T) {
+	object := func(fields map[string]interface{}) *structpb.Struct {
+		s, err := structpb.NewStruct(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	req := &fnv1.RunFunctionRequest{
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{Resource: object(map[string]interface{}{
+				"apiVersion": "example.org/v1",
+				"kind":       "Database",
+				"metadata":   map[string]interface{}{"name": "db", "namespace": "team-a"},
+			})},
+			Resources: map[string]*fnv1.Resource{
+				"instance": {
+					Resource:          object(map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap"}),
+					ConnectionDetails: map[string][]byte{"password": []byte("s3cr3t")},
+				},
+			},
+		},
+		Desired: &fnv1.State{
+			// A resource of an earlier step of the pipeline
+			Resources: map[string]*fnv1.Resource{
+				"network": {Resource: object(map[string]interface{}{"apiVersion": "v1", "kind": "Service"})},
+			},
+		},
+	}
+	resources, err := prepareResources(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.operation {
+		t.Fatal("prepareResources() detected an operation")
+	}
+
+	var jsResponse JSResponse
+	err = json.Unmarshal([]byte(`{
+		"resources": {
+			"instance": {"resource": {"apiVersion": "v1", "kind": "ConfigMap"}, "connectionDetails": ["password"]},
+			"bucket": {"resource": {"apiVersion": "s3.aws.upbound.io/v1beta1", "kind": "Bucket"}, "ready": true}
+		},
+		"results": [{"severity": "Fatal", "message": "quota exceeded"}]
+	}`), &jsResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The response shares the desired state of the request
+	desired := proto.Clone(req.GetDesired())
+	rsp := response.To(req, response.DefaultTTL)
+	if err := buildResponse(rsp, &jsResponse, resources, nil, logger.NewLogrusLogger("error", "text")); err != nil {
+		t.Fatalf("buildResponse() error = %v", err)
+	}
+	if len(rsp.GetResults()) != 1 || rsp.GetResults()[0].GetSeverity() != fnv1.Severity_SEVERITY_FATAL {
+		t.Errorf("results = %v, want the Fatal result", rsp.GetResults())
+	}
+	if !proto.Equal(rsp.GetDesired(), desired) {
+		t.Errorf("desired = %v, want the desired state of the request %v", rsp.GetDesired(), desired)
+	}
+	if _, ok := resources.desired[connectionSecretResource]; ok {
+		t.Error("the connection secret of a Fatal result was generated")
+	}
+	if len(resources.dxr.ConnectionDetails) != 0 {
+		t.Errorf("composite connection details = %v, want none after a Fatal result", resources.dxr.ConnectionDetails)
+	}
+}
@@ -10,6 +10,9 @@ import (
 
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
 	"github.com/socialgouv/xfuncjs-server/pkg/node"
+	"github.com/socialgouv/xfuncjs-server/pkg/readiness"
+	"github.com/socialgouv/xfuncjs-server/pkg/signature"
+	"github.com/socialgouv/xfuncjs-server/pkg/source"
 )
 
 // Server is the gRPC server for the XFuncJS service
@@ -33,6 +36,33 @@ func (s *Server) SetLogCrossplaneIO(enabled bool) {
 	s.function.SetLogCrossplaneIO(enabled)
 }
 
+// SetFailureLogs configures the function output attached to failed executions
+func (s *Server) SetFailureLogs(lines, maxBytes int, mode string) {
+	s.function.SetFailureLogs(lines, maxBytes, mode)
+}
+
+// SetRemoteSources sets the fetcher of the sources referenced with spec.source.remote
+func (s *Server) SetRemoteSources(fetcher *source.Fetcher) {
+	s.function.SetRemoteSources(fetcher)
+}
+
+// SetSignatureVerifier sets the verifier of spec.source.signature
+func (s *Server) SetSignatureVerifier(verifier *signature.Verifier) {
+	s.function.SetSignatureVerifier(verifier)
+}
+
+// SetResponseTTL sets the default TTL of the responses and the bounds of the TTL requested
+// by functions
+func (s *Server) SetResponseTTL(ttl, minTTL, maxTTL time.Duration) {
+	s.function.SetResponseTTL(ttl, minTTL, maxTTL)
+}
+
+// SetReadinessRules sets the rules deriving the readiness of the composed resources a
+// function leaves unset
+func (s *Server) SetReadinessRules(rules *readiness.Rules) {
+	s.function.SetReadinessRules(rules)
+}
+
 // SetNodeHealthCheckConfig sets the health check configuration for the Node.js HTTP server
 func (s *Server) SetNodeHealthCheckConfig(wait, interval time.Duration) {
 	s.function.SetNodeHealthCheckConfig(wait, interval)
@@ -0,0 +1,118 @@
+package grpc
+
+import (
+	"encoding/base64"
+
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// sourceRequirement is the name of the required resource holding the function source
+const sourceRequirement = "xfuncjs-source"
+
+// Keys of the ConfigMap or Secret data read into the function source
+const (
+	sourceKeyInline      = "inline"
+	sourceKeyYarnLock    = "yarnLock"
+	sourceKeyPackageLock = "packageLock"
+	sourceKeyPnpmLock    = "pnpmLock"
+	sourceKeyTsConfig    = "tsConfig"
+)
+
+// sourceSelector returns the selector of the ConfigMap or Secret holding the function
+// source, or nil when the source is in the spec
+func sourceSelector(input *types.XFuncJSInput, resources *resourceBundle) (*fnv1.ResourceSelector, error) {
+	kind, ref := input.SourceObject()
+	if ref == nil {
+		return nil, nil
+	}
+
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = resources.oxr.Resource.GetNamespace()
+	}
+	if namespace == "" {
+		return nil, errors.Errorf("the namespace of the source %s %s is required for a cluster-scoped composite resource or an operation", kind, ref.Name)
+	}
+
+	return &fnv1.ResourceSelector{
+		ApiVersion: "v1",
+		Kind:       kind,
+		Match:      &fnv1.ResourceSelector_MatchName{MatchName: ref.Name},
+		Namespace:  &namespace,
+	}, nil
+}
+
+// resolveSource materializes the function source from the ConfigMap or Secret supplied by
+// Crossplane. It returns false when the object has not been requested yet. The resolved
+// input no longer references the object, so its hash only depends on the content.
+func resolveSource(input *types.XFuncJSInput, resources *resourceBundle) (bool, error) {
+	kind, ref := input.SourceObject()
+	if ref == nil {
+		return true, nil
+	}
+
+	required, requested := resources.extraResources[sourceRequirement]
+	if !requested {
+		return false, nil
+	}
+	// The source is not an extra resource of the function
+	delete(resources.extraResources, sourceRequirement)
+	if len(required) == 0 {
+		return false, errors.Errorf("source %s %s not found", kind, ref.Name)
+	}
+
+	data, err := sourceObjectData(required[0].Resource)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot read source %s %s", kind, ref.Name)
+	}
+	if data[sourceKeyInline] == "" {
+		return false, errors.Errorf("source %s %s has no %q key", kind, ref.Name, sourceKeyInline)
+	}
+
+	source := &input.Spec.Source
+	source.Inline = data[sourceKeyInline]
+	source.YarnLock = data[sourceKeyYarnLock]
+	source.PackageLock = data[sourceKeyPackageLock]
+	source.PnpmLock = data[sourceKeyPnpmLock]
+	if source.TsConfig == "" {
+		source.TsConfig = data[sourceKeyTsConfig]
+	}
+	source.ConfigMapRef = nil
+	source.SecretRef = nil
+
+	return true, errors.Wrap(input.Validate(), "invalid function source")
+}
+
+// sourceObjectData returns the decoded data of a ConfigMap or Secret
+func sourceObjectData(obj *unstructured.Unstructured) (map[string]string, error) {
+	data, _, err := unstructured.NestedStringMap(obj.Object, "data")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = make(map[string]string)
+	}
+
+	// Secret data and ConfigMap binaryData are base64 encoded
+	encoded := "binaryData"
+	if obj.GetKind() == "Secret" {
+		encoded = "data"
+	}
+	binary, _, err := unstructured.NestedStringMap(obj.Object, encoded)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range binary {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot decode key %s", key)
+		}
+		data[key] = string(decoded)
+	}
+
+	return data, nil
+}
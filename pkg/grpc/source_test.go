@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func newSourceBundle(namespace string) *resourceBundle {
+	oxr := composite.New()
+	oxr.SetNamespace(namespace)
+	return &resourceBundle{
+		oxr:            &resource.Composite{Resource: oxr},
+		extraResources: map[string][]resource.Required{},
+	}
+}
+
+func TestSourceFromSecret(t *testing.T) {
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.SecretRef = &types.SourceRef{Name: "my-function"}
+	if err := input.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	resources := newSourceBundle("team-a")
+
+	selector, err := sourceSelector(input, resources)
+	if err != nil {
+		t.Fatalf("sourceSelector() error = %v", err)
+	}
+	if selector.GetKind() != "Secret" || selector.GetMatchName() != "my-function" || selector.GetNamespace() != "team-a" {
+		t.Errorf("sourceSelector() = %v, want the Secret team-a/my-function", selector)
+	}
+
+	// First call: the object has not been requested yet
+	if resolved, err := resolveSource(input, resources); resolved || err != nil {
+		t.Fatalf("resolveSource() = %v, %v, want unresolved", resolved, err)
+	}
+
+	secret := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"data": map[string]interface{}{
+			"inline":   base64.StdEncoding.EncodeToString([]byte("export default () => ({})")),
+			"yarnLock": base64.StdEncoding.EncodeToString([]byte("# yarn lockfile")),
+		},
+	}}
+	resources.extraResources[sourceRequirement] = []resource.Required{{Resource: secret}}
+
+	resolved, err := resolveSource(input, resources)
+	if !resolved || err != nil {
+		t.Fatalf("resolveSource() = %v, %v, want resolved", resolved, err)
+	}
+	if input.Spec.Source.Inline != "export default () => ({})" || input.Spec.Source.YarnLock != "# yarn lockfile" {
+		t.Errorf("source = %+v, want the decoded Secret data", input.Spec.Source)
+	}
+	if _, ref := input.SourceObject(); ref != nil {
+		t.Error("resolved source still references the Secret")
+	}
+	if _, ok := resources.extraResources[sourceRequirement]; ok {
+		t.Error("the source Secret is passed to the function as an extra resource")
+	}
+}
+
+func TestSourceFromConfigMapErrors(t *testing.T) {
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.ConfigMapRef = &types.SourceRef{Name: "my-function"}
+
+	// A cluster-scoped composite resource must name the namespace
+	if _, err := sourceSelector(input, newSourceBundle("")); err == nil {
+		t.Error("sourceSelector() accepted a reference without namespace")
+	}
+
+	// The object was requested but does not exist
+	resources := newSourceBundle("team-a")
+	resources.extraResources[sourceRequirement] = []resource.Required{}
+	if _, err := resolveSource(input, resources); err == nil {
+		t.Error("resolveSource() accepted a missing ConfigMap")
+	}
+
+	// The object has no code
+	configMap := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"data":       map[string]interface{}{"yarnLock": "# yarn lockfile"},
+	}}
+	resources.extraResources[sourceRequirement] = []resource.Required{{Resource: configMap}}
+	if _, err := resolveSource(input, resources); err == nil {
+		t.Error("resolveSource() accepted a ConfigMap without code")
+	}
+
+	// The code comes from the object only
+	input.Spec.Source.Inline = "export default () => ({})"
+	if err := input.Validate(); err == nil {
+		t.Error("Validate() accepted source.inline with source.configMapRef")
+	}
+}
@@ -0,0 +1,39 @@
+package grpc
+
+import (
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+// Defaults of the response TTL and of its bounds, overridden by SetResponseTTL
+const (
+	defaultResponseTTL    = response.DefaultTTL
+	defaultResponseTTLMin = 10 * time.Second
+	defaultResponseTTLMax = 24 * time.Hour
+)
+
+// setResponseTTL sets the TTL requested by the function, a duration string such as
+// "30s" or "1h", clamped to the bounds of the server. The default TTL is kept when the
+// function requests none.
+func (f *Function) setResponseTTL(rsp *fnv1.RunFunctionResponse, requested string, log logger.Logger) error {
+	if requested == "" {
+		return nil
+	}
+	ttl, err := time.ParseDuration(requested)
+	if err != nil {
+		return errors.Wrapf(err, "invalid ttl %q", requested)
+	}
+
+	clamped := min(max(ttl, f.responseTTLMin), f.responseTTLMax)
+	if clamped != ttl {
+		log.Debug("Clamping the TTL requested by the function", "requested", ttl.String(), "ttl", clamped.String())
+	}
+	rsp.Meta.Ttl = durationpb.New(clamped)
+	return nil
+}
@@ -0,0 +1,40 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/response"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+func TestSetResponseTTL(t *testing.T) {
+	log := logger.NewLogrusLogger("error", "text")
+	f := NewFunction(nil, log)
+	f.SetResponseTTL(time.Minute, 30*time.Second, time.Hour)
+
+	tests := []struct {
+		requested string
+		want      time.Duration
+	}{
+		{"", time.Minute},
+		{"5m", 5 * time.Minute},
+		{"1s", 30 * time.Second},
+		{"48h", time.Hour},
+	}
+	for _, tt := range tests {
+		rsp := response.To(&fnv1.RunFunctionRequest{}, f.responseTTL)
+		if err := f.setResponseTTL(rsp, tt.requested, log); err != nil {
+			t.Fatalf("setResponseTTL(%q) error = %v", tt.requested, err)
+		}
+		if got := rsp.GetMeta().GetTtl().AsDuration(); got != tt.want {
+			t.Errorf("setResponseTTL(%q) = %v, want %v", tt.requested, got, tt.want)
+		}
+	}
+
+	if err := f.setResponseTTL(response.To(&fnv1.RunFunctionRequest{}, f.responseTTL), "soon", log); err == nil {
+		t.Error("setResponseTTL() accepted an invalid duration")
+	}
+}
@@ -0,0 +1,216 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/node"
+)
+
+// adminRestartTimeout bounds how long a single restart requested through the admin API may take
+const adminRestartTimeout = 15 * time.Minute
+
+// EnableAdmin enables the process administration endpoints under /admin.
+// Every admin request must carry the given token as "Authorization: Bearer <token>".
+// rollStagger is the default delay between two restarts when rolling all processes.
+// It must be called before Start.
+func (s *Server) EnableAdmin(token string, rollStagger time.Duration) {
+	s.adminToken = token
+	s.adminRollStagger = rollStagger
+}
+
+// registerAdminRoutes registers the administration endpoints on the given mux
+func (s *Server) registerAdminRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /admin/processes", s.requireAdminToken(s.listProcessesHandler))
+	mux.Handle("DELETE /admin/processes/{hash}", s.requireAdminToken(s.killProcessHandler))
+	mux.Handle("POST /admin/processes/{hash}/restart", s.requireAdminToken(s.restartProcessHandler))
+	mux.Handle("PUT /admin/processes/{hash}/log-level", s.requireAdminToken(s.setLogLevelHandler))
+	mux.Handle("GET /admin/install-queue", s.requireAdminToken(s.installQueueHandler))
+	mux.Handle("GET /admin/workspaces", s.requireAdminToken(s.workspacesHandler))
+	mux.Handle("POST /admin/workspaces/refresh", s.requireAdminToken(s.refreshWorkspacesHandler))
+	mux.Handle("POST /admin/gc", s.requireAdminToken(s.gcHandler))
+	mux.Handle("POST /admin/roll", s.requireAdminToken(s.rollHandler))
+
+	s.logger.Info("Process administration API enabled on /admin")
+}
+
+// requireAdminToken wraps a handler with bearer token authentication
+func (s *Server) requireAdminToken(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			s.logger.WithField("path", r.URL.Path).
+				WithField("remote_addr", r.RemoteAddr).
+				Warn("Rejected unauthenticated admin request")
+			writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid admin token"))
+			return
+		}
+		next(w, r)
+	})
+}
+
+// listProcessesHandler lists every managed Node.js process
+func (s *Server) listProcessesHandler(w http.ResponseWriter, r *http.Request) {
+	processes := s.processManager.ListProcesses()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"processes": processes,
+		"count":     len(processes),
+	})
+}
+
+// killProcessHandler terminates one process identified by spec hash or hash prefix
+func (s *Server) killProcessHandler(w http.ResponseWriter, r *http.Request) {
+	specHash, err := s.processManager.KillProcess(r.PathValue("hash"))
+	if err != nil {
+		writeJSONError(w, processErrorStatus(err), err)
+		return
+	}
+
+	s.logger.WithField(logger.FieldCodeHash, specHash[:8]).Info("Process killed through admin API")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"specHash": specHash,
+		"status":   "killed",
+	})
+}
+
+// restartProcessHandler restarts one process identified by spec hash or hash prefix
+func (s *Server) restartProcessHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), adminRestartTimeout)
+	defer cancel()
+
+	specHash, err := s.processManager.RestartProcess(ctx, r.PathValue("hash"))
+	if err != nil {
+		writeJSONError(w, processErrorStatus(err), err)
+		return
+	}
+
+	s.logger.WithField(logger.FieldCodeHash, specHash[:8]).Info("Process restarted through admin API")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"specHash": specHash,
+		"status":   "restarted",
+	})
+}
+
+// setLogLevelHandler changes the log level of one running process.
+// The body is {"level": "<trace|debug|info|warn|error|fatal|silent>"}.
+func (s *Server) setLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Level string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, errors.New("body must be a JSON object with a level field"))
+		return
+	}
+
+	specHash, err := s.processManager.SetProcessLogLevel(r.Context(), r.PathValue("hash"), body.Level)
+	if err != nil {
+		writeJSONError(w, processErrorStatus(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"specHash": specHash,
+		"logLevel": body.Level,
+	})
+}
+
+// installQueueHandler reports the dependency install queue: depth, wait times and active installs
+func (s *Server) installQueueHandler(w http.ResponseWriter, r *http.Request) {
+	stats, enabled := s.processManager.InstallQueueStats()
+	if !enabled {
+		writeJSONError(w, http.StatusNotFound, errors.New("dependency installs are not enabled"))
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// workspacesHandler lists the workspace packages bundled in the image with their versions
+func (s *Server) workspacesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.processManager.WorkspaceStatus())
+}
+
+// refreshWorkspacesHandler loads the workspace packages again from the manifest or yarn
+func (s *Server) refreshWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := s.processManager.RefreshWorkspaces()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.logger.WithField("packages", len(status.Packages)).Info("Workspace packages refreshed through admin API")
+	writeJSON(w, http.StatusOK, status)
+}
+
+// gcHandler triggers a garbage collection pass
+func (s *Server) gcHandler(w http.ResponseWriter, r *http.Request) {
+	before := len(s.processManager.ListProcesses())
+	s.processManager.CollectGarbage()
+	after := len(s.processManager.ListProcesses())
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"collected": before - after,
+		"remaining": after,
+	})
+}
+
+// rollHandler restarts every process in the background, one at a time.
+// The delay between restarts defaults to the configured stagger and can be
+// overridden with the "stagger" query parameter (Go duration, e.g. "10s").
+func (s *Server) rollHandler(w http.ResponseWriter, r *http.Request) {
+	stagger := s.adminRollStagger
+	if raw := r.URL.Query().Get("stagger"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			writeJSONError(w, http.StatusBadRequest, errors.New("stagger must be a non-negative duration"))
+			return
+		}
+		stagger = parsed
+	}
+
+	processes := s.processManager.ListProcesses()
+
+	// Rolling can take a long time, so it is detached from the HTTP request
+	go func() {
+		if _, err := s.processManager.RollProcesses(context.Background(), stagger); err != nil {
+			s.logger.WithField(logger.FieldError, err.Error()).Error("Failed to roll Node.js processes")
+		}
+	}()
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"status":    "rolling",
+		"processes": len(processes),
+		"stagger":   stagger.String(),
+	})
+}
+
+// processErrorStatus maps process lookup errors to HTTP status codes
+func processErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, node.ErrProcessNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, node.ErrAmbiguousProcess):
+		return http.StatusConflict
+	case errors.Is(err, node.ErrInvalidLogLevel):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes an error as a JSON response with the given status code
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/node"
+)
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	log := logger.NewLogrusLogger("error", "text")
+	pm, err := node.NewProcessManager(time.Hour, time.Hour, t.TempDir(), log)
+	if err != nil {
+		t.Fatalf("NewProcessManager: %v", err)
+	}
+
+	s := NewServer(pm, log)
+	s.EnableAdmin("s3cr3t", time.Second)
+	mux := http.NewServeMux()
+	s.registerAdminRoutes(mux)
+
+	tcs := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		want   int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/admin/processes", want: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodGet, path: "/admin/processes", auth: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "list", method: http.MethodGet, path: "/admin/processes", auth: "Bearer s3cr3t", want: http.StatusOK},
+		{name: "install queue disabled", method: http.MethodGet, path: "/admin/install-queue", auth: "Bearer s3cr3t", want: http.StatusNotFound},
+		{name: "gc", method: http.MethodPost, path: "/admin/gc", auth: "Bearer s3cr3t", want: http.StatusOK},
+		{name: "kill unknown", method: http.MethodDelete, path: "/admin/processes/0123456789abcdef", auth: "Bearer s3cr3t", want: http.StatusNotFound},
+		{name: "bad stagger", method: http.MethodPost, path: "/admin/roll?stagger=soon", auth: "Bearer s3cr3t", want: http.StatusBadRequest},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("%s %s = %d, want %d (body: %s)", tc.method, tc.path, rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
@@ -16,9 +16,11 @@ var ErrServerClosed = errors.New("http: server closed")
 
 // Server is the HTTP server for health checks
 type Server struct {
-	server         *http.Server
-	processManager *node.ProcessManager
-	logger         logger.Logger
+	server           *http.Server
+	processManager   *node.ProcessManager
+	logger           logger.Logger
+	adminToken       string        // Bearer token for the admin API; empty disables it
+	adminRollStagger time.Duration // Default delay between restarts when rolling processes
 }
 
 // NewServer creates a new HTTP server for health checks
@@ -36,6 +38,11 @@ func (s *Server) Start(address string) error {
 	// Register the /healthz endpoint for Kubernetes
 	mux.HandleFunc("/healthz", s.healthzHandler)
 
+	// Register the process administration endpoints when enabled
+	if s.adminToken != "" {
+		s.registerAdminRoutes(mux)
+	}
+
 	s.server = &http.Server{
 		Addr:    address,
 		Handler: mux,
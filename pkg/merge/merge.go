@@ -0,0 +1,122 @@
+// Package merge combines the resources returned by a function with the desired resources
+// produced by earlier pipeline steps.
+package merge
+
+import (
+	"reflect"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// Apply combines patch with base according to strategy and returns the result. base is
+// not modified. A nil base is treated as an empty object.
+func Apply(base, patch map[string]interface{}, strategy types.MergeStrategy) (map[string]interface{}, error) {
+	if err := strategy.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch strategy.Strategy {
+	case types.MergeStrategyMergePatch:
+		return mergeObjects(base, patch, nil), nil
+	case types.MergeStrategyDeepMerge:
+		lists := strategy.Lists
+		if lists == "" {
+			lists = types.ListStrategyReplace
+		}
+		return mergeObjects(base, patch, listMerger(lists)), nil
+	default:
+		return patch, nil
+	}
+}
+
+// mergeLists combines two lists
+type mergeLists func(base, patch []interface{}) []interface{}
+
+// mergeObjects merges patch into a copy of base: null removes a field, objects are merged
+// recursively and lists are combined by lists, or replaced verbatim when it is nil, as in
+// a JSON merge patch (RFC 7396)
+func mergeObjects(base, patch map[string]interface{}, lists mergeLists) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(patch))
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for k, v := range patch {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+		switch patchValue := v.(type) {
+		case map[string]interface{}:
+			baseValue, _ := result[k].(map[string]interface{})
+			result[k] = mergeObjects(baseValue, patchValue, lists)
+		case []interface{}:
+			if lists != nil {
+				baseValue, _ := result[k].([]interface{})
+				result[k] = lists(baseValue, patchValue)
+			} else {
+				result[k] = patchValue
+			}
+		default:
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// withoutNulls returns the value of a patch without the null fields of its objects, as
+// when it is merged into an empty object
+func withoutNulls(list []interface{}, lists mergeLists) []interface{} {
+	result := make([]interface{}, len(list))
+	for i, item := range list {
+		if object, ok := item.(map[string]interface{}); ok {
+			item = mergeObjects(nil, object, lists)
+		}
+		result[i] = item
+	}
+	return result
+}
+
+// listMerger returns the function combining lists with a list strategy. Unlike a JSON
+// merge patch, the null fields of the objects of the patch lists are removed.
+func listMerger(strategy string) mergeLists {
+	var lists mergeLists
+	switch strategy {
+	case types.ListStrategyAppend:
+		lists = func(base, patch []interface{}) []interface{} {
+			return append(append([]interface{}{}, base...), withoutNulls(patch, lists)...)
+		}
+	case types.ListStrategyMergeByName:
+		lists = func(base, patch []interface{}) []interface{} {
+			result := append([]interface{}{}, base...)
+			for _, item := range patch {
+				if i := indexByName(result, item); i >= 0 {
+					result[i] = mergeObjects(result[i].(map[string]interface{}), item.(map[string]interface{}), lists)
+					continue
+				}
+				result = append(result, withoutNulls([]interface{}{item}, lists)...)
+			}
+			return result
+		}
+	default:
+		lists = func(_, patch []interface{}) []interface{} {
+			return withoutNulls(patch, lists)
+		}
+	}
+	return lists
+}
+
+// indexByName returns the index of the object of list with the same name field as item,
+// or -1 when item has no name or no object matches
+func indexByName(list []interface{}, item interface{}) int {
+	object, ok := item.(map[string]interface{})
+	if !ok || object["name"] == nil {
+		return -1
+	}
+	for i, candidate := range list {
+		if candidateObject, ok := candidate.(map[string]interface{}); ok && reflect.DeepEqual(candidateObject["name"], object["name"]) {
+			return i
+		}
+	}
+	return -1
+}
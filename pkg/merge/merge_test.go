@@ -0,0 +1,106 @@
+package merge
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func object(t *testing.T, s string) map[string]interface{} {
+	t.Helper()
+	var o map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &o); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+func TestApply(t *testing.T) {
+	const base = `{
+		"metadata": {"name": "web", "labels": {"team": "a", "tier": "front"}},
+		"spec": {"containers": [{"name": "app", "image": "app:1"}, {"name": "proxy", "image": "proxy:1"}]}
+	}`
+	const patch = `{
+		"metadata": {"labels": {"tier": null, "env": "prod"}},
+		"spec": {"containers": [{"name": "app", "image": "app:2"}, {"name": "sidecar", "image": "log:1", "args": null}]}
+	}`
+
+	tests := []struct {
+		name     string
+		strategy types.MergeStrategy
+		want     string
+	}{
+		{
+			name:     "replace",
+			strategy: types.MergeStrategy{},
+			want:     patch,
+		},
+		{
+			name:     "merge patch",
+			strategy: types.MergeStrategy{Strategy: types.MergeStrategyMergePatch},
+			want: `{
+				"metadata": {"name": "web", "labels": {"team": "a", "env": "prod"}},
+				"spec": {"containers": [{"name": "app", "image": "app:2"}, {"name": "sidecar", "image": "log:1", "args": null}]}
+			}`,
+		},
+		{
+			name:     "deep merge replacing lists",
+			strategy: types.MergeStrategy{Strategy: types.MergeStrategyDeepMerge},
+			want: `{
+				"metadata": {"name": "web", "labels": {"team": "a", "env": "prod"}},
+				"spec": {"containers": [{"name": "app", "image": "app:2"}, {"name": "sidecar", "image": "log:1"}]}
+			}`,
+		},
+		{
+			name:     "deep merge appending lists",
+			strategy: types.MergeStrategy{Strategy: types.MergeStrategyDeepMerge, Lists: types.ListStrategyAppend},
+			want: `{
+				"metadata": {"name": "web", "labels": {"team": "a", "env": "prod"}},
+				"spec": {"containers": [
+					{"name": "app", "image": "app:1"}, {"name": "proxy", "image": "proxy:1"},
+					{"name": "app", "image": "app:2"}, {"name": "sidecar", "image": "log:1"}
+				]}
+			}`,
+		},
+		{
+			name:     "deep merge by name",
+			strategy: types.MergeStrategy{Strategy: types.MergeStrategyDeepMerge, Lists: types.ListStrategyMergeByName},
+			want: `{
+				"metadata": {"name": "web", "labels": {"team": "a", "env": "prod"}},
+				"spec": {"containers": [
+					{"name": "app", "image": "app:2"}, {"name": "proxy", "image": "proxy:1"}, {"name": "sidecar", "image": "log:1"}
+				]}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := object(t, base)
+			got, err := Apply(b, object(t, patch), tt.strategy)
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if want := object(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("Apply() = %v, want %v", got, want)
+			}
+			if !reflect.DeepEqual(b, object(t, base)) {
+				t.Error("Apply() modified the base")
+			}
+		})
+	}
+}
+
+func TestApplyRejectsInvalidStrategies(t *testing.T) {
+	for _, strategy := range []types.MergeStrategy{
+		{Strategy: "strategic"},
+		{Strategy: types.MergeStrategyMergePatch, Lists: types.ListStrategyAppend},
+		{Strategy: types.MergeStrategyDeepMerge, Lists: "prepend"},
+	} {
+		if _, err := Apply(nil, map[string]interface{}{}, strategy); err == nil {
+			t.Errorf("Apply() accepted %+v", strategy)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package node
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+var (
+	// ErrProcessNotFound is returned when no managed process matches a spec hash
+	ErrProcessNotFound = errors.New("process not found")
+	// ErrAmbiguousProcess is returned when a spec hash prefix matches several processes
+	ErrAmbiguousProcess = errors.New("spec hash prefix matches more than one process")
+	// ErrInvalidLogLevel is returned when an unknown Node.js log level is requested
+	ErrInvalidLogLevel = errors.New("invalid log level")
+)
+
+// minHashPrefixLength is the shortest spec hash prefix accepted to identify a process.
+// It matches the short hash used in log lines (code_hash field).
+const minHashPrefixLength = 8
+
+// ProcessStatus is a point-in-time snapshot of a managed Node.js process
+type ProcessStatus struct {
+	SpecHash     string    `json:"specHash"`
+	PID          int       `json:"pid"`
+	Port         int       `json:"port"`
+	StartedAt    time.Time `json:"startedAt"`
+	IdleSeconds  float64   `json:"idleSeconds"`
+	RequestCount int64     `json:"requestCount"`
+	InFlight     int32     `json:"inFlight"`
+	RSSBytes     int64     `json:"rssBytes"`
+	LogLevel     string    `json:"logLevel"`
+}
+
+// ListProcesses returns a snapshot of every managed Node.js process, sorted by spec hash
+func (pm *ProcessManager) ListProcesses() []ProcessStatus {
+	now := time.Now()
+
+	processes := pm.snapshotProcesses()
+	statuses := make([]ProcessStatus, 0, len(processes))
+	for _, info := range processes {
+		status := ProcessStatus{
+			SpecHash:     info.SpecHash,
+			PID:          info.PID(),
+			Port:         info.Port,
+			StartedAt:    info.StartedAt,
+			RequestCount: info.RequestCount(),
+			InFlight:     info.InFlight(),
+			LogLevel:     info.LogLevel(),
+		}
+
+		// The process lock is held for the whole duration of a request, so a busy
+		// process is reported as not idle instead of waiting for the request to end
+		if info.Lock.TryLock() {
+			status.IdleSeconds = now.Sub(info.LastUsed).Seconds()
+			info.Lock.Unlock()
+		}
+
+		if status.PID != 0 {
+			if rss, err := readProcessRSS(status.PID); err == nil {
+				status.RSSBytes = rss
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].SpecHash < statuses[j].SpecHash
+	})
+
+	return statuses
+}
+
+// KillProcess terminates the process matching the given spec hash or hash prefix
+// and returns its full spec hash
+func (pm *ProcessManager) KillProcess(hashOrPrefix string) (string, error) {
+	specHash, process, err := pm.findProcess(hashOrPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	pm.restartProcess(process, specHash)
+	return specHash, nil
+}
+
+// RestartProcess terminates the process matching the given spec hash or hash prefix
+// and starts a fresh one from the same input. It returns the full spec hash.
+func (pm *ProcessManager) RestartProcess(ctx context.Context, hashOrPrefix string) (string, error) {
+	specHash, process, err := pm.findProcess(hashOrPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	if process.Input == nil {
+		return "", fmt.Errorf("process %s has no recorded input and cannot be restarted", specHash[:8])
+	}
+
+	pm.restartProcess(process, specHash)
+
+	if _, err := pm.getOrCreateProcess(ctx, process.Input); err != nil {
+		return specHash, fmt.Errorf("failed to start replacement process for %s: %w", specHash[:8], err)
+	}
+
+	return specHash, nil
+}
+
+// SetProcessLogLevel changes the log level of the running process matching the given
+// spec hash or hash prefix, without restarting it. It returns the full spec hash.
+// The level is not persisted: a restarted process uses the configured level again.
+func (pm *ProcessManager) SetProcessLogLevel(ctx context.Context, hashOrPrefix, level string) (string, error) {
+	if !types.IsValidNodeLogLevel(level) {
+		return "", fmt.Errorf("%w: %q, must be one of: %s", ErrInvalidLogLevel, level, strings.Join(types.NodeLogLevels, ", "))
+	}
+
+	specHash, process, err := pm.findProcess(hashOrPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	if err := process.Client.SetLogLevel(ctx, level); err != nil {
+		return specHash, fmt.Errorf("failed to set log level of process %s: %w", specHash[:8], err)
+	}
+	process.logLevel.Store(level)
+
+	pm.logger.WithField(logger.FieldCodeHash, specHash[:8]).
+		WithField(logger.FieldComponent, "node").
+		WithField("node_log_level", level).
+		Info("Changed Node.js process log level")
+
+	return specHash, nil
+}
+
+// CollectGarbage runs a garbage collection pass immediately
+func (pm *ProcessManager) CollectGarbage() {
+	pm.collectGarbage()
+}
+
+// RollProcesses restarts every managed process one after the other, waiting for
+// stagger between two restarts. It returns the spec hashes that were restarted.
+func (pm *ProcessManager) RollProcesses(ctx context.Context, stagger time.Duration) ([]string, error) {
+	rollLogger := pm.logger.WithField(logger.FieldComponent, "node")
+	rollLogger = rollLogger.WithField(logger.FieldOperation, "roll")
+
+	processes := pm.snapshotProcesses()
+	hashes := make([]string, 0, len(processes))
+	for specHash := range processes {
+		hashes = append(hashes, specHash)
+	}
+	sort.Strings(hashes)
+
+	rollLogger.WithField("processes", len(hashes)).
+		WithField("stagger", stagger.String()).
+		Info("Rolling all Node.js processes")
+
+	var restarted []string
+	for i, specHash := range hashes {
+		if i > 0 && stagger > 0 {
+			select {
+			case <-ctx.Done():
+				return restarted, ctx.Err()
+			case <-time.After(stagger):
+			}
+		}
+
+		if _, err := pm.RestartProcess(ctx, specHash); err != nil {
+			if errors.Is(err, ErrProcessNotFound) {
+				// The process was collected or restarted by someone else meanwhile
+				continue
+			}
+			return restarted, err
+		}
+		restarted = append(restarted, specHash)
+	}
+
+	rollLogger.WithField("restarted", len(restarted)).Info("Finished rolling Node.js processes")
+	return restarted, nil
+}
+
+// findProcess looks up a process by full spec hash or unambiguous hash prefix
+func (pm *ProcessManager) findProcess(hashOrPrefix string) (string, *ProcessInfo, error) {
+	if len(hashOrPrefix) < minHashPrefixLength {
+		return "", nil, fmt.Errorf("spec hash must be at least %d characters: %w", minHashPrefixLength, ErrProcessNotFound)
+	}
+
+	processes := pm.snapshotProcesses()
+	if process, ok := processes[hashOrPrefix]; ok {
+		return hashOrPrefix, process, nil
+	}
+
+	var (
+		foundHash    string
+		foundProcess *ProcessInfo
+	)
+	for specHash, process := range processes {
+		if !strings.HasPrefix(specHash, hashOrPrefix) {
+			continue
+		}
+		if foundProcess != nil {
+			return "", nil, ErrAmbiguousProcess
+		}
+		foundHash, foundProcess = specHash, process
+	}
+
+	if foundProcess == nil {
+		return "", nil, ErrProcessNotFound
+	}
+	return foundHash, foundProcess, nil
+}
+
+// readProcessRSS reads the resident set size of a process from /proc, in bytes
+func readProcessRSS(pid int) (int64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "VmRSS:") {
+			continue
+		}
+		// Format is "VmRSS:	   12345 kB"
+		fields := strings.Fields(strings.TrimPrefix(line, "VmRSS:"))
+		if len(fields) == 0 {
+			break
+		}
+		kb, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse VmRSS: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("VmRSS not reported for pid %d", pid)
+}
+
+// InstallQueueStats returns a snapshot of the dependency install queue, with the
+// measures of the workspace .yarn directories.
+// It reports false when installs are not enabled.
+func (pm *ProcessManager) InstallQueueStats() (InstallQueueStats, bool) {
+	if pm.installQueue == nil {
+		return InstallQueueStats{}, false
+	}
+	stats := pm.installQueue.Stats()
+	yarnDir := pm.yarnInstaller.YarnDirStats()
+	stats.YarnDir = &yarnDir
+	return stats, true
+}
+
+// WorkspaceStatus returns the workspace packages bundled in the image, as resolved for dependencies
+func (pm *ProcessManager) WorkspaceStatus() WorkspaceRegistryStatus {
+	// Load the packages if no process was created yet
+	_, _ = pm.workspaces.Packages()
+	return pm.workspaces.Status()
+}
+
+// RefreshWorkspaces loads the workspace packages again
+func (pm *ProcessManager) RefreshWorkspaces() (WorkspaceRegistryStatus, error) {
+	err := pm.workspaces.Refresh()
+	return pm.workspaces.Status(), err
+}
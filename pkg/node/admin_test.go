@@ -0,0 +1,58 @@
+package node
+
+import (
+	"testing"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+func TestAdminDoesNotWaitForProcessCreation(t *testing.T) {
+	pm, err := NewProcessManager(time.Hour, time.Hour, t.TempDir(), logger.NewLogrusLogger("error", "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	specHash := "0123456789abcdef0123456789abcdef"
+	pm.setProcess(specHash, &ProcessInfo{SpecHash: specHash, LastUsed: time.Now()})
+
+	// A process being created holds the creation lock through its install
+	pm.lock.Lock()
+	defer pm.lock.Unlock()
+
+	done := make(chan []ProcessStatus)
+	go func() { done <- pm.ListProcesses() }()
+	select {
+	case statuses := <-done:
+		if len(statuses) != 1 || statuses[0].SpecHash != specHash {
+			t.Errorf("ListProcesses() = %v, want the stored process", statuses)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListProcesses() waits for the process being created")
+	}
+
+	if found, _, err := pm.findProcess(specHash[:8]); err != nil || found != specHash {
+		t.Errorf("findProcess() = %s, %v, want the stored process", found, err)
+	}
+}
+
+func TestDeleteProcessKeepsReplacement(t *testing.T) {
+	pm, err := NewProcessManager(time.Hour, time.Hour, t.TempDir(), logger.NewLogrusLogger("error", "text"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	specHash := "0123456789abcdef0123456789abcdef"
+	previous := &ProcessInfo{SpecHash: specHash}
+	replacement := &ProcessInfo{SpecHash: specHash}
+	pm.setProcess(specHash, replacement)
+
+	// A garbage collection pass holding the previous process in its snapshot
+	if pm.deleteProcess(specHash, previous) {
+		t.Error("deleteProcess() removed the replacement process")
+	}
+	if process, ok := pm.getProcess(specHash); !ok || process != replacement {
+		t.Error("the replacement process is no longer stored")
+	}
+	if !pm.deleteProcess(specHash, replacement) {
+		t.Error("deleteProcess() did not remove the stored process")
+	}
+}
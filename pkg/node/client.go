@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -38,8 +39,9 @@ func NewNodeClient(baseURL string, timeout time.Duration, logger logger.Logger)
 	}
 }
 
-// ExecuteFunction sends a request to the Node.js server to execute a function
-func (c *NodeClient) ExecuteFunction(ctx context.Context, code string, dependencies map[string]string, inputJSON string) (string, error) {
+// ExecuteFunction sends a request to the Node.js server to execute a function.
+// The request ID is sent as X-Request-ID so the server tags the output it produces.
+func (c *NodeClient) ExecuteFunction(ctx context.Context, requestID string, code string, dependencies map[string]string, inputJSON string) (string, error) {
 	// Create the request payload
 	type requestPayload struct {
 		Code         string            `json:"code"`
@@ -79,6 +81,9 @@ func (c *NodeClient) ExecuteFunction(ctx context.Context, code string, dependenc
 	// Set headers
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
 
 	// Send the request
 	c.logger.Debugf("Sending request to Node.js server: %s", c.baseURL)
@@ -110,8 +115,50 @@ func (c *NodeClient) ExecuteFunction(ctx context.Context, code string, dependenc
 	return string(respBody), nil
 }
 
-// CheckReady checks if the Node.js server is ready
-func (c *NodeClient) CheckReady(ctx context.Context) error {
+// SetLogLevel changes the log level of the Node.js server at runtime
+func (c *NodeClient) SetLogLevel(ctx context.Context, level string) error {
+	payloadBytes, err := json.Marshal(map[string]string{"level": level})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request payload: %w", err)
+	}
+
+	// Create the HTTP request
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		fmt.Sprintf("%s/log-level", c.baseURL),
+		bytes.NewReader(payloadBytes),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send the request
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Check the response status
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("setting log level failed with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// ReadyStatus is the readiness of a Node.js server that has loaded the user module
+type ReadyStatus struct {
+	// Requirements are the extra resource requirements statically exported by the user
+	// module as `requirements`, by requirement name
+	Requirements map[string]json.RawMessage `json:"requirements,omitempty"`
+}
+
+// CheckReady checks if the Node.js server is ready and returns its status
+func (c *NodeClient) CheckReady(ctx context.Context) (*ReadyStatus, error) {
 	// Create the HTTP request
 	req, err := http.NewRequestWithContext(
 		ctx,
@@ -120,13 +167,13 @@ func (c *NodeClient) CheckReady(ctx context.Context) error {
 		nil,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 	}
 
 	// Send the request
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send HTTP request: %w", err)
+		return nil, fmt.Errorf("failed to send HTTP request: %w", err)
 	}
 	defer func() {
 		// Ensure body is fully read before closing to allow connection reuse
@@ -139,14 +186,28 @@ func (c *NodeClient) CheckReady(ctx context.Context) error {
 	// Check the response status
 	if resp.StatusCode != http.StatusOK {
 		respBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("readiness check failed with status %d: %s", resp.StatusCode, string(respBody))
+
+		// The user module failed to import or validate
+		if resp.StatusCode == http.StatusUnprocessableEntity {
+			if loadErr := parseModuleLoadError(respBody); loadErr != nil {
+				return nil, loadErr
+			}
+		}
+
+		return nil, fmt.Errorf("readiness check failed with status %d: %s", resp.StatusCode, string(respBody))
 	}
 
-	return nil
+	var status ReadyStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to decode readiness status: %w", err)
+	}
+	return &status, nil
 }
 
-// WaitForReady waits for the Node.js server to be ready
-func (c *NodeClient) WaitForReady(ctx context.Context, timeout, interval time.Duration) error {
+// WaitForReady waits for the Node.js server to be ready and returns its status. The
+// server is ready once it has imported and validated the user module; if that fails,
+// the *ModuleLoadError is returned immediately instead of waiting for the timeout.
+func (c *NodeClient) WaitForReady(ctx context.Context, timeout, interval time.Duration) (*ReadyStatus, error) {
 	// Create a context with timeout
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
@@ -159,13 +220,18 @@ func (c *NodeClient) WaitForReady(ctx context.Context, timeout, interval time.Du
 	for {
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("timeout waiting for Node.js server to be ready: %w", ctx.Err())
+			return nil, fmt.Errorf("timeout waiting for Node.js server to be ready: %w", ctx.Err())
 		case <-ticker.C:
-			if err := c.CheckReady(ctx); err != nil {
+			status, err := c.CheckReady(ctx)
+			if err != nil {
+				var loadErr *ModuleLoadError
+				if errors.As(err, &loadErr) {
+					return nil, loadErr
+				}
 				c.logger.Debugf("Node.js server not ready yet: %v", err)
 			} else {
 				c.logger.Info("Node.js server is ready")
-				return nil
+				return status, nil
 			}
 		}
 	}
@@ -0,0 +1,90 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+func TestCheckReadyRequirements(t *testing.T) {
+	body := `{"status":"loading"}`
+	status := http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	client := NewNodeClient(server.URL, time.Second, logger.NewLogrusLogger("error", "text"))
+
+	if _, err := client.CheckReady(context.Background()); err == nil {
+		t.Error("CheckReady() of a loading module succeeded")
+	}
+
+	status = http.StatusOK
+	body = `{"status":"ready","requirements":{"settings":{"apiVersion":"v1","kind":"ConfigMap","matchName":"settings"}}}`
+	ready, err := client.CheckReady(context.Background())
+	if err != nil {
+		t.Fatalf("CheckReady() error = %v", err)
+	}
+	if string(ready.Requirements["settings"]) != `{"apiVersion":"v1","kind":"ConfigMap","matchName":"settings"}` {
+		t.Errorf("CheckReady() requirements = %v, want the exported requirement", ready.Requirements)
+	}
+
+	status = http.StatusUnprocessableEntity
+	body = `{"status":"error","diagnostics":[{"kind":"export","message":"Module exports requirements that are not an object (got array)"}]}`
+	var loadErr *ModuleLoadError
+	if _, err := client.CheckReady(context.Background()); !errors.As(err, &loadErr) {
+		t.Errorf("CheckReady() error = %v, want a ModuleLoadError", err)
+	}
+}
+
+func TestApplyLogLevel(t *testing.T) {
+	var sent []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		sent = append(sent, payload["level"])
+	}))
+	defer server.Close()
+
+	log := logger.NewLogrusLogger("error", "text")
+	pm, err := NewProcessManager(time.Hour, time.Hour, t.TempDir(), log, WithNodeLogLevel("warn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := &ProcessInfo{Client: NewNodeClient(server.URL, time.Second, log)}
+	process.logLevel.Store("warn")
+	process.specLogLevel.Store("")
+
+	input := newFilesInput(map[string]string{"main.ts": "export default 1"}, "main.ts")
+	steps := []struct {
+		specLevel string
+		adminSet  string
+		want      string
+	}{
+		{specLevel: "", want: "warn"},
+		{specLevel: "debug", want: "debug"},
+		{specLevel: "debug", adminSet: "trace", want: "trace"}, // kept until the input changes
+		{specLevel: "", want: "warn"},
+	}
+	for _, step := range steps {
+		if step.adminSet != "" {
+			process.logLevel.Store(step.adminSet)
+		}
+		input.Spec.LogLevel = step.specLevel
+		pm.applyLogLevel(context.Background(), process, input, log)
+		if got := process.LogLevel(); got != step.want {
+			t.Errorf("log level with input %q = %q, want %q", step.specLevel, got, step.want)
+		}
+	}
+
+	if want := []string{"debug", "warn"}; len(sent) != len(want) || sent[0] != want[0] || sent[1] != want[1] {
+		t.Errorf("levels sent = %v, want %v", sent, want)
+	}
+}
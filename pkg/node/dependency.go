@@ -8,6 +8,7 @@ import (
 
 // DependencyResolver handles dependency resolution operations
 type DependencyResolver struct {
+	policy *DependencyPolicy
 	logger logger.Logger
 }
 
@@ -18,6 +19,11 @@ func NewDependencyResolver(logger logger.Logger) *DependencyResolver {
 	}
 }
 
+// SetPolicy sets the policy enforced by ValidateDependencies. nil allows everything.
+func (dr *DependencyResolver) SetPolicy(policy *DependencyPolicy) {
+	dr.policy = policy
+}
+
 // ResolveDependencies processes and resolves dependencies from the input specification
 func (dr *DependencyResolver) ResolveDependencies(inputDependencies map[string]string, workspaceMap map[string]string, workspaceRoot string, logger logger.Logger) (map[string]interface{}, error) {
 	dependencies := make(map[string]interface{})
@@ -62,9 +68,8 @@ func (dr *DependencyResolver) ResolveDependencies(inputDependencies map[string]s
 	return dependencies, nil
 }
 
-// ValidateDependencies validates that all dependencies are properly formatted
-func (dr *DependencyResolver) ValidateDependencies(dependencies map[string]interface{}) error {
-	// Add validation logic here if needed
-	// For now, we'll just return nil as the current implementation doesn't require validation
-	return nil
+// ValidateDependencies checks the declared dependencies against the dependency policy.
+// It returns a *PolicyViolationError listing every offending dependency.
+func (dr *DependencyResolver) ValidateDependencies(dependencies map[string]string, lockfile string, workspaceMap map[string]string) error {
+	return dr.policy.Validate(dependencies, lockfile, workspaceMap)
 }
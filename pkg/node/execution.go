@@ -6,13 +6,52 @@ import (
 	"fmt"
 	"time"
 
-	"github.com/socialgouv/xfuncjs-server/pkg/hash"
+	"github.com/google/uuid"
+
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
 	"github.com/socialgouv/xfuncjs-server/pkg/types"
 )
 
+// ExecutionResult is the outcome of a function execution
+type ExecutionResult struct {
+	// Output is the raw JSON response of the Node.js server
+	Output string
+	// Logs holds the Node.js output lines produced while the request ran
+	Logs []string
+	// Warnings holds the problems found while creating the process
+	Warnings []ProcessWarning
+}
+
+// ExecutionError is returned when a function execution fails. It carries the
+// Node.js output lines produced during the last attempt.
+type ExecutionError struct {
+	Err  error
+	Logs []string
+}
+
+// Error returns the error message
+func (e *ExecutionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *ExecutionError) Unwrap() error {
+	return e.Err
+}
+
+// StaticRequirements returns the extra resource requirements statically exported by the
+// user module, by requirement name. The process serving the input is created if needed,
+// without running the function.
+func (pm *ProcessManager) StaticRequirements(ctx context.Context, input *types.XFuncJSInput) (map[string]json.RawMessage, error) {
+	process, err := pm.getOrCreateProcess(ctx, input)
+	if err != nil {
+		return nil, &ExecutionError{Err: fmt.Errorf("failed to get or create process: %w", err)}
+	}
+	return process.Requirements, nil
+}
+
 // ExecuteFunction executes a JavaScript/TypeScript function with the given input
-func (pm *ProcessManager) ExecuteFunction(ctx context.Context, input *types.XFuncJSInput, inputJSON string) (string, error) {
+func (pm *ProcessManager) ExecuteFunction(ctx context.Context, input *types.XFuncJSInput, inputJSON string) (*ExecutionResult, error) {
 	// Maximum number of retries
 	const maxRetries = 3
 
@@ -20,13 +59,13 @@ func (pm *ProcessManager) ExecuteFunction(ctx context.Context, input *types.XFun
 	retryDelay := 500 * time.Millisecond
 
 	var lastErr error
+	var lastLogs []string
 
 	// Generate hash based on the entire input spec
-	specBytes, err := json.Marshal(input.Spec)
+	specHash, err := computeSpecHash(input)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal input spec: %w", err)
+		return nil, err
 	}
-	specHash := hash.GenerateInputHash(specBytes)
 
 	// Extract resource information from the input JSON if available
 	var resourceInfo *types.ResourceInfo
@@ -61,6 +100,11 @@ func (pm *ProcessManager) ExecuteFunction(ctx context.Context, input *types.XFun
 		process, err := pm.getOrCreateProcess(ctx, input)
 		if err != nil {
 			lastErr = fmt.Errorf("failed to get or create process: %w", err)
+			if isPermanent(err) {
+				// Retrying cannot fix errors in the function code itself
+				execLogger.WithField(logger.FieldError, err.Error()).Error("Function cannot be loaded, not retrying")
+				break
+			}
 			continue // Retry
 		}
 
@@ -73,6 +117,8 @@ func (pm *ProcessManager) ExecuteFunction(ctx context.Context, input *types.XFun
 		}
 
 		// Send the request to the process
+		process.requestCount.Add(1)
+		process.inFlight.Add(1)
 		process.Lock.Lock()
 
 		// Update the last used time
@@ -81,36 +127,53 @@ func (pm *ProcessManager) ExecuteFunction(ctx context.Context, input *types.XFun
 		// Create a context with timeout for the operation
 		execCtx, cancel := context.WithTimeout(ctx, pm.requestTimeout)
 
-		// Execute the function
-		execLogger.Debug("Sending request to Node.js server")
-		result, err := process.Client.ExecuteFunction(execCtx, input.Spec.Source.Inline, input.Spec.Source.Dependencies, inputJSON)
+		// Execute the function, capturing the output produced for this request
+		requestID := uuid.New().String()
+		process.capture.begin(requestID)
+		execLogger.WithField(logger.FieldRequestID, requestID).Debug("Sending request to Node.js server")
+		result, err := process.Client.ExecuteFunction(execCtx, requestID, input.EntrypointSource(), input.Spec.Source.Dependencies, inputJSON)
+		logs := process.capture.finish(requestID)
 
 		// Cleanup
 		cancel() // Cancel the context
 
 		if err != nil {
 			process.Lock.Unlock()
+			process.inFlight.Add(-1)
 			execLogger.WithField(logger.FieldError, err.Error()).Error("Error executing function")
 
 			// If there's an error, we should restart the process
 			pm.restartProcess(process, specHash)
 
-			// Save the error for potential retry
+			// Save the error and output for potential retry
 			lastErr = err
+			lastLogs = logs
 			continue // Retry
 		}
 
 		// Success
 		execLogger.Debug("Received result from Node.js server")
 		process.Lock.Unlock()
-		return result, nil
+		process.inFlight.Add(-1)
+		return &ExecutionResult{Output: result, Logs: logs, Warnings: process.Warnings}, nil
+	}
+
+	// Permanent errors are returned as is, without the retry context
+	if isPermanent(lastErr) {
+		return nil, &ExecutionError{Err: lastErr, Logs: lastLogs}
 	}
 
 	// If we've exhausted all retries, return a more detailed error
 	if resourceInfo != nil {
-		return "", fmt.Errorf("failed to execute function for resource %s/%s after %d attempts: %w",
-			resourceInfo.XRKind, resourceInfo.XRName, maxRetries, lastErr)
+		return nil, &ExecutionError{
+			Err: fmt.Errorf("failed to execute function for resource %s/%s after %d attempts: %w",
+				resourceInfo.XRKind, resourceInfo.XRName, maxRetries, lastErr),
+			Logs: lastLogs,
+		}
 	}
 
-	return "", fmt.Errorf("all retry attempts failed for spec hash %s: %w", specHash[:8], lastErr)
+	return nil, &ExecutionError{
+		Err:  fmt.Errorf("all retry attempts failed for spec hash %s: %w", specHash[:8], lastErr),
+		Logs: lastLogs,
+	}
 }
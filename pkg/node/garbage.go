@@ -29,10 +29,9 @@ func (pm *ProcessManager) collectGarbage() {
 
 	gcLogger.Debug("Starting garbage collection")
 
-	pm.lock.Lock()
-	defer pm.lock.Unlock()
-
-	for id, info := range pm.processes {
+	// A pass works on a snapshot, so it does not wait for the processes being created
+	processes := pm.snapshotProcesses()
+	for id, info := range processes {
 		info.Lock.Lock()
 
 		// Create a process-specific logger
@@ -46,6 +45,12 @@ func (pm *ProcessManager) collectGarbage() {
 		processLogger = processLogger.WithField("idle_time_seconds", int(idleTime.Seconds()))
 
 		if idleTime > pm.idleTimeout {
+			// Remove the process first, unless it was replaced since the snapshot
+			if !pm.deleteProcess(id, info) {
+				processLogger.Debug("Process was replaced, skipping")
+				info.Lock.Unlock()
+				continue
+			}
 			processLogger.Info("Terminating idle process")
 
 			// Send SIGTERM to signal the process to exit gracefully
@@ -79,7 +84,6 @@ func (pm *ProcessManager) collectGarbage() {
 				}
 			}
 
-			delete(pm.processes, id)
 			processLogger.Info("Process successfully terminated")
 		} else {
 			processLogger.Debug("Process still active, skipping")
@@ -88,6 +92,6 @@ func (pm *ProcessManager) collectGarbage() {
 		info.Lock.Unlock()
 	}
 
-	gcLogger.WithField("active_processes", len(pm.processes)).
+	gcLogger.WithField("active_processes", len(pm.snapshotProcesses())).
 		Debug("Garbage collection completed")
 }
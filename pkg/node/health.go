@@ -44,7 +44,7 @@ func (pm *ProcessManager) isProcessHealthy(process *ProcessInfo) bool {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := process.Client.CheckReady(ctx); err != nil {
+	if _, err := process.Client.CheckReady(ctx); err != nil {
 		healthLogger.WithField(logger.FieldError, err.Error()).
 			Warn("Process is not healthy: health check failed")
 		return false
@@ -85,14 +85,14 @@ func (pm *ProcessManager) restartProcess(process *ProcessInfo, hash string) {
 	}
 
 	// Remove the process from the map
-	pm.lock.Lock()
+	pm.processesLock.Lock()
 	for id, p := range pm.processes {
 		if p == process {
 			delete(pm.processes, id)
 			break
 		}
 	}
-	pm.lock.Unlock()
+	pm.processesLock.Unlock()
 
 	restartLogger.Info("Process successfully restarted")
 }
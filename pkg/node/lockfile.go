@@ -0,0 +1,202 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+// LockfileDriftReason is the reason of the warning reported when yarn.lock drifted
+const LockfileDriftReason = "LockfileDrift"
+
+// LockfileDrift describes how a supplied yarn.lock differs from the declared dependencies
+type LockfileDrift struct {
+	// Missing lists declared packages that have no entry in the lockfile
+	Missing []string `json:"missing,omitempty"`
+	// Changed lists declared packages locked with another range
+	Changed []LockfileChange `json:"changed,omitempty"`
+	// Unreadable is set when the lockfile cannot be parsed as a Yarn Berry lockfile
+	Unreadable string `json:"unreadable,omitempty"`
+	// Rejected is the error of an immutable install refused by yarn because it would
+	// modify the lockfile
+	Rejected string `json:"rejected,omitempty"`
+}
+
+// LockfileChange is a declared package whose range is not the one in the lockfile
+type LockfileChange struct {
+	Package  string   `json:"package"`
+	Declared string   `json:"declared"`
+	Locked   []string `json:"locked"` // Locked versions of the package
+}
+
+// Empty reports whether the lockfile matches the declared dependencies
+func (d *LockfileDrift) Empty() bool {
+	return d == nil || (len(d.Missing) == 0 && len(d.Changed) == 0 && d.Unreadable == "" && d.Rejected == "")
+}
+
+// String renders the drift on a single line
+func (d *LockfileDrift) String() string {
+	if d.Unreadable != "" {
+		return "unreadable yarn.lock: " + d.Unreadable
+	}
+	if d.Rejected != "" {
+		return "rejected by the immutable install: " + d.Rejected
+	}
+
+	var parts []string
+	for _, c := range d.Changed {
+		parts = append(parts, fmt.Sprintf("%s declared %s, locked %s", c.Package, c.Declared, strings.Join(c.Locked, ", ")))
+	}
+	if len(d.Missing) > 0 {
+		parts = append(parts, "not locked: "+strings.Join(d.Missing, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// LockfileDriftError is returned in strict lockfile mode when the supplied yarn.lock
+// does not match the declared dependencies
+type LockfileDriftError struct {
+	Drift *LockfileDrift
+}
+
+// Error describes the drift
+func (e *LockfileDriftError) Error() string {
+	return "yarn.lock does not match the declared dependencies: " + e.Drift.String()
+}
+
+// permanent marks the error as not worth retrying
+func (e *LockfileDriftError) permanent() bool { return true }
+
+// computeLockfileDrift compares the declared dependencies with a Yarn Berry lockfile.
+// Only registry dependencies are compared: the workspace packages provided by the server
+// image, the link: dependencies and the root workspace entry are left to the immutable
+// install. An empty drift therefore does not prove the lockfile matches.
+func computeLockfileDrift(lockfile []byte, dependencies map[string]string, workspaceMap map[string]string) *LockfileDrift {
+	var entries map[string]map[string]interface{}
+	if err := yaml.Unmarshal(lockfile, &entries); err != nil {
+		return &LockfileDrift{Unreadable: err.Error()}
+	}
+	if _, ok := entries["__metadata"]; !ok {
+		return &LockfileDrift{Unreadable: "no __metadata entry, only Yarn Berry lockfiles are supported"}
+	}
+
+	// Index the locked descriptors and versions by package name.
+	// Keys hold one or more descriptors: "lodash@npm:^4.17.0, lodash@npm:^4.17.21"
+	locked := make(map[string]bool)
+	versions := make(map[string][]string)
+	for key, entry := range entries {
+		for _, descriptor := range strings.Split(key, ",") {
+			descriptor = strings.TrimSpace(descriptor)
+			name, _ := splitDescriptor(descriptor)
+			if name == "" {
+				continue
+			}
+			locked[descriptor] = true
+			if version, ok := entry["version"]; ok {
+				if v := fmt.Sprint(version); !slices.Contains(versions[name], v) {
+					versions[name] = append(versions[name], v)
+				}
+			}
+		}
+	}
+
+	drift := &LockfileDrift{}
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, isWorkspace := workspaceMap[name]; isWorkspace {
+			continue
+		}
+		specifier := dependencies[name]
+		if _, _, registry := parseSpecifier(name, specifier); !registry {
+			continue
+		}
+
+		// Yarn stores registry ranges with the npm: protocol
+		descriptor := name + "@" + specifier
+		if !strings.HasPrefix(specifier, "npm:") {
+			descriptor = name + "@npm:" + specifier
+		}
+		if locked[descriptor] {
+			continue
+		}
+
+		if lockedVersions, ok := versions[name]; ok {
+			sort.Strings(lockedVersions)
+			drift.Changed = append(drift.Changed, LockfileChange{Package: name, Declared: specifier, Locked: lockedVersions})
+		} else {
+			drift.Missing = append(drift.Missing, name)
+		}
+	}
+
+	return drift
+}
+
+// splitDescriptor splits "lodash@npm:^4.17.21" or "@scope/pkg@npm:1.0.0" into the
+// package name and the range. It returns an empty name if s is not a descriptor.
+func splitDescriptor(s string) (string, string) {
+	// The range separator is the first "@" after the scope
+	scoped := strings.HasPrefix(s, "@")
+	at := strings.Index(strings.TrimPrefix(s, "@"), "@")
+	if at <= 0 {
+		return "", ""
+	}
+	if scoped {
+		at++
+	}
+
+	name := s[:at]
+	if strings.ContainsAny(name, " \t") {
+		return "", ""
+	}
+	return name, s[at+1:]
+}
+
+// lockfileRejection returns the drift of an immutable install that yarn refused because
+// it would modify the lockfile, nil when err is another failure
+func lockfileRejection(err error) *LockfileDriftError {
+	var installErr *YarnInstallError
+	if !errors.As(err, &installErr) || !installErr.hasKind(YarnFailureLockfile) {
+		return nil
+	}
+	return &LockfileDriftError{Drift: &LockfileDrift{Rejected: installErr.Error()}}
+}
+
+// checkLockfile compares the yarn.lock written in workDir with the declared dependencies,
+// before the immutable install. In strict mode a drift is a *LockfileDriftError, otherwise
+// it is the warning to attach to executions. It only catches the drift of registry
+// dependencies; the immutable install rejects the lockfile for the rest.
+func (pm *ProcessManager) checkLockfile(workDir string, dependencies map[string]string, workspaceMap map[string]string, procLogger logger.Logger) (*ProcessWarning, error) {
+	content, err := os.ReadFile(filepath.Join(workDir, "yarn.lock"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read yarn.lock: %w", err)
+	}
+
+	drift := computeLockfileDrift(content, dependencies, workspaceMap)
+	if drift.Empty() {
+		procLogger.Info("No drift found in the registry dependencies of yarn.lock")
+		return nil, nil
+	}
+
+	driftErr := &LockfileDriftError{Drift: drift}
+	if pm.lockfileStrict {
+		return nil, driftErr
+	}
+
+	// The immutable install fails if yarn has to update the resolution
+	procLogger.WithField(logger.FieldError, driftErr.Error()).
+		Warn("yarn.lock drifted from the declared dependencies, installing in immutable mode anyway")
+	return &ProcessWarning{Reason: LockfileDriftReason, Message: driftErr.Error()}, nil
+}
@@ -0,0 +1,128 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+const testLockfile = `# This file is generated by running "yarn install" inside your project.
+
+__metadata:
+  version: 8
+  cacheKey: 10c0
+
+"@acme/utils@npm:~1.2.0":
+  version: 1.2.4
+  resolution: "@acme/utils@npm:1.2.4"
+  languageName: node
+  linkType: hard
+
+"lodash@npm:^4.17.0, lodash@npm:^4.17.21":
+  version: 4.17.21
+  resolution: "lodash@npm:4.17.21"
+  languageName: node
+  linkType: hard
+
+"chalk@npm:^4.1.0":
+  version: 4.1.2
+  resolution: "chalk@npm:4.1.2"
+  languageName: node
+  linkType: hard
+`
+
+func TestComputeLockfileDrift(t *testing.T) {
+	t.Run("matching", func(t *testing.T) {
+		deps := map[string]string{
+			"lodash":             "^4.17.21",
+			"@acme/utils":        "npm:~1.2.0",
+			"@crossplane-js/sdk": "link:packages/sdk",
+			"local-package":      "file:./local",
+		}
+		workspaces := map[string]string{"@crossplane-js/sdk": "packages/sdk"}
+		if drift := computeLockfileDrift([]byte(testLockfile), deps, workspaces); !drift.Empty() {
+			t.Errorf("expected no drift, got %s", drift)
+		}
+	})
+
+	t.Run("drifted", func(t *testing.T) {
+		deps := map[string]string{
+			"lodash":   "^4.17.21",
+			"chalk":    "^5.0.0",
+			"left-pad": "^1.3.0",
+		}
+		drift := computeLockfileDrift([]byte(testLockfile), deps, nil)
+		want := &LockfileDrift{
+			Missing: []string{"left-pad"},
+			Changed: []LockfileChange{{Package: "chalk", Declared: "^5.0.0", Locked: []string{"4.1.2"}}},
+		}
+		if !reflect.DeepEqual(drift, want) {
+			t.Errorf("drift = %+v, want %+v", drift, want)
+		}
+		if got, want := drift.String(), "chalk declared ^5.0.0, locked 4.1.2; not locked: left-pad"; got != want {
+			t.Errorf("String() = %q, want %q", got, want)
+		}
+	})
+
+	t.Run("classic lockfile", func(t *testing.T) {
+		classic := "# yarn lockfile v1\n\nlodash@^4.17.21:\n  version \"4.17.21\"\n"
+		drift := computeLockfileDrift([]byte(classic), map[string]string{"lodash": "^4.17.21"}, nil)
+		if drift.Unreadable == "" {
+			t.Errorf("expected an unreadable lockfile, got %+v", drift)
+		}
+	})
+}
+
+func TestLockfileRejection(t *testing.T) {
+	rejected := &YarnInstallError{Failures: []YarnFailure{
+		{Kind: YarnFailureLockfile, Code: "YN0028", Message: "The lockfile would have been modified by this install, which is explicitly forbidden."},
+	}}
+	driftErr := lockfileRejection(fmt.Errorf("install: %w", rejected))
+	if driftErr == nil || driftErr.Drift.Empty() {
+		t.Fatalf("lockfileRejection() = %v, want a drift", driftErr)
+	}
+	if !strings.Contains(driftErr.Error(), "YN0028") {
+		t.Errorf("Error() = %q, want the yarn failure", driftErr.Error())
+	}
+
+	unresolved := &YarnInstallError{Failures: []YarnFailure{{Kind: YarnFailureResolution, Package: "left-pad"}}}
+	for _, err := range []error{nil, unresolved, errors.New("exit status 1")} {
+		if driftErr := lockfileRejection(err); driftErr != nil {
+			t.Errorf("lockfileRejection(%v) = %v, want nil", err, driftErr)
+		}
+	}
+}
+
+func TestCheckLockfile(t *testing.T) {
+	workDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workDir, "yarn.lock"), []byte(testLockfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deps := map[string]string{"lodash": "^4.17.21", "left-pad": "^1.3.0"}
+	log := logger.NewLogrusLogger("error", "text")
+
+	pm, err := NewProcessManager(time.Hour, time.Hour, t.TempDir(), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warning, err := pm.checkLockfile(workDir, deps, nil, log)
+	if err != nil || warning == nil || warning.Reason != LockfileDriftReason {
+		t.Errorf("checkLockfile() = %v, %v, want a drift warning", warning, err)
+	}
+
+	strict, err := NewProcessManager(time.Hour, time.Hour, t.TempDir(), log, WithLockfileStrict(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var driftErr *LockfileDriftError
+	if _, err := strict.checkLockfile(workDir, deps, nil, log); !errors.As(err, &driftErr) {
+		t.Errorf("checkLockfile() error = %v, want a *LockfileDriftError in strict mode", err)
+	}
+}
@@ -0,0 +1,141 @@
+package node
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestEndMarkerKey is the JSON key of the line the Node.js server writes to
+// stdout once it is done with a request (see markRequestEnd in server.ts)
+const requestEndMarkerKey = "xfuncjsRequestEnd"
+
+// requestIDKey is the JSON key the Node.js logger uses to tag lines with the current request
+const requestIDKey = "requestId"
+
+// defaultCapturedLines is the number of output lines kept per request
+const defaultCapturedLines = 100
+
+// requestEndWait bounds how long to wait for the end marker after the HTTP response
+// was received, as stdout lines can be read slightly after the response
+const requestEndWait = 250 * time.Millisecond
+
+// requestLogCapture collects the output a Node.js process produces while a request runs.
+//
+// A process handles one request at a time (see ProcessInfo.Lock), so lines tagged
+// with the active request ID and untagged lines (raw writes to stdout/stderr) are
+// attributed to the active request. Lines tagged with another request ID are late
+// output of a previous request and are dropped.
+type requestLogCapture struct {
+	mu       sync.Mutex
+	maxLines int
+	active   string
+	lines    []string
+	ended    chan struct{}
+}
+
+// newRequestLogCapture creates a capture keeping at most maxLines lines per request
+func newRequestLogCapture(maxLines int) *requestLogCapture {
+	if maxLines <= 0 {
+		maxLines = defaultCapturedLines
+	}
+	return &requestLogCapture{maxLines: maxLines}
+}
+
+// begin starts capturing output for the given request
+func (c *requestLogCapture) begin(requestID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.active = requestID
+	c.lines = nil
+	c.ended = make(chan struct{})
+}
+
+// finish waits briefly for the end-of-request marker, stops capturing and returns
+// the lines captured for the given request
+func (c *requestLogCapture) finish(requestID string) []string {
+	c.mu.Lock()
+	ended := c.ended
+	active := c.active
+	c.mu.Unlock()
+
+	if active != requestID || ended == nil {
+		return nil
+	}
+
+	select {
+	case <-ended:
+	case <-time.After(requestEndWait):
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lines := c.lines
+	c.active = ""
+	c.lines = nil
+	c.ended = nil
+	return lines
+}
+
+// capture records a raw output line. jsonData is the parsed line when it was JSON.
+// It reports whether the line is an internal marker that must not be logged.
+func (c *requestLogCapture) capture(line string, jsonData map[string]interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if jsonData != nil {
+		if endID, ok := jsonData[requestEndMarkerKey].(string); ok {
+			if endID == c.active && c.ended != nil {
+				close(c.ended)
+				c.ended = nil
+			}
+			return true
+		}
+	}
+
+	if c.active == "" {
+		return false
+	}
+
+	rendered := line
+	if jsonData != nil {
+		if taggedID, ok := jsonData[requestIDKey].(string); ok && taggedID != c.active {
+			return false
+		}
+		rendered = renderPinoLine(jsonData, line)
+	}
+
+	c.lines = append(c.lines, rendered)
+	if len(c.lines) > c.maxLines {
+		c.lines = c.lines[len(c.lines)-c.maxLines:]
+	}
+	return false
+}
+
+// renderPinoLine renders a Pino JSON line as "LEVEL message" for human consumption
+func renderPinoLine(jsonData map[string]interface{}, raw string) string {
+	level := "INFO"
+	if l, ok := jsonData["level"].(string); ok {
+		level = strings.ToUpper(l)
+	}
+
+	parts := []string{level}
+	if msg, ok := jsonData["msg"].(string); ok {
+		parts = append(parts, msg)
+	}
+
+	// console.log with several arguments is logged as {context: [...]}
+	if ctx, ok := jsonData["context"]; ok {
+		if b, err := json.Marshal(ctx); err == nil {
+			parts = append(parts, string(b))
+		}
+	}
+
+	if len(parts) == 1 {
+		return raw
+	}
+	return strings.Join(parts, " ")
+}
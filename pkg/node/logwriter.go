@@ -12,7 +12,8 @@ import (
 type logWriter struct {
 	logger     logger.Logger
 	prefix     string
-	streamType string // "stdout" or "stderr"
+	streamType string             // "stdout" or "stderr"
+	capture    *requestLogCapture // Optional per-request capture of the output
 	buffer     []byte
 	bufferLock sync.Mutex
 }
@@ -45,7 +46,17 @@ func (w *logWriter) logLine(line string) {
 
 	// Try to parse the line as JSON (Pino format)
 	var jsonData map[string]interface{}
-	if err := json.Unmarshal([]byte(line), &jsonData); err == nil {
+	isJSON := json.Unmarshal([]byte(line), &jsonData) == nil
+	if !isJSON {
+		jsonData = nil
+	}
+
+	// Record the line for the request currently running, skipping internal markers
+	if w.capture != nil && w.capture.capture(line, jsonData) {
+		return
+	}
+
+	if isJSON {
 		// Successfully parsed as JSON - this is likely Pino output
 		w.logPinoMessage(contextLogger, jsonData)
 	} else {
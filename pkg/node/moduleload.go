@@ -0,0 +1,83 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ModuleDiagnostic describes one problem found while loading the user module
+type ModuleDiagnostic struct {
+	Kind    string `json:"kind"` // "syntax", "import" or "export"
+	Message string `json:"message"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Stack   string `json:"stack,omitempty"`
+}
+
+// String renders the diagnostic as "kind error at file:line:column: message"
+func (d ModuleDiagnostic) String() string {
+	location := d.File
+	if location != "" && d.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, d.Line)
+		if d.Column > 0 {
+			location = fmt.Sprintf("%s:%d", location, d.Column)
+		}
+	}
+
+	if location == "" {
+		return fmt.Sprintf("%s error: %s", d.Kind, d.Message)
+	}
+	return fmt.Sprintf("%s error at %s: %s", d.Kind, location, d.Message)
+}
+
+// ModuleLoadError is returned when the Node.js server cannot import or validate the
+// user module. Such errors are deterministic: retrying or restarting the process
+// cannot fix them, so they are not retried.
+type ModuleLoadError struct {
+	Diagnostics []ModuleDiagnostic
+}
+
+// Error returns every diagnostic on its own line
+func (e *ModuleLoadError) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return "function module failed to load"
+	}
+
+	lines := make([]string, 0, len(e.Diagnostics))
+	for _, d := range e.Diagnostics {
+		lines = append(lines, d.String())
+	}
+	return "function module failed to load: " + strings.Join(lines, "\n")
+}
+
+// permanent marks the error as not worth retrying
+func (e *ModuleLoadError) permanent() bool { return true }
+
+// permanentError is implemented by errors that retrying may not fix
+type permanentError interface {
+	error
+	permanent() bool
+}
+
+// isPermanent reports whether err is permanent, as told by the first error of its chain
+// implementing permanentError
+func isPermanent(err error) bool {
+	var permErr permanentError
+	return errors.As(err, &permErr) && permErr.permanent()
+}
+
+// parseModuleLoadError decodes the body of a failed readiness check. It returns
+// nil if the body does not describe a module load failure.
+func parseModuleLoadError(body []byte) *ModuleLoadError {
+	var payload struct {
+		Status      string             `json:"status"`
+		Diagnostics []ModuleDiagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Status != "error" {
+		return nil
+	}
+	return &ModuleLoadError{Diagnostics: payload.Diagnostics}
+}
@@ -30,15 +30,72 @@ func WithRequestTimeout(timeout time.Duration) ProcessManagerOption {
 	}
 }
 
-// WithYarnQueue sets the yarn installer with queue support
+// WithNodeLogLevel sets the default log level of the Node.js processes
+func WithNodeLogLevel(level string) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.nodeLogLevel = level
+	}
+}
+
+// WithRegistryConfig sets the npm registry, auth token and offline mirror used by yarn installs
+func WithRegistryConfig(registry RegistryConfig) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.registryConfig = registry
+	}
+}
+
+// WithDependencyPolicy sets the policy the declared dependencies must comply with
+func WithDependencyPolicy(policy *DependencyPolicy) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.dependencyPolicy = policy
+	}
+}
+
+// WithLockfileStrict makes a supplied yarn.lock that does not match the declared
+// dependencies an error before installing, instead of a warning. A lockfile rejected by
+// the immutable install is an error either way.
+func WithLockfileStrict(strict bool) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.lockfileStrict = strict
+	}
+}
+
+// WithStrictInstall makes any yarn install failure abort the process creation.
+// Without it, only unresolved packages do.
+func WithStrictInstall(strict bool) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.strictInstall = strict
+	}
+}
+
+// WithYarnQueue enables dependency installs, running at most maxConcurrentYarnInstalls at a time.
+// Each process manager owns its queue.
 func WithYarnQueue(maxConcurrentYarnInstalls int) ProcessManagerOption {
 	return func(pm *ProcessManager) {
-		// Initialize the global yarn queue if not already initialized
-		if GetYarnQueue() == nil {
-			InitializeYarnQueue(maxConcurrentYarnInstalls, pm.logger)
-		}
-		pm.yarnInstaller = NewYarnInstaller(GetYarnQueue(), pm.logger)
-		pm.dependencyResolver = NewDependencyResolver(pm.logger)
+		pm.maxConcurrentInstalls = maxConcurrentYarnInstalls
+	}
+}
+
+// WithYarnDirStrategy sets how the shared parts of /app/.yarn are provided to each workspace
+func WithYarnDirStrategy(strategy string) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.yarnDirStrategy = strategy
+	}
+}
+
+// WithWorkspaceManifest sets the manifest of the workspace packages bundled in the image.
+// When the file does not exist, the packages are listed with yarn.
+func WithWorkspaceManifest(manifest string) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.workspaceManifest = manifest
+	}
+}
+
+// WithInstallTimeout sets the timeout of a single dependency install, not counting the
+// time spent waiting in the queue. 0 means no timeout.
+func WithInstallTimeout(timeout time.Duration) ProcessManagerOption {
+	return func(pm *ProcessManager) {
+		pm.installTimeout = timeout
 	}
 }
 
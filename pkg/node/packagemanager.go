@@ -0,0 +1,137 @@
+package node
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// PackageManager installs the dependencies of a function workspace.
+// It is selected by spec.source.packageManager.
+type PackageManager interface {
+	// Name is the value of spec.source.packageManager selecting the package manager
+	Name() string
+	// Lockfile is the name of the lockfile in the workspace, e.g. "yarn.lock"
+	Lockfile() string
+	// WorkspaceProtocol is the protocol referencing the workspace packages of the server image
+	WorkspaceProtocol() string
+	// Prepare writes the package manager configuration in the workspace
+	Prepare(workDir string, registry RegistryConfig, logger logger.Logger) error
+	// Install installs the production dependencies of the job workspace.
+	// With job.Immutable set, the lockfile must be installed as is.
+	Install(job *YarnInstallJob) error
+}
+
+// npmPackageManager installs dependencies with the npm CLI bundled with Node.js
+type npmPackageManager struct{}
+
+// Name returns "npm"
+func (npmPackageManager) Name() string { return types.PackageManagerNpm }
+
+// Lockfile returns "package-lock.json"
+func (npmPackageManager) Lockfile() string { return "package-lock.json" }
+
+// WorkspaceProtocol returns "file:", npm does not support "link:"
+func (npmPackageManager) WorkspaceProtocol() string { return "file:" }
+
+// Prepare writes the registry settings to .npmrc
+func (npmPackageManager) Prepare(workDir string, registry RegistryConfig, logger logger.Logger) error {
+	return writeNpmrc(workDir, registry, "cache", logger)
+}
+
+// Install runs `npm ci` when a lockfile is supplied, `npm install` otherwise
+func (npmPackageManager) Install(job *YarnInstallJob) error {
+	command := "install"
+	if job.Immutable {
+		command = "ci"
+	}
+
+	if err := runInstallCommand(job, "npm", []string{command, "--omit=dev", "--no-audit", "--no-fund"}, nil, nil); err != nil {
+		return fmt.Errorf("npm %s failed: %w", command, err)
+	}
+	return nil
+}
+
+// pnpmPackageManager installs dependencies with the pnpm CLI installed in the server image
+type pnpmPackageManager struct{}
+
+// Name returns "pnpm"
+func (pnpmPackageManager) Name() string { return types.PackageManagerPnpm }
+
+// Lockfile returns "pnpm-lock.yaml"
+func (pnpmPackageManager) Lockfile() string { return "pnpm-lock.yaml" }
+
+// WorkspaceProtocol returns "link:"
+func (pnpmPackageManager) WorkspaceProtocol() string { return "link:" }
+
+// Prepare writes the registry settings to .npmrc, which pnpm also reads
+func (pnpmPackageManager) Prepare(workDir string, registry RegistryConfig, logger logger.Logger) error {
+	return writeNpmrc(workDir, registry, "store-dir", logger)
+}
+
+// Install runs `pnpm install --prod`, with a frozen lockfile when one is supplied
+func (pnpmPackageManager) Install(job *YarnInstallJob) error {
+	lockfileFlag := "--no-frozen-lockfile"
+	if job.Immutable {
+		lockfileFlag = "--frozen-lockfile"
+	}
+
+	if err := runInstallCommand(job, "pnpm", []string{"install", "--prod", lockfileFlag}, nil, nil); err != nil {
+		return fmt.Errorf("pnpm install failed: %w", err)
+	}
+	return nil
+}
+
+// writeNpmrc writes the registry settings to the workspace .npmrc, if any is configured
+func writeNpmrc(workDir string, registry RegistryConfig, cacheKey string, logger logger.Logger) error {
+	npmrc, err := registry.npmrc(cacheKey)
+	if err != nil {
+		return err
+	}
+	if npmrc == "" {
+		return nil
+	}
+
+	if err := os.WriteFile(filepath.Join(workDir, ".npmrc"), []byte(npmrc), 0600); err != nil {
+		return &RegistryConfigError{Err: fmt.Errorf("failed to write .npmrc: %w", err)}
+	}
+
+	logger.WithField("npm_registry", registry.Server).
+		WithField("cache_folder", registry.CacheFolder).
+		WithField("offline_only", registry.OfflineOnly).
+		Info("Applied registry configuration to .npmrc")
+	return nil
+}
+
+// runInstallCommand runs a package manager command in the job workspace, logging its
+// output. Stdout is also written to report when it is not nil.
+func runInstallCommand(job *YarnInstallJob, name string, args []string, env []string, report io.Writer) error {
+	cmd := exec.CommandContext(job.Context, name, args...)
+	cmd.Dir = job.WorkDir
+	cmd.Env = append(os.Environ(), env...)
+
+	// Create custom logWriters for the command output
+	stdoutWriter := &logWriter{
+		logger:     job.Logger.WithField(name, "stdout"),
+		prefix:     fmt.Sprintf("%s[%s]: ", name, job.SpecHash[:8]),
+		streamType: "stdout",
+	}
+	stderrWriter := &logWriter{
+		logger:     job.Logger.WithField(name, "stderr"),
+		prefix:     fmt.Sprintf("%s[%s]: ", name, job.SpecHash[:8]),
+		streamType: "stderr",
+	}
+
+	cmd.Stdout = stdoutWriter
+	if report != nil {
+		cmd.Stdout = io.MultiWriter(stdoutWriter, report)
+	}
+	cmd.Stderr = stderrWriter
+
+	return cmd.Run()
+}
@@ -0,0 +1,199 @@
+package node
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRegistryConfigNpmrc(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		config   RegistryConfig
+		cacheKey string
+		want     string
+	}{
+		{
+			name:     "zero value",
+			cacheKey: "cache",
+			want:     "",
+		},
+		{
+			name: "private registry",
+			config: RegistryConfig{
+				Server:        "https://npm.example.com/repository/npm",
+				AuthTokenFile: tokenFile,
+			},
+			cacheKey: "cache",
+			want:     "registry=https://npm.example.com/repository/npm\n//npm.example.com/repository/npm/:_authToken=s3cr3t\n",
+		},
+		{
+			name:     "token for the default registry",
+			config:   RegistryConfig{AuthTokenFile: tokenFile},
+			cacheKey: "cache",
+			want:     "//registry.npmjs.org/:_authToken=s3cr3t\n",
+		},
+		{
+			name:     "offline pnpm store",
+			config:   RegistryConfig{CacheFolder: "/mirror", OfflineOnly: true},
+			cacheKey: "store-dir",
+			want:     "store-dir=/mirror\noffline=true\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.config.npmrc(tt.cacheKey)
+			if err != nil {
+				t.Fatalf("npmrc() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("npmrc() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	var registryErr *RegistryConfigError
+	if _, err := (RegistryConfig{AuthTokenFile: "/nonexistent"}).npmrc("cache"); !errors.As(err, &registryErr) {
+		t.Errorf("npmrc() with a missing token file error = %v, want a *RegistryConfigError", err)
+	}
+}
+
+func TestYarnPrepareFailures(t *testing.T) {
+	appDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(appDir, ".yarnrc.yml"), []byte("yarnPath: [unclosed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manager := &yarnPackageManager{appDir: appDir, dirStrategy: types.YarnDirCopy}
+	log := logger.NewLogrusLogger("error", "text")
+
+	// Without registry settings, the install reports what is missing
+	if err := manager.Prepare(t.TempDir(), RegistryConfig{}, log); err != nil {
+		t.Errorf("Prepare() error = %v, want none without registry configuration", err)
+	}
+
+	var registryErr *RegistryConfigError
+	if err := manager.Prepare(t.TempDir(), RegistryConfig{Server: "https://npm.example.com"}, log); !errors.As(err, &registryErr) {
+		t.Errorf("Prepare() error = %v, want a *RegistryConfigError", err)
+	}
+}
+
+func TestYarnPrepareCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		yarnrc   string
+		registry RegistryConfig
+		want     map[string]interface{}
+	}{
+		{
+			name: "global cache by default",
+			want: map[string]interface{}{"enableGlobalCache": true},
+		},
+		{
+			name:   "cache folder of the image",
+			yarnrc: "cacheFolder: .yarn/cache\n",
+			want:   map[string]interface{}{"cacheFolder": "APPDIR/.yarn/cache"},
+		},
+		{
+			name:     "offline mirror",
+			registry: RegistryConfig{CacheFolder: "/mirror"},
+			want:     map[string]interface{}{"cacheFolder": "/mirror", "enableGlobalCache": false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			appDir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(appDir, ".yarnrc.yml"), []byte(tt.yarnrc), 0644); err != nil {
+				t.Fatal(err)
+			}
+			manager := &yarnPackageManager{appDir: appDir, dirStrategy: types.YarnDirReference}
+
+			workDir := t.TempDir()
+			if err := manager.Prepare(workDir, tt.registry, logger.NewLogrusLogger("error", "text")); err != nil {
+				t.Fatalf("Prepare() error = %v", err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(workDir, ".yarnrc.yml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := yaml.Unmarshal(content, &got); err != nil {
+				t.Fatal(err)
+			}
+			for key, want := range tt.want {
+				if s, ok := want.(string); ok {
+					want = strings.Replace(s, "APPDIR", appDir, 1)
+				}
+				if got[key] != want {
+					t.Errorf("%s = %v, want %v", key, got[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePackageJSONWorkspaceProtocol(t *testing.T) {
+	installer := NewYarnInstaller(nil, logger.NewLogrusLogger("error", "text"))
+
+	tests := []struct {
+		manager string
+		want    string
+	}{
+		{manager: "yarn", want: "link:/app/packages/sdk"},
+		{manager: "npm", want: "file:/app/packages/sdk"},
+		{manager: "pnpm", want: "link:/app/packages/sdk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.manager, func(t *testing.T) {
+			manager, err := installer.PackageManager(tt.manager)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			workDir := t.TempDir()
+			deps := map[string]interface{}{
+				"@crossplane-js/sdk": "link:/app/packages/sdk",
+				"lodash":             "^4.17.21",
+			}
+			if err := installer.CreatePackageJSON(workDir, deps, manager, logger.NewLogrusLogger("error", "text")); err != nil {
+				t.Fatal(err)
+			}
+
+			content, err := os.ReadFile(filepath.Join(workDir, "package.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var packageJSON struct {
+				Dependencies map[string]string `json:"dependencies"`
+			}
+			if err := json.Unmarshal(content, &packageJSON); err != nil {
+				t.Fatal(err)
+			}
+			if got := packageJSON.Dependencies["@crossplane-js/sdk"]; got != tt.want {
+				t.Errorf("workspace dependency = %q, want %q", got, tt.want)
+			}
+			if got := packageJSON.Dependencies["lodash"]; got != "^4.17.21" {
+				t.Errorf("registry dependency = %q, want unchanged", got)
+			}
+		})
+	}
+
+	if _, err := installer.PackageManager("bun"); err == nil {
+		t.Error("PackageManager(bun) should fail")
+	}
+}
@@ -0,0 +1,258 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// DependencyPolicy restricts the dependencies a function may declare.
+// The zero value allows everything.
+type DependencyPolicy struct {
+	// Allow lists the only packages that may be used. Empty allows every package.
+	Allow []PackageRule `json:"allow,omitempty"`
+	// Deny lists packages that must not be used, checked after Allow
+	Deny []PackageRule `json:"deny,omitempty"`
+	// RejectNonRegistry rejects git, file, link, http and other non-registry specifiers
+	RejectNonRegistry bool `json:"rejectNonRegistry,omitempty"`
+	// RejectUnboundedRanges rejects ranges without upper bound such as "*", ">=1" or "latest"
+	RejectUnboundedRanges bool `json:"rejectUnboundedRanges,omitempty"`
+	// RequireYarnLock requires a lockfile (yarnLock, packageLock or pnpmLock, depending on
+	// the package manager) whenever dependencies are declared
+	RequireYarnLock bool `json:"requireYarnLock,omitempty"`
+	// MaxDependencies caps the number of declared dependencies. 0 means no limit.
+	MaxDependencies int `json:"maxDependencies,omitempty"`
+}
+
+// PackageRule matches packages by name and optionally by version
+type PackageRule struct {
+	// Name is a package name or glob, e.g. "lodash", "@acme/*"
+	Name string `json:"name"`
+	// Version is an npm version range, e.g. "<1.0.0". An allow rule matches when every
+	// version of the declared range satisfies it, a deny rule when any version does.
+	// Empty matches any version.
+	Version string `json:"version,omitempty"`
+
+	versionRange versionRange
+}
+
+// PolicyViolation is one dependency rejected by the policy
+type PolicyViolation struct {
+	Dependency string `json:"dependency,omitempty"`
+	Specifier  string `json:"specifier,omitempty"`
+	Reason     string `json:"reason"`
+}
+
+// String renders the violation as "name@specifier: reason"
+func (v PolicyViolation) String() string {
+	if v.Dependency == "" {
+		return v.Reason
+	}
+	return fmt.Sprintf("%s@%s: %s", v.Dependency, v.Specifier, v.Reason)
+}
+
+// PolicyViolationError is returned when dependencies do not comply with the policy.
+// It lists every violation found, not only the first one.
+type PolicyViolationError struct {
+	Violations []PolicyViolation
+}
+
+// Error lists every violation
+func (e *PolicyViolationError) Error() string {
+	lines := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		lines = append(lines, v.String())
+	}
+	return "dependency policy violations: " + strings.Join(lines, "; ")
+}
+
+// permanent marks the error as not worth retrying
+func (e *PolicyViolationError) permanent() bool { return true }
+
+// nonRegistryPrefixes are specifier prefixes that do not resolve from the npm registry
+var nonRegistryPrefixes = []string{
+	"git:", "git+", "github:", "gitlab:", "bitbucket:", "http:", "https:",
+	"file:", "link:", "portal:", "workspace:", "patch:", "exec:",
+}
+
+// LoadDependencyPolicy reads a policy from a YAML or JSON file
+func LoadDependencyPolicy(file string) (*DependencyPolicy, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dependency policy file: %w", err)
+	}
+
+	policy := &DependencyPolicy{}
+	if err := yaml.UnmarshalStrict(content, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse dependency policy file %s: %w", file, err)
+	}
+	if err := policy.compile(); err != nil {
+		return nil, fmt.Errorf("invalid dependency policy file %s: %w", file, err)
+	}
+
+	return policy, nil
+}
+
+// compile checks the rules and parses their version ranges
+func (p *DependencyPolicy) compile() error {
+	if p.MaxDependencies < 0 {
+		return fmt.Errorf("maxDependencies must not be negative")
+	}
+	for _, rules := range [][]PackageRule{p.Allow, p.Deny} {
+		for i := range rules {
+			rule := &rules[i]
+			if rule.Name == "" {
+				return fmt.Errorf("package rule without name")
+			}
+			if _, err := path.Match(rule.Name, ""); err != nil {
+				return fmt.Errorf("invalid package name pattern %q: %w", rule.Name, err)
+			}
+			if rule.Version != "" {
+				r, err := parseVersionRange(rule.Version)
+				if err != nil {
+					return fmt.Errorf("invalid version range %q for %s: %w", rule.Version, rule.Name, err)
+				}
+				rule.versionRange = r
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the declared dependencies against the policy. Workspace packages,
+// provided by the server image, are only counted. It returns a *PolicyViolationError
+// listing every violation.
+func (p *DependencyPolicy) Validate(dependencies map[string]string, lockfile string, workspaceMap map[string]string) error {
+	if p == nil {
+		return nil
+	}
+
+	var violations []PolicyViolation
+
+	if p.MaxDependencies > 0 && len(dependencies) > p.MaxDependencies {
+		violations = append(violations, PolicyViolation{
+			Reason: fmt.Sprintf("%d dependencies declared, at most %d allowed", len(dependencies), p.MaxDependencies),
+		})
+	}
+	if p.RequireYarnLock && len(dependencies) > 0 && strings.TrimSpace(lockfile) == "" {
+		violations = append(violations, PolicyViolation{
+			Reason: "a lockfile is required when dependencies are declared",
+		})
+	}
+
+	// Sort names so violations are reported in a stable order
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, isWorkspace := workspaceMap[name]; isWorkspace {
+			continue
+		}
+		specifier := dependencies[name]
+		for _, reason := range p.checkDependency(name, specifier) {
+			violations = append(violations, PolicyViolation{Dependency: name, Specifier: specifier, Reason: reason})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyViolationError{Violations: violations}
+	}
+	return nil
+}
+
+// checkDependency returns the reasons why a dependency violates the policy
+func (p *DependencyPolicy) checkDependency(name, specifier string) []string {
+	var reasons []string
+
+	packageName, versionSpec, registry := parseSpecifier(name, specifier)
+	if !registry && p.RejectNonRegistry {
+		reasons = append(reasons, "only registry dependencies are allowed")
+	}
+
+	// Version rules and range checks only apply to registry specifiers
+	var declared versionRange
+	if registry {
+		if r, err := parseVersionRange(versionSpec); err == nil {
+			declared = r
+		}
+		if p.RejectUnboundedRanges && (declared == nil || declared.unbounded()) {
+			reasons = append(reasons, "version range has no upper bound")
+		}
+	}
+
+	if len(p.Allow) > 0 && !matchesAnyRule(p.Allow, packageName, declared, false) {
+		reasons = append(reasons, "package is not in the allow list")
+	}
+	if matchesAnyRule(p.Deny, packageName, declared, true) {
+		reasons = append(reasons, "package is denied")
+	}
+
+	return reasons
+}
+
+// matchesAnyRule reports whether a package matches one of the rules. The package manager
+// may install any version of the declared range, so a deny rule with a version matches
+// when the ranges intersect, and an allow rule when the declared range is contained in
+// the rule. A declared range that cannot be evaluated only matches deny rules.
+func matchesAnyRule(rules []PackageRule, packageName string, declared versionRange, deny bool) bool {
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Name, packageName); !ok {
+			continue
+		}
+		if rule.versionRange == nil {
+			return true
+		}
+		if declared == nil {
+			if deny {
+				return true
+			}
+			continue
+		}
+		if deny && declared.intersects(rule.versionRange) {
+			return true
+		}
+		if !deny && declared.containedIn(rule.versionRange) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSpecifier returns the package actually installed for a dependency, its version
+// range, and whether it resolves from the registry. "npm:" aliases are followed.
+func parseSpecifier(name, specifier string) (string, string, bool) {
+	specifier = strings.TrimSpace(specifier)
+
+	if alias, ok := strings.CutPrefix(specifier, "npm:"); ok {
+		// "npm:^1.0.0" is a range of the package itself
+		if _, err := parseVersionRange(alias); err == nil {
+			return name, alias, true
+		}
+		// "npm:other", "npm:other@^1.0.0", "npm:@scope/other" or "npm:@scope/other@^1.0.0".
+		// The "@" of a scope is not the version separator.
+		if at := strings.Index(alias[1:], "@"); at >= 0 {
+			return alias[:at+1], alias[at+2:], true
+		}
+		return alias, "", true
+	}
+
+	for _, prefix := range nonRegistryPrefixes {
+		if strings.HasPrefix(specifier, prefix) {
+			return name, specifier, false
+		}
+	}
+
+	// Paths and GitHub shorthands ("user/repo")
+	if strings.ContainsAny(specifier, "/\\") {
+		return name, specifier, false
+	}
+
+	return name, specifier, true
+}
@@ -0,0 +1,264 @@
+package node
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVersionRange(t *testing.T) {
+	cases := []struct {
+		rng       string
+		version   string
+		satisfied bool
+		lower     string
+		unbounded bool
+	}{
+		{rng: "^1.2.3", version: "v1.9.0", satisfied: true, lower: "v1.2.3"},
+		{rng: "^1.2.3", version: "v2.0.0", satisfied: false, lower: "v1.2.3"},
+		{rng: "^0.2.3", version: "v0.3.0", satisfied: false, lower: "v0.2.3"},
+		{rng: "~1.2.3", version: "v1.2.9", satisfied: true, lower: "v1.2.3"},
+		{rng: "~1.2.3", version: "v1.3.0", satisfied: false, lower: "v1.2.3"},
+		{rng: "1.2.x", version: "v1.2.7", satisfied: true, lower: "v1.2.0"},
+		{rng: "1", version: "v1.99.0", satisfied: true, lower: "v1.0.0"},
+		{rng: "1.0.0 - 2.0.0", version: "v2.0.0", satisfied: true, lower: "v1.0.0"},
+		{rng: ">= 1.0.0 <1.5", version: "v1.5.0", satisfied: false, lower: "v1.0.0"},
+		{rng: "<1.0.0", version: "v0.9.0", satisfied: true, lower: "v0.0.0"},
+		{rng: "^1 || ^3", version: "v3.1.0", satisfied: true, lower: "v1.0.0"},
+		{rng: "=2.1.0", version: "v2.1.0", satisfied: true, lower: "v2.1.0"},
+		{rng: ">=1.0.0", version: "v9.0.0", satisfied: true, lower: "v1.0.0", unbounded: true},
+		{rng: "*", version: "v0.0.1", satisfied: true, lower: "v0.0.0", unbounded: true},
+		{rng: "^1 || >2", version: "v5.0.0", satisfied: true, lower: "v1.0.0", unbounded: true},
+	}
+
+	for _, tc := range cases {
+		r, err := parseVersionRange(tc.rng)
+		if err != nil {
+			t.Fatalf("parseVersionRange(%q): %v", tc.rng, err)
+		}
+		if got := r.satisfiedBy(tc.version); got != tc.satisfied {
+			t.Errorf("%q satisfiedBy(%s) = %v, want %v", tc.rng, tc.version, got, tc.satisfied)
+		}
+		if got := r.lowerBound(); got != tc.lower {
+			t.Errorf("%q lowerBound() = %s, want %s", tc.rng, got, tc.lower)
+		}
+		if got := r.unbounded(); got != tc.unbounded {
+			t.Errorf("%q unbounded() = %v, want %v", tc.rng, got, tc.unbounded)
+		}
+	}
+
+	for _, invalid := range []string{"latest", "1.2.3.4", "^abc"} {
+		if _, err := parseVersionRange(invalid); err == nil {
+			t.Errorf("parseVersionRange(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestVersionRangeRelations(t *testing.T) {
+	cases := []struct {
+		declared   string
+		rule       string
+		intersects bool
+		contained  bool
+	}{
+		{declared: "^1.2.0", rule: ">=1.0.0", intersects: true, contained: true},
+		{declared: "^1.0.0 || ^2.0.0", rule: ">=2.0.0", intersects: true, contained: false},
+		{declared: ">=4", rule: "<5", intersects: true, contained: false},
+		{declared: "^4.1.0", rule: "<5", intersects: true, contained: true},
+		{declared: "^3.0.0", rule: ">=4.0.0", intersects: false, contained: false},
+		{declared: "<2.0.0", rule: ">=2.0.0", intersects: false, contained: false},
+		{declared: "<=2.0.0", rule: ">=2.0.0", intersects: true, contained: false},
+		{declared: "^1 || ^2", rule: "<2.0.0 || >=2.0.0 <3.0.0", intersects: true, contained: true},
+		{declared: "3.3.6", rule: "3.3.6", intersects: true, contained: true},
+		{declared: "*", rule: "<1.0.0", intersects: true, contained: false},
+	}
+
+	for _, tc := range cases {
+		declared, err := parseVersionRange(tc.declared)
+		if err != nil {
+			t.Fatalf("parseVersionRange(%q): %v", tc.declared, err)
+		}
+		rule, err := parseVersionRange(tc.rule)
+		if err != nil {
+			t.Fatalf("parseVersionRange(%q): %v", tc.rule, err)
+		}
+		if got := declared.intersects(rule); got != tc.intersects {
+			t.Errorf("%q intersects(%q) = %v, want %v", tc.declared, tc.rule, got, tc.intersects)
+		}
+		if got := declared.containedIn(rule); got != tc.contained {
+			t.Errorf("%q containedIn(%q) = %v, want %v", tc.declared, tc.rule, got, tc.contained)
+		}
+	}
+}
+
+func TestParseSpecifier(t *testing.T) {
+	cases := []struct {
+		name, specifier string
+		packageName     string
+		version         string
+	}{
+		{"lodash", "^4.17.21", "lodash", "^4.17.21"},
+		{"lodash", "npm:^4.17.21", "lodash", "^4.17.21"},
+		{"lodash", "npm:evil-pkg", "evil-pkg", ""},
+		{"lodash", "npm:evil-pkg@^1.0.0", "evil-pkg", "^1.0.0"},
+		{"lodash", "npm:@evil/pkg", "@evil/pkg", ""},
+		{"lodash", "npm:@evil/pkg@~2.0.0", "@evil/pkg", "~2.0.0"},
+	}
+	for _, tc := range cases {
+		packageName, version, registry := parseSpecifier(tc.name, tc.specifier)
+		if packageName != tc.packageName || version != tc.version || !registry {
+			t.Errorf("parseSpecifier(%q, %q) = %q, %q, %v, want %q, %q, true",
+				tc.name, tc.specifier, packageName, version, registry, tc.packageName, tc.version)
+		}
+	}
+}
+
+func TestDependencyPolicyValidate(t *testing.T) {
+	policy := &DependencyPolicy{
+		Allow: []PackageRule{
+			{Name: "lodash"},
+			{Name: "@acme/*"},
+			{Name: "left-pad", Version: ">=1.3.0"},
+			{Name: "express"},
+		},
+		Deny: []PackageRule{
+			{Name: "express", Version: "<4.0.0"},
+		},
+		RejectNonRegistry:     true,
+		RejectUnboundedRanges: true,
+		RequireYarnLock:       true,
+		MaxDependencies:       5,
+	}
+	if err := policy.compile(); err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+
+	t.Run("compliant", func(t *testing.T) {
+		deps := map[string]string{
+			"lodash":      "^4.17.21",
+			"@acme/utils": "~1.2.0",
+			"left-pad":    "^1.3.0",
+			"express":     "npm:express@^4.18.0",
+			"@xfuncjs/ws": "link:packages/ws",
+		}
+		workspaces := map[string]string{"@xfuncjs/ws": "packages/ws"}
+		if err := policy.Validate(deps, "lockfile", workspaces); err != nil {
+			t.Errorf("expected no violation, got %v", err)
+		}
+	})
+
+	t.Run("violations", func(t *testing.T) {
+		deps := map[string]string{
+			"lodash":   "*",
+			"left-pad": "^1.0.0",
+			"express":  "^3.0.0",
+			"chalk":    "^5.0.0",
+			"@acme/ui": "github:acme/ui",
+			"debug":    "latest",
+		}
+		err := policy.Validate(deps, "", nil)
+
+		var policyErr *PolicyViolationError
+		if !errors.As(err, &policyErr) {
+			t.Fatalf("expected *PolicyViolationError, got %v", err)
+		}
+		if !isPermanent(err) {
+			t.Error("policy violations should be permanent")
+		}
+
+		got := make([]string, 0, len(policyErr.Violations))
+		for _, v := range policyErr.Violations {
+			got = append(got, v.String())
+		}
+		want := []string{
+			"6 dependencies declared, at most 5 allowed",
+			"a lockfile is required when dependencies are declared",
+			"@acme/ui@github:acme/ui: only registry dependencies are allowed",
+			"chalk@^5.0.0: package is not in the allow list",
+			"debug@latest: version range has no upper bound",
+			"debug@latest: package is not in the allow list",
+			"express@^3.0.0: package is denied",
+			"left-pad@^1.0.0: package is not in the allow list",
+			"lodash@*: version range has no upper bound",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("violations =\n%q\nwant\n%q", got, want)
+		}
+	})
+
+	t.Run("bypasses", func(t *testing.T) {
+		bypasses := &DependencyPolicy{
+			Allow: []PackageRule{{Name: "lodash"}, {Name: "express", Version: "<5"}, {Name: "event-stream"}},
+			Deny:  []PackageRule{{Name: "event-stream", Version: ">=2.0.0"}},
+		}
+		if err := bypasses.compile(); err != nil {
+			t.Fatalf("compile: %v", err)
+		}
+		deps := map[string]string{
+			"lodash":       "npm:evil-pkg",
+			"lodash-es":    "npm:@evil/pkg",
+			"express":      ">=4",
+			"event-stream": "^1.0.0 || ^2.0.0",
+		}
+		err := bypasses.Validate(deps, "", nil)
+
+		var policyErr *PolicyViolationError
+		if !errors.As(err, &policyErr) {
+			t.Fatalf("expected *PolicyViolationError, got %v", err)
+		}
+		got := make([]string, 0, len(policyErr.Violations))
+		for _, v := range policyErr.Violations {
+			got = append(got, v.String())
+		}
+		want := []string{
+			"event-stream@^1.0.0 || ^2.0.0: package is denied",
+			"express@>=4: package is not in the allow list",
+			"lodash@npm:evil-pkg: package is not in the allow list",
+			"lodash-es@npm:@evil/pkg: package is not in the allow list",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("violations =\n%q\nwant\n%q", got, want)
+		}
+	})
+
+	t.Run("nil policy", func(t *testing.T) {
+		var nilPolicy *DependencyPolicy
+		if err := nilPolicy.Validate(map[string]string{"x": "git+https://example.com/x.git"}, "", nil); err != nil {
+			t.Errorf("nil policy should allow everything, got %v", err)
+		}
+	})
+}
+
+func TestLoadDependencyPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	valid := filepath.Join(dir, "policy.yaml")
+	if err := os.WriteFile(valid, []byte("deny:\n  - name: event-stream\n    version: \"3.3.6\"\nmaxDependencies: 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := LoadDependencyPolicy(valid)
+	if err != nil {
+		t.Fatalf("LoadDependencyPolicy: %v", err)
+	}
+	if policy.MaxDependencies != 10 || len(policy.Deny) != 1 || policy.Deny[0].versionRange == nil {
+		t.Errorf("unexpected policy: %+v", policy)
+	}
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("deny:\n  - name: lodash\n    version: \"not a range\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDependencyPolicy(invalid); err == nil {
+		t.Error("expected an error for an invalid version range")
+	}
+
+	unknown := filepath.Join(dir, "unknown.yaml")
+	if err := os.WriteFile(unknown, []byte("denyList: []\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadDependencyPolicy(unknown); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
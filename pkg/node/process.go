@@ -2,7 +2,7 @@ package node
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
@@ -10,24 +10,35 @@ import (
 	"sync"
 	"time"
 
-	"github.com/socialgouv/xfuncjs-server/pkg/hash"
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
 	"github.com/socialgouv/xfuncjs-server/pkg/types"
 )
 
 // ProcessManager manages Node.js processes
 type ProcessManager struct {
-	processes           map[string]*ProcessInfo
-	lock                sync.RWMutex
-	gcInterval          time.Duration
-	idleTimeout         time.Duration
-	tempDir             string
-	logger              logger.Logger
-	healthCheckWait     time.Duration
-	healthCheckInterval time.Duration
-	requestTimeout      time.Duration
-	yarnInstaller       *YarnInstaller
-	dependencyResolver  *DependencyResolver
+	processes             map[string]*ProcessInfo
+	processesLock         sync.RWMutex // Guards processes, never held during an install
+	lock                  sync.Mutex   // Serializes process creation
+	gcInterval            time.Duration
+	idleTimeout           time.Duration
+	tempDir               string
+	logger                logger.Logger
+	healthCheckWait       time.Duration
+	healthCheckInterval   time.Duration
+	requestTimeout        time.Duration
+	nodeLogLevel          string
+	registryConfig        RegistryConfig
+	dependencyPolicy      *DependencyPolicy
+	lockfileStrict        bool
+	strictInstall         bool
+	maxConcurrentInstalls int
+	installTimeout        time.Duration
+	installQueue          *YarnQueue
+	yarnDirStrategy       string
+	workspaceManifest     string
+	workspaces            *WorkspaceRegistry
+	yarnInstaller         *YarnInstaller
+	dependencyResolver    *DependencyResolver
 }
 
 // NewProcessManager creates a new process manager
@@ -43,9 +54,13 @@ func NewProcessManager(gcInterval, idleTimeout time.Duration, tempDir string, lo
 		idleTimeout:         idleTimeout,
 		tempDir:             tempDir,
 		logger:              logger,
-		healthCheckWait:     60 * time.Second, // Default timeout for health check
-		healthCheckInterval: 1 * time.Second,  // Default interval for health check polling
-		requestTimeout:      5 * time.Second,  // Default timeout for requests
+		healthCheckWait:     60 * time.Second,       // Default timeout for health check
+		healthCheckInterval: 1 * time.Second,        // Default interval for health check polling
+		requestTimeout:      5 * time.Second,        // Default timeout for requests
+		nodeLogLevel:        "info",                 // Default log level of the Node.js processes
+		installTimeout:      10 * time.Minute,       // Default timeout of a dependency install
+		yarnDirStrategy:     types.YarnDirReference, // Default .yarn strategy, shared parts used in place
+		workspaceManifest:   DefaultWorkspaceManifest,
 	}
 
 	// Apply options
@@ -53,20 +68,74 @@ func NewProcessManager(gcInterval, idleTimeout time.Duration, tempDir string, lo
 		opt(pm)
 	}
 
+	pm.workspaces = NewWorkspaceRegistry("/app", pm.workspaceManifest, logger)
+
+	// Each process manager owns its install queue, so several managers can coexist
+	if pm.maxConcurrentInstalls > 0 {
+		pm.installQueue = NewYarnQueue(pm.maxConcurrentInstalls, pm.installTimeout, logger)
+		pm.yarnInstaller = NewYarnInstaller(pm.installQueue, logger)
+		pm.yarnInstaller.SetRegistryConfig(pm.registryConfig)
+		pm.yarnInstaller.SetYarnDirStrategy(pm.yarnDirStrategy)
+	}
+
+	// Dependencies are resolved and validated even without yarn queue
+	if pm.dependencyResolver == nil {
+		pm.dependencyResolver = NewDependencyResolver(logger)
+	}
+	pm.dependencyResolver.SetPolicy(pm.dependencyPolicy)
+
 	// Start the garbage collector
 	pm.startGarbageCollector()
 
 	return pm, nil
 }
 
+// getProcess returns the process of the spec hash
+func (pm *ProcessManager) getProcess(specHash string) (*ProcessInfo, bool) {
+	pm.processesLock.RLock()
+	defer pm.processesLock.RUnlock()
+	process, ok := pm.processes[specHash]
+	return process, ok
+}
+
+// setProcess stores the process of the spec hash
+func (pm *ProcessManager) setProcess(specHash string, process *ProcessInfo) {
+	pm.processesLock.Lock()
+	defer pm.processesLock.Unlock()
+	pm.processes[specHash] = process
+}
+
+// deleteProcess removes the process of the spec hash, unless it was replaced by another
+// process meanwhile. It reports whether the process was removed.
+func (pm *ProcessManager) deleteProcess(specHash string, process *ProcessInfo) bool {
+	pm.processesLock.Lock()
+	defer pm.processesLock.Unlock()
+	if pm.processes[specHash] != process {
+		return false
+	}
+	delete(pm.processes, specHash)
+	return true
+}
+
+// snapshotProcesses returns a copy of the processes by spec hash, which does not wait
+// for the processes being created
+func (pm *ProcessManager) snapshotProcesses() map[string]*ProcessInfo {
+	pm.processesLock.RLock()
+	defer pm.processesLock.RUnlock()
+	processes := make(map[string]*ProcessInfo, len(pm.processes))
+	for specHash, process := range pm.processes {
+		processes[specHash] = process
+	}
+	return processes
+}
+
 // getOrCreateProcess gets an existing process for the given input or creates a new one
 func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.XFuncJSInput) (*ProcessInfo, error) {
 	// Generate hash based on the entire input spec
-	specBytes, err := json.Marshal(input.Spec)
+	specHash, err := computeSpecHash(input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal input spec: %w", err)
+		return nil, err
 	}
-	specHash := hash.GenerateInputHash(specBytes)
 
 	// Create a logger with spec hash information
 	procLogger := pm.logger.WithField(logger.FieldCodeHash, specHash[:8])
@@ -74,9 +143,7 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 	procLogger = procLogger.WithField(logger.FieldOperation, "process-create")
 
 	// Check if we already have a process for this input
-	pm.lock.RLock()
-	process, exists := pm.processes[specHash]
-	pm.lock.RUnlock()
+	process, exists := pm.getProcess(specHash)
 
 	if exists {
 		// Verify the process is still healthy before returning it
@@ -85,28 +152,29 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 			pm.restartProcess(process, specHash)
 		} else {
 			procLogger.Debug("Reusing existing process")
+			pm.applyLogLevel(ctx, process, input, procLogger)
 			return process, nil
 		}
 	}
 
-	// Create a new process
+	// Create a new process. The map of processes stays readable meanwhile, so other
+	// functions and the admin API are not blocked by the install.
 	pm.lock.Lock()
 	defer pm.lock.Unlock()
 
 	// Check again in case another goroutine created the process while we were waiting
-	if process, exists = pm.processes[specHash]; exists {
+	if process, exists = pm.getProcess(specHash); exists {
 		if pm.isProcessHealthy(process) {
 			procLogger.Debug("Reusing existing process (created by another goroutine)")
+			pm.applyLogLevel(ctx, process, input, procLogger)
 			return process, nil
 		}
 		// Process exists but is unhealthy, remove it
 		procLogger.Warn("Existing process is unhealthy, removing it")
-		delete(pm.processes, specHash)
+		pm.deleteProcess(specHash, process)
 	}
 
 	// Create a unique directory for this input
-	extension := ".ts"
-	tempFilename := hash.GenerateTempFilename(input.Spec.Source.Inline, extension)
 	uniqueDirName := specHash[:16] // Use first 16 chars of hash
 	uniqueDirPath := filepath.Join(pm.tempDir, uniqueDirName)
 
@@ -115,23 +183,46 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 		return nil, fmt.Errorf("failed to create unique directory %s: %w", uniqueDirPath, err)
 	}
 
-	// Place the temporary file in the unique directory
-	tempFilePath := filepath.Join(uniqueDirPath, tempFilename)
-	procLogger = procLogger.WithField("temp_file", tempFilePath)
-
-	// Write the code to the temporary file
-	if err := os.WriteFile(tempFilePath, []byte(input.Spec.Source.Inline), 0644); err != nil {
-		return nil, fmt.Errorf("failed to write code to temporary file %s: %w", tempFilePath, err)
+	// Write the source files in the unique directory
+	tempFilePath, err := writeSource(uniqueDirPath, input)
+	if err != nil {
+		if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+			procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+				Warn("Failed to remove temporary directory after source write failure")
+		}
+		return nil, err
 	}
+	procLogger = procLogger.WithField("temp_file", tempFilePath)
 
 	// Discover workspace packages
-	workspaceMap, err := GetWorkspacePackages("/app", procLogger)
+	workspaceMap, err := pm.workspaces.Packages()
 	if err != nil {
 		procLogger.WithField(logger.FieldError, err.Error()).
 			Warn("Failed to discover workspace packages, workspace dependencies may not work correctly")
 		workspaceMap = make(map[string]string) // Use empty map as fallback
 	}
 
+	// Check the declared dependencies against the dependency policy
+	if err := pm.dependencyResolver.ValidateDependencies(input.Spec.Source.Dependencies, input.Lockfile(), workspaceMap); err != nil {
+		procLogger.WithField(logger.FieldError, err.Error()).Error("Dependencies rejected by the dependency policy")
+		if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+			procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+				Warn("Failed to remove temporary directory after dependency policy violation")
+		}
+		return nil, err
+	}
+
+	// Select the package manager installing the dependencies
+	manager, err := pm.yarnInstaller.PackageManager(input.Spec.Source.PackageManager)
+	if err != nil {
+		if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+			procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+				Warn("Failed to remove temporary directory after package manager selection failure")
+		}
+		return nil, err
+	}
+	procLogger = procLogger.WithField("package_manager", manager.Name())
+
 	// Resolve dependencies
 	dependencies, err := pm.dependencyResolver.ResolveDependencies(input.Spec.Source.Dependencies, workspaceMap, "/app", procLogger)
 	if err != nil {
@@ -147,7 +238,7 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 	}
 
 	// Create package.json
-	if err := pm.yarnInstaller.CreatePackageJSON(uniqueDirPath, dependencies, procLogger); err != nil {
+	if err := pm.yarnInstaller.CreatePackageJSON(uniqueDirPath, dependencies, manager, procLogger); err != nil {
 		// Clean up the temporary directory
 		if uniqueDirPath != "" {
 			procLogger.Info("Removing temporary directory after package.json creation failure")
@@ -159,22 +250,79 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 		return nil, fmt.Errorf("failed to create package.json: %w", err)
 	}
 
-	// Prepare yarn environment
-	yarnPath, err := pm.yarnInstaller.PrepareYarnEnvironment(uniqueDirPath, input.Spec.Source.YarnLock, input.Spec.Source.TsConfig, procLogger)
-	if err != nil {
-		procLogger.WithField(logger.FieldError, err.Error()).
-			Warn("Failed to prepare yarn environment, but continuing anyway")
+	// Prepare the package manager environment. Only the registry configuration is
+	// required, the install reports the other failures.
+	if err := pm.yarnInstaller.PrepareEnvironment(uniqueDirPath, manager, input.Lockfile(), input.Spec.Source.TsConfig, procLogger); err != nil {
+		var registryErr *RegistryConfigError
+		if !errors.As(err, &registryErr) {
+			procLogger.WithField(logger.FieldError, err.Error()).
+				Warnf("Failed to prepare %s environment, but continuing anyway", manager.Name())
+		} else {
+			// Clean up the temporary directory
+			procLogger.Info("Removing temporary directory after registry configuration failure")
+			if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+				procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+					Warn("Failed to remove temporary directory after registry configuration failure")
+			}
+			return nil, fmt.Errorf("failed to prepare %s environment: %w", manager.Name(), err)
+		}
+	}
+
+	// Install in immutable mode whenever a lockfile is supplied, so that the reviewed
+	// versions are the ones installed. Drift is only computed for yarn.lock, npm and pnpm
+	// lockfiles are installed as is.
+	var warnings []ProcessWarning
+	immutable := input.Lockfile() != ""
+	if immutable && manager.Name() == types.PackageManagerYarn {
+		warning, err := pm.checkLockfile(uniqueDirPath, input.Spec.Source.Dependencies, workspaceMap, procLogger)
+		if err != nil {
+			procLogger.WithField(logger.FieldError, err.Error()).Error("Supplied yarn.lock rejected")
+			if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+				procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+					Warn("Failed to remove temporary directory after yarn.lock check failure")
+			}
+			return nil, err
+		}
+		if warning != nil {
+			warnings = append(warnings, *warning)
+		}
 	}
 
 	// Install dependencies using the queue
-	if err := pm.yarnInstaller.InstallDependencies(ctx, uniqueDirPath, specHash, yarnPath, procLogger); err != nil {
-		procLogger.WithField(logger.FieldError, err.Error()).
-			Warn("Yarn install failed, but continuing anyway")
+	err = pm.yarnInstaller.InstallDependencies(ctx, uniqueDirPath, specHash, manager, immutable, procLogger)
+
+	// The immutable install has the final say: a lockfile yarn would have to modify
+	// fails the function, whether or not strict lockfile or strict install is enabled
+	if driftErr := lockfileRejection(err); driftErr != nil {
+		procLogger.WithField(logger.FieldError, driftErr.Error()).Error("Supplied yarn.lock rejected")
+		if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+			procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+				Warn("Failed to remove temporary directory after yarn.lock check failure")
+		}
+		return nil, driftErr
 	}
 
-	// Construct the absolute path to the yarn executable
-	yarnExecPath := filepath.Join(uniqueDirPath, yarnPath)
-	procLogger.WithField("yarnExecPath", yarnExecPath).Info("Using yarn executable")
+	if err != nil {
+		// Missing packages make the function fail at import, abort with the yarn error instead
+		abort := pm.strictInstall
+		var installErr *YarnInstallError
+		if errors.As(err, &installErr) && len(installErr.UnresolvedPackages()) > 0 {
+			abort = true
+		}
+
+		if abort {
+			procLogger.WithField(logger.FieldError, err.Error()).Error("Failed to install dependencies")
+			if cleanupErr := os.RemoveAll(uniqueDirPath); cleanupErr != nil {
+				procLogger.WithField(logger.FieldError, cleanupErr.Error()).
+					Warn("Failed to remove temporary directory after dependency installation failure")
+			}
+			return nil, fmt.Errorf("failed to install dependencies: %w", err)
+		}
+
+		procLogger.WithField(logger.FieldError, err.Error()).
+			Warn("Dependency install failed, but continuing anyway")
+		warnings = append(warnings, ProcessWarning{Reason: InstallFailedReason, Message: err.Error()})
+	}
 
 	port, err := pm.getAvailablePort()
 	if err != nil {
@@ -199,26 +347,36 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 	// Ensure Node resolves workspace deps; set working directory to the server package (ensures tsx resolution)
 	cmd.Dir = "/app/packages/server"
 
+	// The input can override the server-wide Node.js log level
+	logLevel := pm.nodeLogLevel
+	if input.Spec.LogLevel != "" {
+		logLevel = input.Spec.LogLevel
+	}
+	procLogger = procLogger.WithField("node_log_level", logLevel)
+
 	// Ensure our custom ESM alias loader is enabled via NODE_OPTIONS
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("PORT=%d", port),
 		fmt.Sprintf("NODE_OPTIONS=%s", "--import tsx"),
 		fmt.Sprintf("XFUNCJS_CODE_FILE_PATH=%s", tempFilePath),
-		"XFUNCJS_LOG_LEVEL=debug", // Ensure we capture all logs from Node.js
-		"LOG_LEVEL=debug",         // Fallback for Pino logger
-		"BIND_ADDR=127.0.0.1",     // Bind server to loopback only
+		fmt.Sprintf("XFUNCJS_LOG_LEVEL=%s", logLevel),
+		fmt.Sprintf("LOG_LEVEL=%s", logLevel), // Fallback for Pino logger
+		"BIND_ADDR=127.0.0.1",                 // Bind server to loopback only
 	)
 
-	// Create custom logWriters for both stdout and stderr
+	// Create custom logWriters for both stdout and stderr, sharing the per-request capture
+	capture := newRequestLogCapture(defaultCapturedLines)
 	stdoutWriter := &logWriter{
 		logger:     procLogger,
 		prefix:     fmt.Sprintf("node[%s]: ", specHash[:8]),
 		streamType: "stdout",
+		capture:    capture,
 	}
 	stderrWriter := &logWriter{
 		logger:     procLogger,
 		prefix:     fmt.Sprintf("node[%s]: ", specHash[:8]),
 		streamType: "stderr",
+		capture:    capture,
 	}
 
 	// Redirect both stdout and stderr to our loggers
@@ -244,39 +402,28 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 	}
 
 	procLogger.Info("Started Node.js process")
+	startedAt := time.Now()
 
 	// Create the HTTP client
 	baseURL := fmt.Sprintf("http://127.0.0.1:%d", port)
 	client := NewNodeClient(baseURL, pm.requestTimeout, procLogger)
 
-	// Create the process info
-	process = &ProcessInfo{
-		Process:     cmd,
-		Client:      client,
-		LastUsed:    time.Now(),
-		Port:        port,
-		TempDirPath: uniqueDirPath,
-	}
-
-	// Store the process
-	pm.processes[specHash] = process
-
 	// Wait for the HTTP server to be ready
 	procLogger.Info("Waiting for Node.js HTTP server to be ready")
 	waitCtx, cancel := context.WithTimeout(ctx, pm.healthCheckWait)
 	defer cancel()
 
-	if err := client.WaitForReady(waitCtx, pm.healthCheckWait, pm.healthCheckInterval); err != nil {
+	status, err := client.WaitForReady(waitCtx, pm.healthCheckWait, pm.healthCheckInterval)
+	if err != nil {
 		procLogger.WithField(logger.FieldError, err.Error()).
 			Error("Failed to wait for Node.js HTTP server to be ready")
 
 		// Kill the process
-		if process.Process.Process != nil {
-			if err := process.Process.Process.Kill(); err != nil {
+		if cmd.Process != nil {
+			if err := cmd.Process.Kill(); err != nil {
 				procLogger.WithField("error", err.Error()).Warn("Failed to kill process")
 			}
 		}
-		delete(pm.processes, specHash)
 
 		// Clean up the temporary directory
 		if uniqueDirPath != "" {
@@ -290,10 +437,28 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 		return nil, fmt.Errorf("Node.js HTTP server failed to start: %w", err)
 	}
 
+	// Create the process info
+	process = &ProcessInfo{
+		Process:     cmd,
+		Client:      client,
+		LastUsed:    time.Now(),
+		Port:        port,
+		TempDirPath: uniqueDirPath,
+		SpecHash:    specHash,
+		Input:       input.DeepCopyObject().(*types.XFuncJSInput),
+		StartedAt:   startedAt,
+		Warnings:    warnings,
+		// Set before the process is stored, so that StaticRequirements never
+		// returns nil requirements for a module exporting some
+		Requirements: status.Requirements,
+		capture:      capture,
+	}
+	process.logLevel.Store(logLevel)
+	process.specLogLevel.Store(input.Spec.LogLevel)
+
 	// Verify the process is still running after initialization
 	if !pm.isProcessHealthy(process) {
 		procLogger.Error("Process is not healthy after initialization")
-		delete(pm.processes, specHash)
 
 		// Clean up the temporary directory
 		if uniqueDirPath != "" {
@@ -307,6 +472,36 @@ func (pm *ProcessManager) getOrCreateProcess(ctx context.Context, input *types.X
 		return nil, fmt.Errorf("process failed to initialize properly")
 	}
 
+	// The process is only stored once ready: the callers that do not wait on pm.lock
+	// never get a process still loading
+	pm.setProcess(specHash, process)
+
 	procLogger.Info("Process successfully initialized and ready")
 	return process, nil
 }
+
+// applyLogLevel sends the log level requested by the input to a reused process, since it is
+// not part of the process identity. It is only sent when the input changes it, so a level
+// set through the admin API is kept meanwhile. A failure is logged and retried on the next call.
+func (pm *ProcessManager) applyLogLevel(ctx context.Context, process *ProcessInfo, input *types.XFuncJSInput, procLogger logger.Logger) {
+	requested := input.Spec.LogLevel
+	if current, _ := process.specLogLevel.Load().(string); current == requested {
+		return
+	}
+
+	level := requested
+	if level == "" {
+		level = pm.nodeLogLevel
+	}
+	if err := process.Client.SetLogLevel(ctx, level); err != nil {
+		procLogger.WithField(logger.FieldError, err.Error()).
+			WithField("node_log_level", level).
+			Warn("Failed to apply the input log level to the running process")
+		return
+	}
+	process.logLevel.Store(level)
+	process.specLogLevel.Store(requested)
+
+	procLogger.WithField("node_log_level", level).
+		Info("Applied the input log level to the running process")
+}
@@ -1,17 +1,62 @@
 package node
 
 import (
+	"encoding/json"
 	"os/exec"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
 )
 
 // ProcessInfo holds information about a Node.js process
 type ProcessInfo struct {
-	Process     *exec.Cmd
-	Client      *NodeClient
-	LastUsed    time.Time
-	Lock        sync.Mutex
-	Port        int    // Store the assigned port for this process
-	TempDirPath string // Path to the temporary directory for this process
+	Process      *exec.Cmd
+	Client       *NodeClient
+	LastUsed     time.Time
+	Lock         sync.Mutex
+	Port         int                        // Store the assigned port for this process
+	TempDirPath  string                     // Path to the temporary directory for this process
+	SpecHash     string                     // Full hash of the input spec this process serves
+	Input        *types.XFuncJSInput        // Input the process was created from, used to restart it
+	StartedAt    time.Time                  // Time the Node.js process was started
+	Warnings     []ProcessWarning           // Problems found while creating the process, reported with every execution
+	Requirements map[string]json.RawMessage // Extra resource requirements statically exported by the user module
+
+	capture      *requestLogCapture // Output captured for the request currently running
+	logLevel     atomic.Value       // Current log level of the Node.js process (string)
+	specLogLevel atomic.Value       // Log level last requested by the input, empty for the default (string)
+	requestCount atomic.Int64       // Total number of requests sent to this process
+	inFlight     atomic.Int32       // Requests currently waiting for or running on this process
+}
+
+// ProcessWarning is a non-fatal problem found while creating a process
+type ProcessWarning struct {
+	Reason  string
+	Message string
+}
+
+// RequestCount returns the total number of requests handled by the process
+func (p *ProcessInfo) RequestCount() int64 {
+	return p.requestCount.Load()
+}
+
+// InFlight returns the number of requests currently waiting for or running on the process
+func (p *ProcessInfo) InFlight() int32 {
+	return p.inFlight.Load()
+}
+
+// LogLevel returns the current log level of the Node.js process
+func (p *ProcessInfo) LogLevel() string {
+	level, _ := p.logLevel.Load().(string)
+	return level
+}
+
+// PID returns the operating system process ID, or 0 if the process was not started
+func (p *ProcessInfo) PID() int {
+	if p.Process == nil || p.Process.Process == nil {
+		return 0
+	}
+	return p.Process.Process.Pid
 }
@@ -0,0 +1,121 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// RegistryConfig configures where the package manager fetches dependencies from.
+// The zero value keeps the registry settings of /app/.yarnrc.yml, or the npm and
+// pnpm defaults.
+type RegistryConfig struct {
+	// Server is the npm registry URL (npmRegistryServer)
+	Server string
+	// AuthTokenFile is a file holding the registry auth token (npmAuthToken).
+	// It is read every time a workspace is prepared, so the token can be rotated.
+	AuthTokenFile string
+	// CacheFolder is an offline mirror or pre-populated cache of package archives (cacheFolder)
+	CacheFolder string
+	// OfflineOnly forbids any network access during installs: packages missing
+	// from CacheFolder fail to resolve
+	OfflineOnly bool
+}
+
+// RegistryConfigError is returned when the registry settings cannot be applied to a
+// workspace. Installing without them would fetch from another registry, or without
+// credentials, so the process is not created.
+type RegistryConfigError struct {
+	Err error
+}
+
+// Error describes the failure
+func (e *RegistryConfigError) Error() string {
+	return fmt.Sprintf("failed to apply the registry configuration: %v", e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *RegistryConfigError) Unwrap() error {
+	return e.Err
+}
+
+// IsZero reports whether no registry setting is configured
+func (rc RegistryConfig) IsZero() bool {
+	return rc == RegistryConfig{}
+}
+
+// apply injects the registry settings into a parsed .yarnrc.yml
+func (rc RegistryConfig) apply(yarnConfig map[string]interface{}) error {
+	if rc.Server != "" {
+		yarnConfig["npmRegistryServer"] = rc.Server
+	}
+
+	if rc.AuthTokenFile != "" {
+		token, err := os.ReadFile(rc.AuthTokenFile)
+		if err != nil {
+			return &RegistryConfigError{Err: fmt.Errorf("failed to read npm auth token file: %w", err)}
+		}
+		yarnConfig["npmAuthToken"] = strings.TrimSpace(string(token))
+		yarnConfig["npmAlwaysAuth"] = true
+	}
+
+	if rc.CacheFolder != "" {
+		// Use the mirror directly instead of copying archives to the global cache
+		yarnConfig["cacheFolder"] = rc.CacheFolder
+		yarnConfig["enableGlobalCache"] = false
+		yarnConfig["enableMirror"] = false
+	}
+
+	if rc.OfflineOnly {
+		yarnConfig["enableNetwork"] = false
+		yarnConfig["enableOfflineMode"] = true
+	}
+
+	return nil
+}
+
+// defaultNpmRegistry is used to scope the auth token when no registry is configured
+const defaultNpmRegistry = "https://registry.npmjs.org/"
+
+// npmrc renders the registry settings as an .npmrc read by npm and pnpm.
+// cacheKey is the setting holding the package cache: "cache" for npm, "store-dir" for pnpm.
+func (rc RegistryConfig) npmrc(cacheKey string) (string, error) {
+	var lines []string
+
+	if rc.Server != "" {
+		lines = append(lines, "registry="+rc.Server)
+	}
+
+	if rc.AuthTokenFile != "" {
+		token, err := os.ReadFile(rc.AuthTokenFile)
+		if err != nil {
+			return "", &RegistryConfigError{Err: fmt.Errorf("failed to read npm auth token file: %w", err)}
+		}
+		// Tokens are scoped to the registry URL without scheme: //registry.example.com/:_authToken
+		server := rc.Server
+		if server == "" {
+			server = defaultNpmRegistry
+		}
+		scope := server
+		if _, host, ok := strings.Cut(server, "//"); ok {
+			scope = "//" + host
+		}
+		if !strings.HasSuffix(scope, "/") {
+			scope += "/"
+		}
+		lines = append(lines, scope+":_authToken="+strings.TrimSpace(string(token)))
+	}
+
+	if rc.CacheFolder != "" {
+		lines = append(lines, cacheKey+"="+rc.CacheFolder)
+	}
+
+	if rc.OfflineOnly {
+		lines = append(lines, "offline=true")
+	}
+
+	if len(lines) == 0 {
+		return "", nil
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
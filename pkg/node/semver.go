@@ -0,0 +1,432 @@
+package node
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// versionRange is a parsed npm version range: a union ("||") of comparator sets,
+// each set being an intersection of comparators
+type versionRange [][]comparator
+
+// comparator compares a version against a canonical semver ("v1.2.3")
+type comparator struct {
+	op      string // ">=", ">", "<=", "<" or "="
+	version string
+}
+
+// partialVersion is a version where trailing parts may be missing or wildcards ("1", "1.2", "1.x")
+type partialVersion struct {
+	parts      [3]int
+	specified  int // Number of parts that are set
+	prerelease string
+}
+
+// canonical returns the version with missing parts set to 0, as "vX.Y.Z[-pre]"
+func (p partialVersion) canonical() string {
+	v := fmt.Sprintf("v%d.%d.%d", p.parts[0], p.parts[1], p.parts[2])
+	if p.prerelease != "" {
+		v += "-" + p.prerelease
+	}
+	return v
+}
+
+// bump returns the smallest version above every version matching p at the given part
+// index, e.g. bumping "1.2" at index 1 gives "1.3.0"
+func (p partialVersion) bump(index int) string {
+	parts := p.parts
+	parts[index]++
+	for i := index + 1; i < 3; i++ {
+		parts[i] = 0
+	}
+	return fmt.Sprintf("v%d.%d.%d", parts[0], parts[1], parts[2])
+}
+
+// parseVersionRange parses an npm version range such as "^1.2.3", ">=1 <2",
+// "1.2.x", "1.0.0 - 2.0.0" or "^1 || ^2". "*", "x" and "" match any version.
+func parseVersionRange(s string) (versionRange, error) {
+	var r versionRange
+	for _, alternative := range strings.Split(s, "||") {
+		set, err := parseComparatorSet(strings.TrimSpace(alternative))
+		if err != nil {
+			return nil, err
+		}
+		r = append(r, set)
+	}
+	return r, nil
+}
+
+// parseComparatorSet parses space separated comparators
+func parseComparatorSet(s string) ([]comparator, error) {
+	fields := strings.Fields(s)
+
+	// Hyphen range: "1.2.3 - 2.3.4"
+	if len(fields) == 3 && fields[1] == "-" {
+		low, err := parsePartialVersion(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		high, err := parsePartialVersion(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		return append(expandComparator(">=", low), expandComparator("<=", high)...), nil
+	}
+
+	var set []comparator
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		// Allow a space between the operator and the version (">= 1.2.3")
+		if isOperator(field) && i+1 < len(fields) {
+			field += fields[i+1]
+			i++
+		}
+
+		op, version := splitOperator(field)
+		if version == "*" || strings.EqualFold(version, "x") {
+			continue
+		}
+		p, err := parsePartialVersion(version)
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "^":
+			set = append(set, expandCaret(p)...)
+		case "~":
+			set = append(set, expandTilde(p)...)
+		default:
+			set = append(set, expandComparator(op, p)...)
+		}
+	}
+	return set, nil
+}
+
+// isOperator reports whether s is a bare comparison operator
+func isOperator(s string) bool {
+	switch s {
+	case ">=", "<=", ">", "<", "=", "^", "~":
+		return true
+	}
+	return false
+}
+
+// splitOperator splits a comparator into its operator and version
+func splitOperator(s string) (string, string) {
+	for _, op := range []string{">=", "<=", "~>", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, op) {
+			if op == "~>" {
+				op = "~"
+			}
+			return op, strings.TrimSpace(s[len(op):])
+		}
+	}
+	return "", s
+}
+
+// parsePartialVersion parses "1", "1.2", "1.2.3", "v1.2.3", "1.x", "1.2.*" or "1.2.3-beta.1"
+func parsePartialVersion(s string) (partialVersion, error) {
+	var p partialVersion
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	// Build metadata is ignored by semver precedence
+	s, _, _ = strings.Cut(s, "+")
+	s, p.prerelease, _ = strings.Cut(s, "-")
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return p, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		if part == "*" || strings.EqualFold(part, "x") {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid version %q", s)
+		}
+		p.parts[i] = n
+		p.specified = i + 1
+	}
+	if p.specified < 3 {
+		// A prerelease only makes sense on a full version
+		p.prerelease = ""
+	}
+	return p, nil
+}
+
+// expandComparator turns a comparison with a partial version into canonical comparators
+func expandComparator(op string, p partialVersion) []comparator {
+	if p.specified == 0 {
+		// "*" in any position matches everything, except "<*" which matches nothing
+		if op == "<" {
+			return []comparator{{op: "<", version: "v0.0.0"}}
+		}
+		return nil
+	}
+
+	full := p.specified == 3
+	switch op {
+	case "", "=":
+		if full {
+			return []comparator{{op: "=", version: p.canonical()}}
+		}
+		return []comparator{{op: ">=", version: p.canonical()}, {op: "<", version: p.bump(p.specified - 1)}}
+	case ">":
+		if full {
+			return []comparator{{op: ">", version: p.canonical()}}
+		}
+		return []comparator{{op: ">=", version: p.bump(p.specified - 1)}}
+	case "<=":
+		if full {
+			return []comparator{{op: "<=", version: p.canonical()}}
+		}
+		return []comparator{{op: "<", version: p.bump(p.specified - 1)}}
+	default: // ">=" and "<"
+		return []comparator{{op: op, version: p.canonical()}}
+	}
+}
+
+// expandCaret expands "^1.2.3" to ">=1.2.3 <2.0.0", allowing changes that do not
+// modify the left-most non-zero part
+func expandCaret(p partialVersion) []comparator {
+	if p.specified == 0 {
+		return nil
+	}
+
+	upper := 0
+	for upper < p.specified-1 && p.parts[upper] == 0 {
+		upper++
+	}
+	return []comparator{{op: ">=", version: p.canonical()}, {op: "<", version: p.bump(upper)}}
+}
+
+// expandTilde expands "~1.2.3" to ">=1.2.3 <1.3.0", allowing patch-level changes
+func expandTilde(p partialVersion) []comparator {
+	if p.specified == 0 {
+		return nil
+	}
+
+	upper := 1
+	if p.specified == 1 {
+		upper = 0
+	}
+	return []comparator{{op: ">=", version: p.canonical()}, {op: "<", version: p.bump(upper)}}
+}
+
+// satisfiedBy reports whether the canonical version v matches the range
+func (r versionRange) satisfiedBy(v string) bool {
+	for _, set := range r {
+		matches := true
+		for _, c := range set {
+			if !c.satisfiedBy(v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// satisfiedBy reports whether the canonical version v passes the comparison
+func (c comparator) satisfiedBy(v string) bool {
+	cmp := semver.Compare(v, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	case "<":
+		return cmp < 0
+	default:
+		return cmp == 0
+	}
+}
+
+// lowerBound returns the lowest version the range can resolve to, as a canonical version
+func (r versionRange) lowerBound() string {
+	lowest := ""
+	for _, set := range r {
+		low := "v0.0.0"
+		for _, c := range set {
+			if (c.op == ">=" || c.op == ">" || c.op == "=") && semver.Compare(c.version, low) > 0 {
+				low = c.version
+			}
+		}
+		if lowest == "" || semver.Compare(low, lowest) < 0 {
+			lowest = low
+		}
+	}
+	if lowest == "" {
+		return "v0.0.0"
+	}
+	return lowest
+}
+
+// unbounded reports whether the range accepts arbitrarily high versions, such as
+// "*", ">=1.0.0" or "^1 || >=3"
+func (r versionRange) unbounded() bool {
+	for _, set := range r {
+		bounded := false
+		for _, c := range set {
+			if c.op == "<" || c.op == "<=" || c.op == "=" {
+				bounded = true
+				break
+			}
+		}
+		if !bounded {
+			return true
+		}
+	}
+	return false
+}
+
+// interval is the set of versions between two canonical versions. An empty high bound
+// is unbounded.
+type interval struct {
+	low, high             string
+	lowClosed, highClosed bool
+}
+
+// intervals returns the non-empty intervals of the comparator sets, sorted and merged
+func (r versionRange) intervals() []interval {
+	var list []interval
+	for _, set := range r {
+		i := interval{low: "v0.0.0", lowClosed: true}
+		for _, c := range set {
+			if c.op == ">=" || c.op == ">" || c.op == "=" {
+				i.raiseLow(c.version, c.op != ">")
+			}
+			if c.op == "<=" || c.op == "<" || c.op == "=" {
+				i.lowerHigh(c.version, c.op != "<")
+			}
+		}
+		if !i.empty() {
+			list = append(list, i)
+		}
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		if cmp := semver.Compare(list[a].low, list[b].low); cmp != 0 {
+			return cmp < 0
+		}
+		return list[a].lowClosed && !list[b].lowClosed
+	})
+	var merged []interval
+	for _, i := range list {
+		if n := len(merged); n > 0 && merged[n-1].touches(i) {
+			last := &merged[n-1]
+			if last.high != "" && (i.high == "" || semver.Compare(i.high, last.high) > 0) {
+				last.high, last.highClosed = i.high, i.highClosed
+			} else if last.high != "" && semver.Compare(i.high, last.high) == 0 {
+				last.highClosed = last.highClosed || i.highClosed
+			}
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return merged
+}
+
+// raiseLow restricts the interval to the versions above v
+func (i *interval) raiseLow(v string, closed bool) {
+	switch cmp := semver.Compare(v, i.low); {
+	case cmp > 0:
+		i.low, i.lowClosed = v, closed
+	case cmp == 0:
+		i.lowClosed = i.lowClosed && closed
+	}
+}
+
+// lowerHigh restricts the interval to the versions below v
+func (i *interval) lowerHigh(v string, closed bool) {
+	if i.high == "" {
+		i.high, i.highClosed = v, closed
+		return
+	}
+	switch cmp := semver.Compare(v, i.high); {
+	case cmp < 0:
+		i.high, i.highClosed = v, closed
+	case cmp == 0:
+		i.highClosed = i.highClosed && closed
+	}
+}
+
+// empty reports whether no version is in the interval
+func (i interval) empty() bool {
+	if i.high == "" {
+		return false
+	}
+	cmp := semver.Compare(i.low, i.high)
+	return cmp > 0 || (cmp == 0 && !(i.lowClosed && i.highClosed))
+}
+
+// touches reports whether next, which does not start below i, overlaps or extends i
+func (i interval) touches(next interval) bool {
+	if i.high == "" {
+		return true
+	}
+	cmp := semver.Compare(next.low, i.high)
+	return cmp < 0 || (cmp == 0 && (i.highClosed || next.lowClosed))
+}
+
+// contains reports whether every version of inner is in i
+func (i interval) contains(inner interval) bool {
+	if cmp := semver.Compare(inner.low, i.low); cmp < 0 || (cmp == 0 && inner.lowClosed && !i.lowClosed) {
+		return false
+	}
+	if i.high == "" {
+		return true
+	}
+	if inner.high == "" {
+		return false
+	}
+	cmp := semver.Compare(inner.high, i.high)
+	return cmp < 0 || (cmp == 0 && (i.highClosed || !inner.highClosed))
+}
+
+// intersects reports whether a version matches both ranges
+func (r versionRange) intersects(other versionRange) bool {
+	for _, a := range r.intervals() {
+		for _, b := range other.intervals() {
+			common := a
+			common.raiseLow(b.low, b.lowClosed)
+			if b.high != "" {
+				common.lowerHigh(b.high, b.highClosed)
+			}
+			if !common.empty() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containedIn reports whether every version matching the range matches other
+func (r versionRange) containedIn(other versionRange) bool {
+	outer := other.intervals()
+	for _, inner := range r.intervals() {
+		contained := false
+		for _, o := range outer {
+			if o.contains(inner) {
+				contained = true
+				break
+			}
+		}
+		if !contained {
+			return false
+		}
+	}
+	return true
+}
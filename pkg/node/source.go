@@ -0,0 +1,86 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/hash"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// computeSpecHash returns the identity of a function: the hash of its whole spec, including
+// every source file. Maps are marshalled with sorted keys, so the hash does not depend on
+// the order of the files. The log level is left out: it is applied to the running process.
+func computeSpecHash(input *types.XFuncJSInput) (string, error) {
+	spec := input.Spec
+	spec.LogLevel = ""
+	specBytes, err := json.Marshal(spec)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal input spec: %w", err)
+	}
+	return hash.GenerateInputHash(specBytes), nil
+}
+
+// writeSource writes the function source into the workspace and returns the path of the
+// entrypoint. The inline source is written as <hash>.ts; files are written at their path.
+func writeSource(workDir string, input *types.XFuncJSInput) (string, error) {
+	source := input.Spec.Source
+
+	for name, content := range source.Files {
+		target, err := sourceFilePath(workDir, name)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return "", fmt.Errorf("failed to create directory for source file %s: %w", name, err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			return "", fmt.Errorf("failed to write source file %s: %w", name, err)
+		}
+	}
+
+	if source.Inline == "" {
+		return sourceFilePath(workDir, source.Entrypoint)
+	}
+
+	entrypoint := filepath.Join(workDir, hash.GenerateTempFilename(source.Inline, ".ts"))
+	if err := os.WriteFile(entrypoint, []byte(source.Inline), 0644); err != nil {
+		return "", fmt.Errorf("failed to write code to temporary file %s: %w", entrypoint, err)
+	}
+	return entrypoint, nil
+}
+
+// sourceFilePath returns the absolute path of a source file in the workspace. It rejects
+// paths leaving the workspace, directly or through a symbolic link.
+func sourceFilePath(workDir, name string) (string, error) {
+	if err := types.ValidateSourcePath(name); err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(workDir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(workDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid file path %q: must stay within the function workspace", name)
+	}
+
+	// A reused workspace may hold links created by the package manager
+	current := workDir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to check source file path %s: %w", name, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid file path %q: %s is a symbolic link", name, part)
+		}
+	}
+
+	return target, nil
+}
@@ -0,0 +1,148 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func newFilesInput(files map[string]string, entrypoint string) *types.XFuncJSInput {
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Files = files
+	input.Spec.Source.Entrypoint = entrypoint
+	return input
+}
+
+func TestWriteSourceFiles(t *testing.T) {
+	workDir := t.TempDir()
+	input := newFilesInput(map[string]string{
+		"main.ts":          `import { helper } from "./lib/helper.ts"; export default helper`,
+		"lib/helper.ts":    `export const helper = () => ({})`,
+		"lib/data/x.json":  `{}`,
+		"lib/package.json": `{"type": "module"}`,
+	}, "main.ts")
+	if err := input.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	entrypoint, err := writeSource(workDir, input)
+	if err != nil {
+		t.Fatalf("writeSource() error = %v", err)
+	}
+	if want := filepath.Join(workDir, "main.ts"); entrypoint != want {
+		t.Errorf("entrypoint = %s, want %s", entrypoint, want)
+	}
+	for name, content := range input.Spec.Source.Files {
+		got, err := os.ReadFile(filepath.Join(workDir, name))
+		if err != nil || string(got) != content {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, content)
+		}
+	}
+}
+
+func TestWriteSourceInlineWithFiles(t *testing.T) {
+	workDir := t.TempDir()
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Inline = `export { helper as default } from "./helper.ts"`
+	input.Spec.Source.Files = map[string]string{"helper.ts": `export const helper = () => ({})`}
+	if err := input.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	entrypoint, err := writeSource(workDir, input)
+	if err != nil {
+		t.Fatalf("writeSource() error = %v", err)
+	}
+	if filepath.Dir(entrypoint) != workDir || filepath.Ext(entrypoint) != ".ts" {
+		t.Errorf("entrypoint = %s, want a .ts file in the workspace", entrypoint)
+	}
+	if _, err := os.Stat(filepath.Join(workDir, "helper.ts")); err != nil {
+		t.Errorf("helper.ts was not written: %v", err)
+	}
+}
+
+func TestSourcePathValidation(t *testing.T) {
+	invalid := []string{
+		"",
+		".",
+		"../escape.ts",
+		"lib/../../escape.ts",
+		"/etc/passwd",
+		"./main.ts",
+		"lib//main.ts",
+		`lib\main.ts`,
+		"package.json",
+		"node_modules/lodash/index.js",
+		".yarn/releases/yarn.cjs",
+		".npmrc",
+	}
+	for _, name := range invalid {
+		input := newFilesInput(map[string]string{"main.ts": "", name: ""}, "main.ts")
+		if err := input.Validate(); err == nil {
+			t.Errorf("Validate() accepted the file path %q", name)
+		}
+		if _, err := sourceFilePath(t.TempDir(), name); err == nil {
+			t.Errorf("sourceFilePath() accepted %q", name)
+		}
+	}
+
+	if err := newFilesInput(map[string]string{"main.ts": ""}, "other.ts").Validate(); err == nil {
+		t.Error("Validate() accepted an entrypoint missing from the files")
+	}
+	if err := newFilesInput(map[string]string{"main.ts": ""}, "").Validate(); err == nil {
+		t.Error("Validate() accepted files without entrypoint")
+	}
+}
+
+func TestSourceFilePathRejectsSymlinks(t *testing.T) {
+	workDir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(workDir, "lib")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := sourceFilePath(workDir, "lib/helper.ts"); err == nil {
+		t.Error("sourceFilePath() accepted a path through a symbolic link")
+	}
+}
+
+func TestComputeSpecHashCoversFiles(t *testing.T) {
+	a := newFilesInput(map[string]string{"main.ts": "export default 1", "lib.ts": "a"}, "main.ts")
+	b := newFilesInput(map[string]string{"lib.ts": "a", "main.ts": "export default 1"}, "main.ts")
+	c := newFilesInput(map[string]string{"main.ts": "export default 1", "lib.ts": "b"}, "main.ts")
+
+	hashA, err := computeSpecHash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, _ := computeSpecHash(b)
+	hashC, _ := computeSpecHash(c)
+
+	if hashA != hashB {
+		t.Error("computeSpecHash() depends on the order of the files")
+	}
+	if hashA == hashC {
+		t.Error("computeSpecHash() ignores the content of helper files")
+	}
+}
+
+func TestComputeSpecHashIgnoresLogLevel(t *testing.T) {
+	a := newFilesInput(map[string]string{"main.ts": "export default 1"}, "main.ts")
+	b := newFilesInput(map[string]string{"main.ts": "export default 1"}, "main.ts")
+	b.Spec.LogLevel = "debug"
+
+	hashA, err := computeSpecHash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, _ := computeSpecHash(b)
+
+	if hashA != hashB {
+		t.Error("computeSpecHash() depends on the log level")
+	}
+	if b.Spec.LogLevel != "debug" {
+		t.Error("computeSpecHash() modified the input")
+	}
+}
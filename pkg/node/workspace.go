@@ -2,19 +2,30 @@ package node
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
 )
 
+// DefaultWorkspaceManifest is the manifest of the workspace packages generated at image build time
+const DefaultWorkspaceManifest = "/app/workspaces.json"
+
+// workspaceRetryInterval is the minimum delay between two attempts after a failed load
+const workspaceRetryInterval = 10 * time.Second
+
 // WorkspacePackage represents a package in the yarn workspace
 type WorkspacePackage struct {
-	Name    string `json:"name"`
-	Version string `json:"version"`
-	Path    string
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Location string `json:"location"` // Relative to the workspace root
 }
 
 // WorkspaceInfo represents the output from yarn workspaces list --json
@@ -23,24 +34,167 @@ type WorkspaceInfo struct {
 	Name     string `json:"name"`
 }
 
-// Simple bootstrap cache - load once at startup, never changes
-var (
-	workspaceCache map[string]string
-	workspaceOnce  sync.Once
-)
+// workspaceManifest is the format of the workspace manifest
+type workspaceManifest struct {
+	Packages []WorkspacePackage `json:"packages"`
+}
+
+// WorkspaceRegistry provides the workspace packages bundled in the server image. They are
+// loaded from the manifest generated at image build time, or from `yarn workspaces list`
+// when there is none. A failed load is retried on a later call instead of being cached.
+type WorkspaceRegistry struct {
+	root     string
+	manifest string
+	logger   logger.Logger
+
+	mu          sync.Mutex
+	packages    []WorkspacePackage
+	source      string // "manifest" or "yarn"
+	loadedAt    time.Time
+	lastErr     error
+	lastAttempt time.Time
+}
+
+// WorkspaceRegistryStatus is a snapshot of the workspace registry
+type WorkspaceRegistryStatus struct {
+	Root     string             `json:"root"`
+	Manifest string             `json:"manifest"`
+	Source   string             `json:"source,omitempty"`
+	LoadedAt *time.Time         `json:"loadedAt,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Packages []WorkspacePackage `json:"packages"`
+	// Map is the name to location map used to resolve dependencies
+	Map map[string]string `json:"map"`
+}
+
+// NewWorkspaceRegistry creates a registry of the packages of the workspace at root.
+// manifest is the path of the manifest; when empty or missing, yarn is used.
+func NewWorkspaceRegistry(root, manifest string, logger logger.Logger) *WorkspaceRegistry {
+	return &WorkspaceRegistry{
+		root:     root,
+		manifest: manifest,
+		logger:   logger.WithField("component", "workspace-registry"),
+	}
+}
+
+// Packages returns the workspace map (package name to location), loading it on first use.
+// After a failed load, the load is retried once workspaceRetryInterval has elapsed.
+func (wr *WorkspaceRegistry) Packages() (map[string]string, error) {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	if wr.loadedAt.IsZero() && (wr.lastErr == nil || time.Since(wr.lastAttempt) >= workspaceRetryInterval) {
+		wr.loadLocked()
+	}
+	if wr.loadedAt.IsZero() {
+		return nil, wr.lastErr
+	}
+	return workspaceMap(wr.packages), nil
+}
+
+// Refresh loads the workspace packages again. The previous packages are kept if it fails.
+func (wr *WorkspaceRegistry) Refresh() error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	wr.loadLocked()
+	return wr.lastErr
+}
+
+// Status returns the loaded packages, where they were loaded from and the last load error
+func (wr *WorkspaceRegistry) Status() WorkspaceRegistryStatus {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	status := WorkspaceRegistryStatus{
+		Root:     wr.root,
+		Manifest: wr.manifest,
+		Source:   wr.source,
+		Packages: append([]WorkspacePackage{}, wr.packages...),
+		Map:      workspaceMap(wr.packages),
+	}
+	if !wr.loadedAt.IsZero() {
+		loadedAt := wr.loadedAt
+		status.LoadedAt = &loadedAt
+	}
+	if wr.lastErr != nil {
+		status.Error = wr.lastErr.Error()
+	}
+	return status
+}
+
+// loadLocked loads the packages from the manifest, or from yarn. wr.mu must be held.
+func (wr *WorkspaceRegistry) loadLocked() {
+	wr.lastAttempt = time.Now()
+
+	packages, source, err := wr.load()
+	if err != nil {
+		wr.lastErr = err
+		wr.logger.WithField(logger.FieldError, err.Error()).Error("Failed to load workspace packages")
+		return
+	}
+
+	sort.Slice(packages, func(i, j int) bool { return packages[i].Name < packages[j].Name })
+	wr.packages = packages
+	wr.source = source
+	wr.loadedAt = wr.lastAttempt
+	wr.lastErr = nil
+	wr.logger.WithField("source", source).
+		WithField("packages", len(packages)).
+		Info("Loaded workspace packages")
+}
+
+// load reads the manifest, falling back to yarn when it does not exist
+func (wr *WorkspaceRegistry) load() ([]WorkspacePackage, string, error) {
+	if wr.manifest != "" {
+		packages, err := loadWorkspaceManifest(wr.manifest)
+		if err == nil {
+			return packages, "manifest", nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, "", err
+		}
+		wr.logger.WithField("manifest", wr.manifest).Warn("Workspace manifest not found, listing workspace packages with yarn")
+	}
+
+	packages, err := loadWorkspacePackages(wr.root, wr.logger)
+	if err != nil {
+		return nil, "", err
+	}
+	return packages, "yarn", nil
+}
 
-// GetWorkspacePackages discovers workspace packages using yarn workspaces list --json
-// Uses simple bootstrap cache - loads once and never changes during runtime
-func GetWorkspacePackages(workspaceRoot string, logger logger.Logger) (map[string]string, error) {
-	var err error
-	workspaceOnce.Do(func() {
-		workspaceCache, err = loadWorkspacePackages(workspaceRoot, logger)
-	})
-	return workspaceCache, err
+// loadWorkspaceManifest reads a workspace manifest
+func loadWorkspaceManifest(file string) ([]WorkspacePackage, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace manifest: %w", err)
+	}
+
+	var manifest workspaceManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse workspace manifest %s: %w", file, err)
+	}
+	for _, pkg := range manifest.Packages {
+		if pkg.Name == "" || pkg.Location == "" {
+			return nil, fmt.Errorf("invalid workspace manifest %s: package without name or location", file)
+		}
+	}
+	return manifest.Packages, nil
+}
+
+// workspaceMap returns the name to location map of the packages
+func workspaceMap(packages []WorkspacePackage) map[string]string {
+	m := make(map[string]string, len(packages))
+	for _, pkg := range packages {
+		m[pkg.Name] = pkg.Location
+	}
+	return m
 }
 
-// loadWorkspacePackages loads workspace packages from yarn
-func loadWorkspacePackages(workspaceRoot string, logger logger.Logger) (map[string]string, error) {
+// loadWorkspacePackages loads workspace packages from yarn, reading their version
+// from their package.json
+func loadWorkspacePackages(workspaceRoot string, logger logger.Logger) ([]WorkspacePackage, error) {
 	logger.Info("Loading workspace packages")
 
 	// Run yarn workspaces list --json from the workspace root
@@ -49,14 +203,12 @@ func loadWorkspacePackages(workspaceRoot string, logger logger.Logger) (map[stri
 
 	output, err := cmd.Output()
 	if err != nil {
-		logger.WithField("error", err.Error()).
-			Error("Failed to run yarn workspaces list command")
 		return nil, fmt.Errorf("failed to run yarn workspaces list: %w", err)
 	}
 
 	// Parse the output - each line is a separate JSON object
 	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	workspaceMap := make(map[string]string)
+	var packages []WorkspacePackage
 
 	for _, line := range lines {
 		if strings.TrimSpace(line) == "" {
@@ -76,10 +228,17 @@ func loadWorkspacePackages(workspaceRoot string, logger logger.Logger) (map[stri
 			continue
 		}
 
-		workspaceMap[workspace.Name] = workspace.Location
+		pkg := WorkspacePackage{Name: workspace.Name, Location: workspace.Location}
+		var packageJSON struct {
+			Version string `json:"version"`
+		}
+		if content, err := os.ReadFile(filepath.Join(workspaceRoot, workspace.Location, "package.json")); err == nil && json.Unmarshal(content, &packageJSON) == nil {
+			pkg.Version = packageJSON.Version
+		}
+		packages = append(packages, pkg)
 	}
 
-	return workspaceMap, nil
+	return packages, nil
 }
 
 // ResolveWorkspacePackage resolves a link: dependency to a workspace package
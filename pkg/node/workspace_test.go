@@ -0,0 +1,83 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+const testWorkspaceManifest = `{
+  "packages": [
+    {"name": "@crossplane-js/sdk", "location": "packages/sdk", "version": "0.0.64"},
+    {"name": "@crossplane-js/libs", "location": "packages/libs", "version": "0.0.64"}
+  ]
+}`
+
+func TestWorkspaceRegistryManifest(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "workspaces.json")
+	if err := os.WriteFile(manifest, []byte(testWorkspaceManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewWorkspaceRegistry(t.TempDir(), manifest, logger.NewLogrusLogger("error", "text"))
+	got, err := registry.Packages()
+	if err != nil {
+		t.Fatalf("Packages() error = %v", err)
+	}
+	want := map[string]string{
+		"@crossplane-js/sdk":  "packages/sdk",
+		"@crossplane-js/libs": "packages/libs",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Packages() = %v, want %v", got, want)
+	}
+
+	status := registry.Status()
+	if status.Source != "manifest" || status.LoadedAt == nil || status.Error != "" {
+		t.Errorf("Status() = %+v, want loaded from the manifest", status)
+	}
+	if len(status.Packages) != 2 || status.Packages[0].Name != "@crossplane-js/libs" || status.Packages[0].Version != "0.0.64" {
+		t.Errorf("Status().Packages = %+v, want sorted packages with versions", status.Packages)
+	}
+}
+
+func TestWorkspaceRegistryRetriesFailedLoads(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "workspaces.json")
+	if err := os.WriteFile(manifest, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewWorkspaceRegistry(t.TempDir(), manifest, logger.NewLogrusLogger("error", "text"))
+	if _, err := registry.Packages(); err == nil {
+		t.Fatal("Packages() with an invalid manifest should fail")
+	}
+	if status := registry.Status(); status.Error == "" || status.LoadedAt != nil {
+		t.Errorf("Status() = %+v, want the load error", status)
+	}
+
+	// The error is not cached forever: fixing the manifest and refreshing loads the packages
+	if err := os.WriteFile(manifest, []byte(testWorkspaceManifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Refresh(); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	got, err := registry.Packages()
+	if err != nil || len(got) != 2 {
+		t.Errorf("Packages() = %v, %v, want the 2 packages of the manifest", got, err)
+	}
+
+	// A failed refresh keeps the packages loaded before
+	if err := os.WriteFile(manifest, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Refresh(); err == nil {
+		t.Fatal("Refresh() with an invalid manifest should fail")
+	}
+	if got, err := registry.Packages(); err != nil || len(got) != 2 {
+		t.Errorf("Packages() after a failed refresh = %v, %v, want the previous packages", got, err)
+	}
+}
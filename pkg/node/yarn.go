@@ -1,203 +1,100 @@
 package node
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
 	"sigs.k8s.io/yaml"
 )
 
-// YarnInstallJob represents a yarn install job
-type YarnInstallJob struct {
-	WorkDir    string
-	SpecHash   string
-	Logger     logger.Logger
-	Context    context.Context
-	ResultChan chan error
-	YarnPath   string
+// yarnPackageManager installs dependencies with the Yarn Berry release of the server image
+type yarnPackageManager struct {
+	appDir      string // Directory holding the .yarnrc.yml and .yarn of the server image
+	dirStrategy string
+	dirStats    yarnDirRecorder
 }
 
-// YarnQueue manages concurrent yarn install operations
-type YarnQueue struct {
-	semaphore chan struct{} // Limits concurrent operations
-	logger    logger.Logger
+// newYarnPackageManager creates a yarn package manager using the configuration of /app
+func newYarnPackageManager() *yarnPackageManager {
+	return &yarnPackageManager{appDir: "/app", dirStrategy: types.YarnDirReference}
 }
 
-// Global yarn queue instance
-var globalYarnQueue *YarnQueue
+// Name returns "yarn"
+func (*yarnPackageManager) Name() string { return types.PackageManagerYarn }
 
-// InitializeYarnQueue initializes the global yarn queue with the specified concurrency limit
-func InitializeYarnQueue(maxConcurrent int, logger logger.Logger) {
-	globalYarnQueue = &YarnQueue{
-		semaphore: make(chan struct{}, maxConcurrent),
-		logger:    logger.WithField("component", "yarn-queue"),
-	}
-	logger.WithField("max_concurrent", maxConcurrent).Info("Initialized yarn install queue")
-}
-
-// GetYarnQueue returns the global yarn queue instance
-func GetYarnQueue() *YarnQueue {
-	return globalYarnQueue
-}
-
-// SubmitYarnInstall submits a yarn install job to the queue
-func (yq *YarnQueue) SubmitYarnInstall(job *YarnInstallJob) {
-	go func() {
-		// Wait for a slot in the semaphore
-		select {
-		case yq.semaphore <- struct{}{}:
-			// Got a slot, proceed with yarn install
-			err := yq.executeYarnInstall(job)
-
-			// Release the slot
-			<-yq.semaphore
-
-			// Send result back
-			select {
-			case job.ResultChan <- err:
-			case <-job.Context.Done():
-				// Context was cancelled, don't block
-			}
-
-		case <-job.Context.Done():
-			// Context was cancelled while waiting
-			job.ResultChan <- job.Context.Err()
-		}
-	}()
-}
-
-// executeYarnInstall performs the actual yarn install operation
-func (yq *YarnQueue) executeYarnInstall(job *YarnInstallJob) error {
-	jobLogger := job.Logger.WithField("yarn_queue", "install")
-	jobLogger.Info("Starting queued yarn install")
-
-	// Create yarn command
-	yarnCmd := exec.CommandContext(job.Context, "yarn", "workspaces", "focus", "--production", "--json")
-	yarnCmd.Dir = job.WorkDir
-	yarnCmd.Env = os.Environ()
-
-	// Create custom logWriters for yarn output
-	yarnStdoutWriter := &logWriter{
-		logger:     jobLogger.WithField("yarn", "stdout"),
-		prefix:     fmt.Sprintf("yarn[%s]: ", job.SpecHash[:8]),
-		streamType: "stdout",
-	}
-	yarnStderrWriter := &logWriter{
-		logger:     jobLogger.WithField("yarn", "stderr"),
-		prefix:     fmt.Sprintf("yarn[%s]: ", job.SpecHash[:8]),
-		streamType: "stderr",
-	}
-
-	yarnCmd.Stdout = yarnStdoutWriter
-	yarnCmd.Stderr = yarnStderrWriter
-
-	// Run yarn install
-	if err := yarnCmd.Run(); err != nil {
-		jobLogger.WithField("error", err.Error()).Error("Yarn install failed")
-		return fmt.Errorf("yarn install failed: %w", err)
-	}
-
-	jobLogger.Info("Yarn install completed successfully")
-	return nil
-}
-
-// YarnInstaller handles yarn installation operations
-type YarnInstaller struct {
-	queue  *YarnQueue
-	logger logger.Logger
-}
-
-// NewYarnInstaller creates a new yarn installer
-func NewYarnInstaller(queue *YarnQueue, logger logger.Logger) *YarnInstaller {
-	return &YarnInstaller{
-		queue:  queue,
-		logger: logger.WithField("component", "yarn-installer"),
-	}
-}
-
-// PrepareYarnEnvironment prepares the yarn environment in the specified directory
-func (yi *YarnInstaller) PrepareYarnEnvironment(workDir string, yarnLock string, tsConfig string, logger logger.Logger) (string, error) {
-	// If yarn.lock is provided, write it to the temporary directory
-	if yarnLock != "" {
-		yarnLockPath := filepath.Join(workDir, "yarn.lock")
-
-		// Attempt to base64-decode and gunzip the provided yarn.lock
-		var toWrite []byte
-		if decoded, err := base64.StdEncoding.DecodeString(yarnLock); err == nil {
-			if zr, err := gzip.NewReader(bytes.NewReader(decoded)); err == nil {
-				defer zr.Close()
-				if unzipped, err := io.ReadAll(zr); err == nil {
-					toWrite = unzipped
-					logger.Info("Decoded base64 and gunzipped yarn.lock")
-				} else {
-					logger.WithField("error", err.Error()).
-						Warn("Failed to read gunzipped yarn.lock, falling back to raw content")
-				}
-			} else {
-				logger.WithField("error", err.Error()).
-					Warn("Failed to create gzip reader for yarn.lock, falling back to raw content")
-			}
-		}
+// Lockfile returns "yarn.lock"
+func (*yarnPackageManager) Lockfile() string { return "yarn.lock" }
 
-		if toWrite == nil {
-			toWrite = []byte(yarnLock)
-		}
+// WorkspaceProtocol returns "link:"
+func (*yarnPackageManager) WorkspaceProtocol() string { return "link:" }
 
-		if err := os.WriteFile(yarnLockPath, toWrite, 0644); err != nil {
-			logger.WithField("error", err.Error()).
-				Warn("Failed to write yarn.lock to temporary directory")
-		} else {
-			logger.Info("Created yarn.lock in temporary directory")
-		}
-	}
+// Prepare writes a .yarnrc.yml derived from /app/.yarnrc.yml, using the shared yarn cache,
+// and provides the yarn release and plugins of /app/.yarn with the configured strategy
+func (ym *yarnPackageManager) Prepare(workDir string, registry RegistryConfig, logger logger.Logger) error {
+	ym.prepareYarnDir(workDir, logger)
 
-	// If tsconfig.json is provided, write it to the temporary directory
-	if tsConfig != "" {
-		tsConfigPath := filepath.Join(workDir, "tsconfig.json")
-		if err := os.WriteFile(tsConfigPath, []byte(tsConfig), 0644); err != nil {
-			logger.WithField("error", err.Error()).
-				Warn("Failed to write tsconfig.json to temporary directory")
-		} else {
-			logger.Info("Created tsconfig.json in temporary directory")
-		}
-	}
-
-	// Read the original .yarnrc.yml, extract yarnPath, and remove the plugins section
-	yarnrcSrc := "/app/.yarnrc.yml"
+	// Read the original .yarnrc.yml, log yarnPath, and remove the plugins section
+	yarnrcSrc := filepath.Join(ym.appDir, ".yarnrc.yml")
 	yarnrcContent, err := os.ReadFile(yarnrcSrc)
 	if err != nil {
 		logger.WithField("error", err.Error()).Warn("Failed to read .yarnrc.yml")
-		return "", nil // Return empty yarnPath but don't fail
+		if registry.IsZero() {
+			return nil // Don't fail
+		}
+		// The registry settings must still be written
+		yarnrcContent = nil
 	}
 
-	var yarnPath string
-
 	// Parse the YAML content
 	var yarnConfig map[string]interface{}
 	if err := yaml.Unmarshal(yarnrcContent, &yarnConfig); err != nil {
 		logger.WithField("error", err.Error()).
 			Warn("Failed to parse .yarnrc.yml")
-		return "", nil // Return empty yarnPath but don't fail
+		if !registry.IsZero() {
+			return &RegistryConfigError{Err: fmt.Errorf("failed to parse .yarnrc.yml: %w", err)}
+		}
+		return nil // Don't fail
+	}
+	if yarnConfig == nil {
+		yarnConfig = make(map[string]interface{})
 	}
 
-	// Extract yarnPath from the config
+	// The yarn release is copied with .yarn, so yarnPath stays valid in the workspace
 	if path, ok := yarnConfig["yarnPath"].(string); ok {
-		yarnPath = path
-		logger.WithField("yarnPath", yarnPath).Info("Found yarnPath in .yarnrc.yml")
+		logger.WithField("yarnPath", path).Info("Found yarnPath in .yarnrc.yml")
 	}
 
 	// Remove the plugins section
 	delete(yarnConfig, "plugins")
 
+	// Installs share one cache instead of filling a cache in each workspace: a cacheFolder of
+	// the image configuration is resolved against /app, otherwise the global cache is used
+	if folder, ok := yarnConfig["cacheFolder"].(string); ok {
+		if !filepath.IsAbs(folder) {
+			yarnConfig["cacheFolder"] = filepath.Join(ym.appDir, folder)
+		}
+	} else {
+		yarnConfig["enableGlobalCache"] = true
+	}
+
+	// Point yarn at the configured registry or offline mirror
+	if err := registry.apply(yarnConfig); err != nil {
+		return err
+	}
+	if !registry.IsZero() {
+		logger.WithField("npm_registry", registry.Server).
+			WithField("cache_folder", registry.CacheFolder).
+			WithField("offline_only", registry.OfflineOnly).
+			Info("Applied registry configuration to .yarnrc.yml")
+	}
+
 	// Marshal back to YAML
 	modifiedYarnrc, err := yaml.Marshal(yarnConfig)
 	if err != nil {
@@ -209,55 +106,157 @@ func (yi *YarnInstaller) PrepareYarnEnvironment(workDir string, yarnLock string,
 		if err := os.WriteFile(yarnrcDst, modifiedYarnrc, 0644); err != nil {
 			logger.WithField("error", err.Error()).
 				Warn("Failed to write modified .yarnrc.yml to temporary directory")
+			if !registry.IsZero() {
+				return &RegistryConfigError{Err: fmt.Errorf("failed to write .yarnrc.yml: %w", err)}
+			}
 		} else {
 			logger.Info("Created modified .yarnrc.yml in temporary directory (plugins removed)")
 		}
 	}
 
-	// Create .yarn directory in the temp directory and copy contents from /app/.yarn
-	// Exclude install-state.gz which is environment-specific
-	yarnSrcDir := "/app/.yarn"
-	yarnDstDir := filepath.Join(workDir, ".yarn")
-	if err := os.MkdirAll(yarnDstDir, 0755); err != nil {
-		logger.WithField("error", err.Error()).
-			Warn("Failed to create .yarn directory in temporary directory")
-	} else if err := copyDir(yarnSrcDir, yarnDstDir, "install-state.gz"); err != nil {
+	return nil
+}
+
+// prepareYarnDir creates the workspace .yarn directory from /app/.yarn. The yarn release
+// must be available before yarn runs, so failures are logged and the install reports them.
+func (ym *yarnPackageManager) prepareYarnDir(workDir string, logger logger.Logger) {
+	start := time.Now()
+	result, err := populateYarnDir(filepath.Join(ym.appDir, ".yarn"), filepath.Join(workDir, ".yarn"), ym.dirStrategy)
+	duration := time.Since(start)
+	if err != nil {
 		logger.WithField("error", err.Error()).
-			Warn("Failed to copy .yarn directory to temporary directory")
-	} else {
-		logger.Info("Copied .yarn directory to temporary directory")
+			WithField("strategy", ym.dirStrategy).
+			Warn("Failed to prepare .yarn directory in temporary directory")
+		return
 	}
 
-	return yarnPath, nil
+	ym.dirStats.record(result, duration)
+	logger.WithField("strategy", ym.dirStrategy).
+		WithField("symlinked", result.symlinked).
+		WithField("hardlinked", result.hardlinked).
+		WithField("copied", result.copied).
+		WithField("copied_bytes", result.copiedBytes).
+		WithField("duration_ms", duration.Milliseconds()).
+		Info("Prepared .yarn directory in temporary directory")
 }
 
-// InstallDependencies installs dependencies using the yarn queue
-func (yi *YarnInstaller) InstallDependencies(ctx context.Context, workDir, specHash, yarnPath string, logger logger.Logger) error {
-	// Create a job for the queue
-	resultChan := make(chan error, 1)
-	job := &YarnInstallJob{
-		WorkDir:    workDir,
-		SpecHash:   specHash,
-		Logger:     logger,
-		Context:    ctx,
-		ResultChan: resultChan,
-		YarnPath:   yarnPath,
+// Install runs `yarn workspaces focus --production`, reporting the errors parsed from
+// the yarn JSON output as a *YarnInstallError
+func (*yarnPackageManager) Install(job *YarnInstallJob) error {
+	var env []string
+	if job.Immutable {
+		env = append(env, "YARN_ENABLE_IMMUTABLE_INSTALLS=true")
 	}
 
-	// Submit to queue
-	yi.queue.SubmitYarnInstall(job)
+	// Collect the errors reported by yarn alongside the logs
+	report := newYarnReport()
+	return report.err(runInstallCommand(job, "yarn", []string{"workspaces", "focus", "--production", "--json"}, env, report))
+}
 
-	// Wait for result
-	select {
-	case err := <-resultChan:
-		return err
-	case <-ctx.Done():
-		return ctx.Err()
+// YarnInstaller handles dependency installation operations with the package manager
+// selected by each function
+type YarnInstaller struct {
+	queue    *YarnQueue
+	yarn     *yarnPackageManager
+	managers map[string]PackageManager
+	registry RegistryConfig
+	logger   logger.Logger
+}
+
+// NewYarnInstaller creates a new installer supporting yarn, npm and pnpm
+func NewYarnInstaller(queue *YarnQueue, logger logger.Logger) *YarnInstaller {
+	yarn := newYarnPackageManager()
+	managers := make(map[string]PackageManager)
+	for _, manager := range []PackageManager{yarn, npmPackageManager{}, pnpmPackageManager{}} {
+		managers[manager.Name()] = manager
 	}
+
+	return &YarnInstaller{
+		queue:    queue,
+		yarn:     yarn,
+		managers: managers,
+		logger:   logger.WithField("component", "yarn-installer"),
+	}
+}
+
+// SetRegistryConfig sets the registry settings injected into each workspace .yarnrc.yml or .npmrc
+func (yi *YarnInstaller) SetRegistryConfig(registry RegistryConfig) {
+	yi.registry = registry
 }
 
-// CreatePackageJSON creates a package.json file with the specified dependencies
-func (yi *YarnInstaller) CreatePackageJSON(workDir string, dependencies map[string]interface{}, logger logger.Logger) error {
+// SetYarnDirStrategy sets how the shared parts of /app/.yarn are provided to each workspace
+func (yi *YarnInstaller) SetYarnDirStrategy(strategy string) {
+	yi.yarn.dirStrategy = strategy
+}
+
+// YarnDirStats returns the measures of the workspace .yarn directories prepared so far
+func (yi *YarnInstaller) YarnDirStats() YarnDirStats {
+	return yi.yarn.dirStats.snapshot(yi.yarn.dirStrategy)
+}
+
+// PackageManager returns the package manager with the given name, yarn if name is empty
+func (yi *YarnInstaller) PackageManager(name string) (PackageManager, error) {
+	if name == "" {
+		name = types.PackageManagerYarn
+	}
+	manager, ok := yi.managers[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported package manager %q", name)
+	}
+	return manager, nil
+}
+
+// PrepareEnvironment writes the lockfile, tsconfig.json and package manager configuration
+// in the specified directory. The lockfile is already decoded from source.encoding.
+func (yi *YarnInstaller) PrepareEnvironment(workDir string, manager PackageManager, lockfile string, tsConfig string, logger logger.Logger) error {
+	// If a lockfile is provided, write it to the temporary directory
+	if lockfile != "" {
+		lockfilePath := filepath.Join(workDir, manager.Lockfile())
+		if err := os.WriteFile(lockfilePath, []byte(lockfile), 0644); err != nil {
+			logger.WithField("error", err.Error()).
+				Warnf("Failed to write %s to temporary directory", manager.Lockfile())
+		} else {
+			logger.Infof("Created %s in temporary directory", manager.Lockfile())
+		}
+	}
+
+	// If tsconfig.json is provided, write it to the temporary directory
+	if tsConfig != "" {
+		tsConfigPath := filepath.Join(workDir, "tsconfig.json")
+		if err := os.WriteFile(tsConfigPath, []byte(tsConfig), 0644); err != nil {
+			logger.WithField("error", err.Error()).
+				Warn("Failed to write tsconfig.json to temporary directory")
+		} else {
+			logger.Info("Created tsconfig.json in temporary directory")
+		}
+	}
+
+	return manager.Prepare(workDir, yi.registry, logger)
+}
+
+// InstallDependencies installs dependencies using the install queue.
+// With immutable set, the install fails if it would modify the supplied lockfile.
+func (yi *YarnInstaller) InstallDependencies(ctx context.Context, workDir, specHash string, manager PackageManager, immutable bool, logger logger.Logger) error {
+	return yi.queue.Install(ctx, &YarnInstallJob{
+		WorkDir:   workDir,
+		SpecHash:  specHash,
+		Logger:    logger,
+		Manager:   manager,
+		Immutable: immutable,
+	})
+}
+
+// CreatePackageJSON creates a package.json file with the specified dependencies.
+// Workspace packages are referenced with the protocol of the package manager.
+func (yi *YarnInstaller) CreatePackageJSON(workDir string, dependencies map[string]interface{}, manager PackageManager, logger logger.Logger) error {
+	for name, value := range dependencies {
+		if specifier, ok := value.(string); ok {
+			if target, isLink := strings.CutPrefix(specifier, "link:"); isLink {
+				dependencies[name] = manager.WorkspaceProtocol() + target
+			}
+		}
+	}
+
 	// Create simple package.json with dependencies
 	packageJSON := map[string]interface{}{
 		"name":         "xfuncjs-function",
@@ -0,0 +1,154 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// workspaceStateEntries are the .yarn entries holding the state of a single install.
+// They are never shared: yarn creates them in each workspace.
+var workspaceStateEntries = []string{"install-state.gz", "build-state.yml", "unplugged", "__virtual__"}
+
+// sharedCacheEntry is the yarn cache of the image. It is not provided to the workspaces:
+// the generated .yarnrc.yml points installs at a shared cache instead.
+const sharedCacheEntry = "cache"
+
+// YarnDirStats measures the preparation of the workspace .yarn directories
+type YarnDirStats struct {
+	Strategy       string  `json:"strategy"`
+	Workspaces     int64   `json:"workspaces"`
+	Symlinked      int64   `json:"symlinked"`
+	Hardlinked     int64   `json:"hardlinked"`
+	Copied         int64   `json:"copied"`
+	CopiedBytes    int64   `json:"copiedBytes"`
+	TotalSeconds   float64 `json:"totalSeconds"`
+	AverageSeconds float64 `json:"averageSeconds"`
+}
+
+// yarnDirResult counts the entries provided to one workspace
+type yarnDirResult struct {
+	symlinked   int64
+	hardlinked  int64
+	copied      int64
+	copiedBytes int64
+}
+
+// yarnDirRecorder accumulates the results of every workspace preparation
+type yarnDirRecorder struct {
+	mu    sync.Mutex
+	stats YarnDirStats
+	total time.Duration
+}
+
+// record adds the result of one preparation
+func (r *yarnDirRecorder) record(result yarnDirResult, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stats.Workspaces++
+	r.stats.Symlinked += result.symlinked
+	r.stats.Hardlinked += result.hardlinked
+	r.stats.Copied += result.copied
+	r.stats.CopiedBytes += result.copiedBytes
+	r.total += duration
+}
+
+// snapshot returns the accumulated stats
+func (r *yarnDirRecorder) snapshot(strategy string) YarnDirStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Strategy = strategy
+	stats.TotalSeconds = r.total.Seconds()
+	if stats.Workspaces > 0 {
+		stats.AverageSeconds = stats.TotalSeconds / float64(stats.Workspaces)
+	}
+	return stats
+}
+
+// populateYarnDir provides the shared entries of src to the workspace .yarn directory dst
+// with the given strategy. Per-workspace state and the cache are skipped.
+func populateYarnDir(src, dst string, strategy string) (yarnDirResult, error) {
+	var result yarnDirResult
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return result, fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == sharedCacheEntry || slices.Contains(workspaceStateEntries, entry.Name()) {
+			continue
+		}
+		srcPath := filepath.Join(src, entry.Name())
+		dstPath := filepath.Join(dst, entry.Name())
+
+		// A workspace may be prepared again: never write through a previous link
+		if err := os.RemoveAll(dstPath); err != nil {
+			return result, fmt.Errorf("failed to remove %s: %w", dstPath, err)
+		}
+
+		if strategy == types.YarnDirReference {
+			if err := os.Symlink(srcPath, dstPath); err != nil {
+				return result, fmt.Errorf("failed to link %s: %w", srcPath, err)
+			}
+			result.symlinked++
+			continue
+		}
+
+		if err := linkTree(srcPath, dstPath, strategy != types.YarnDirCopy, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// linkTree recreates src at dst, hardlinking files when hardlink is set. Files that
+// cannot be linked (other filesystem, protected hardlinks) are copied.
+func linkTree(src, dst string, hardlink bool, result *yarnDirResult) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("failed to get %s info: %w", src, err)
+	}
+
+	if info.IsDir() {
+		if err := os.MkdirAll(dst, info.Mode().Perm()|0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dst, err)
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		for _, entry := range entries {
+			if err := linkTree(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), hardlink, result); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if hardlink {
+		if err := os.Link(src, dst); err == nil {
+			result.hardlinked++
+			return nil
+		}
+	}
+
+	if err := copyFile(src, dst); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	result.copied++
+	result.copiedBytes += info.Size()
+	return nil
+}
@@ -0,0 +1,78 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// newTestYarnDir creates a .yarn directory with a release, a cache and per-workspace state
+func newTestYarnDir(t *testing.T) string {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), ".yarn")
+	files := map[string]string{
+		"releases/yarn-4.12.0.cjs":          "// yarn",
+		"plugins/@yarnpkg/plugin-fetch.cjs": "// plugin",
+		"cache/lodash-npm-4.17.21.zip":      "zip",
+		"install-state.gz":                  "state",
+		"unplugged/esbuild/package.json":    "{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src
+}
+
+func TestPopulateYarnDir(t *testing.T) {
+	tests := []struct {
+		strategy string
+		want     yarnDirResult
+	}{
+		{strategy: types.YarnDirReference, want: yarnDirResult{symlinked: 2}},
+		{strategy: types.YarnDirHardlink, want: yarnDirResult{hardlinked: 2}},
+		{strategy: types.YarnDirCopy, want: yarnDirResult{copied: 2, copiedBytes: int64(len("// yarn") + len("// plugin"))}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			src := newTestYarnDir(t)
+			dst := filepath.Join(t.TempDir(), ".yarn")
+
+			got, err := populateYarnDir(src, dst, tt.strategy)
+			if err != nil {
+				t.Fatalf("populateYarnDir() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("populateYarnDir() = %+v, want %+v", got, tt.want)
+			}
+
+			// The release is reachable whatever the strategy
+			if content, err := os.ReadFile(filepath.Join(dst, "releases/yarn-4.12.0.cjs")); err != nil || string(content) != "// yarn" {
+				t.Errorf("release = %q, %v", content, err)
+			}
+
+			// Per-workspace state is never shared, the cache is used through .yarnrc.yml
+			for _, name := range []string{"install-state.gz", "unplugged", "cache"} {
+				if _, err := os.Lstat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+					t.Errorf("%s was provided to the workspace", name)
+				}
+			}
+
+			// Preparing the workspace again must not write through the previous links
+			if _, err := populateYarnDir(src, dst, tt.strategy); err != nil {
+				t.Fatalf("second populateYarnDir() error = %v", err)
+			}
+			if content, err := os.ReadFile(filepath.Join(src, "releases/yarn-4.12.0.cjs")); err != nil || string(content) != "// yarn" {
+				t.Errorf("shared release = %q, %v", content, err)
+			}
+		})
+	}
+}
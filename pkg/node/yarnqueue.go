@@ -0,0 +1,288 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+// YarnInstallJob represents a dependency install job, run by the package manager of the function
+type YarnInstallJob struct {
+	WorkDir   string
+	SpecHash  string
+	Logger    logger.Logger
+	Context   context.Context // Set by the queue, bound by the install timeout
+	Manager   PackageManager
+	Immutable bool // Fail instead of modifying the supplied lockfile
+}
+
+// YarnQueue manages concurrent dependency install operations, whatever the package manager.
+// Jobs targeting the same workspace are merged: later callers wait for the install already
+// queued or running instead of starting another one.
+type YarnQueue struct {
+	semaphore      chan struct{} // Limits concurrent operations
+	installTimeout time.Duration
+	logger         logger.Logger
+
+	mu       sync.Mutex
+	installs map[string]*queuedInstall // Installs queued or running, by workspace
+	started  int64
+	finished int64
+	failed   int64
+	merged   int64
+	waitSum  time.Duration
+	waitMax  time.Duration
+}
+
+// queuedInstall is an install queued or running, shared by every caller waiting for it
+type queuedInstall struct {
+	job       *YarnInstallJob
+	queuedAt  time.Time
+	startedAt time.Time // Zero while waiting for a slot
+	waiters   int
+	cancel    context.CancelFunc
+	done      chan struct{} // Closed when err is set
+	err       error
+}
+
+// InstallQueueStats is a point-in-time snapshot of the install queue
+type InstallQueueStats struct {
+	MaxConcurrent         int             `json:"maxConcurrent"`
+	InstallTimeoutSeconds float64         `json:"installTimeoutSeconds"`
+	Depth                 int             `json:"depth"` // Installs waiting for a slot
+	Active                []InstallStatus `json:"active"`
+	Queued                []InstallStatus `json:"queued"`
+	Finished              int64           `json:"finished"`
+	Failed                int64           `json:"failed"`
+	Merged                int64           `json:"merged"` // Callers that joined an install already queued or running
+	AverageWaitSeconds    float64         `json:"averageWaitSeconds"`
+	MaxWaitSeconds        float64         `json:"maxWaitSeconds"`
+	YarnDir               *YarnDirStats   `json:"yarnDir,omitempty"` // Set by the process manager
+}
+
+// InstallStatus describes an install queued or running
+type InstallStatus struct {
+	SpecHash       string    `json:"specHash"`
+	WorkDir        string    `json:"workDir"`
+	PackageManager string    `json:"packageManager"`
+	Immutable      bool      `json:"immutable"`
+	Waiters        int       `json:"waiters"`
+	QueuedAt       time.Time `json:"queuedAt"`
+	WaitSeconds    float64   `json:"waitSeconds"`
+	RunningSeconds float64   `json:"runningSeconds,omitempty"`
+}
+
+// NewYarnQueue creates an install queue running at most maxConcurrent installs at a time.
+// Each install is cancelled after installTimeout; 0 means no timeout.
+func NewYarnQueue(maxConcurrent int, installTimeout time.Duration, logger logger.Logger) *YarnQueue {
+	logger.WithField("max_concurrent", maxConcurrent).
+		WithField("install_timeout", installTimeout.String()).
+		Info("Initialized dependency install queue")
+
+	return &YarnQueue{
+		semaphore:      make(chan struct{}, maxConcurrent),
+		installTimeout: installTimeout,
+		logger:         logger.WithField("component", "yarn-queue"),
+		installs:       make(map[string]*queuedInstall),
+	}
+}
+
+// Install runs job, or waits for the install of the same workspace already queued or running.
+// The install is bound by the install timeout, not by ctx: when ctx is done the caller stops
+// waiting, and an install still waiting for a slot is dropped once no caller waits for it.
+func (yq *YarnQueue) Install(ctx context.Context, job *YarnInstallJob) error {
+	var install *queuedInstall
+	for install == nil {
+		yq.mu.Lock()
+		existing, exists := yq.installs[job.WorkDir]
+		switch {
+		case !exists:
+			install = yq.enqueue(job)
+		case existing.job.Manager.Name() == job.Manager.Name() && existing.job.Immutable == job.Immutable:
+			existing.waiters++
+			yq.merged++
+			install = existing
+			job.Logger.Info("Dependency install already in progress for this workspace, waiting for it")
+		}
+		yq.mu.Unlock()
+
+		if install == nil {
+			// Another kind of install runs in the workspace, wait for it before running ours
+			select {
+			case <-existing.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	select {
+	case <-install.done:
+		return install.err
+	case <-ctx.Done():
+		yq.leave(install)
+		return ctx.Err()
+	}
+}
+
+// enqueue registers a new install and starts waiting for a slot. yq.mu must be held.
+func (yq *YarnQueue) enqueue(job *YarnInstallJob) *queuedInstall {
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Context = ctx
+
+	install := &queuedInstall{
+		job:      job,
+		queuedAt: time.Now(),
+		waiters:  1,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	yq.installs[job.WorkDir] = install
+
+	go yq.run(install)
+	return install
+}
+
+// leave unregisters a caller that stopped waiting. An install nobody waits for is
+// cancelled if it has not started yet; a running install completes so a later caller
+// for the same workspace can join it.
+func (yq *YarnQueue) leave(install *queuedInstall) {
+	yq.mu.Lock()
+	defer yq.mu.Unlock()
+
+	install.waiters--
+	if install.waiters == 0 && install.startedAt.IsZero() {
+		// Later callers must not join the cancelled install
+		if yq.installs[install.job.WorkDir] == install {
+			delete(yq.installs, install.job.WorkDir)
+		}
+		install.cancel()
+	}
+}
+
+// run waits for a slot and runs the install
+func (yq *YarnQueue) run(install *queuedInstall) {
+	job := install.job
+	defer install.cancel()
+
+	// Wait for a slot in the semaphore
+	select {
+	case yq.semaphore <- struct{}{}:
+	case <-job.Context.Done():
+		// Every caller stopped waiting before the install started
+		yq.finish(install, job.Context.Err())
+		return
+	}
+
+	yq.mu.Lock()
+	install.startedAt = time.Now()
+	wait := install.startedAt.Sub(install.queuedAt)
+	yq.started++
+	yq.waitSum += wait
+	if wait > yq.waitMax {
+		yq.waitMax = wait
+	}
+	yq.mu.Unlock()
+
+	// The timeout only covers the install itself, not the wait for a slot
+	if yq.installTimeout > 0 {
+		ctx, cancel := context.WithTimeout(job.Context, yq.installTimeout)
+		defer cancel()
+		job.Context = ctx
+	}
+
+	err := yq.executeInstall(job)
+	if err != nil && errors.Is(job.Context.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("dependency install timed out after %s: %w", yq.installTimeout, err)
+	}
+
+	// Release the slot
+	<-yq.semaphore
+
+	yq.finish(install, err)
+}
+
+// finish records the result of an install and wakes up its callers
+func (yq *YarnQueue) finish(install *queuedInstall, err error) {
+	yq.mu.Lock()
+	if yq.installs[install.job.WorkDir] == install {
+		delete(yq.installs, install.job.WorkDir)
+	}
+	if !install.startedAt.IsZero() {
+		yq.finished++
+		if err != nil {
+			yq.failed++
+		}
+	}
+	yq.mu.Unlock()
+
+	install.err = err
+	close(install.done)
+}
+
+// executeInstall runs the install of a job with its package manager
+func (yq *YarnQueue) executeInstall(job *YarnInstallJob) error {
+	jobLogger := job.Logger.WithField("package_manager", job.Manager.Name())
+	jobLogger.Info("Starting queued dependency install")
+
+	if err := job.Manager.Install(job); err != nil {
+		jobLogger.WithField("error", err.Error()).Error("Dependency install failed")
+		return err
+	}
+
+	jobLogger.Info("Dependency install completed successfully")
+	return nil
+}
+
+// Stats returns a snapshot of the queue. Installs are sorted by queue time.
+func (yq *YarnQueue) Stats() InstallQueueStats {
+	now := time.Now()
+
+	yq.mu.Lock()
+	defer yq.mu.Unlock()
+
+	stats := InstallQueueStats{
+		MaxConcurrent:         cap(yq.semaphore),
+		InstallTimeoutSeconds: yq.installTimeout.Seconds(),
+		Active:                []InstallStatus{},
+		Queued:                []InstallStatus{},
+		Finished:              yq.finished,
+		Failed:                yq.failed,
+		Merged:                yq.merged,
+		MaxWaitSeconds:        yq.waitMax.Seconds(),
+	}
+	if yq.started > 0 {
+		stats.AverageWaitSeconds = yq.waitSum.Seconds() / float64(yq.started)
+	}
+
+	for _, install := range yq.installs {
+		status := InstallStatus{
+			SpecHash:       install.job.SpecHash,
+			WorkDir:        install.job.WorkDir,
+			PackageManager: install.job.Manager.Name(),
+			Immutable:      install.job.Immutable,
+			Waiters:        install.waiters,
+			QueuedAt:       install.queuedAt,
+		}
+		if install.startedAt.IsZero() {
+			status.WaitSeconds = now.Sub(install.queuedAt).Seconds()
+			stats.Queued = append(stats.Queued, status)
+		} else {
+			status.WaitSeconds = install.startedAt.Sub(install.queuedAt).Seconds()
+			status.RunningSeconds = now.Sub(install.startedAt).Seconds()
+			stats.Active = append(stats.Active, status)
+		}
+	}
+	stats.Depth = len(stats.Queued)
+
+	for _, list := range [][]InstallStatus{stats.Active, stats.Queued} {
+		sort.Slice(list, func(i, j int) bool { return list[i].QueuedAt.Before(list[j].QueuedAt) })
+	}
+
+	return stats
+}
@@ -0,0 +1,144 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/logger"
+)
+
+// fakePackageManager counts installs and blocks each one until release is closed
+type fakePackageManager struct {
+	installs atomic.Int32
+	started  chan struct{}
+	release  chan struct{}
+}
+
+func newFakePackageManager() *fakePackageManager {
+	return &fakePackageManager{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (f *fakePackageManager) Name() string              { return "fake" }
+func (f *fakePackageManager) Lockfile() string          { return "fake.lock" }
+func (f *fakePackageManager) WorkspaceProtocol() string { return "link:" }
+func (f *fakePackageManager) Prepare(string, RegistryConfig, logger.Logger) error {
+	return nil
+}
+
+func (f *fakePackageManager) Install(job *YarnInstallJob) error {
+	f.installs.Add(1)
+	f.started <- struct{}{}
+	select {
+	case <-f.release:
+		return nil
+	case <-job.Context.Done():
+		return job.Context.Err()
+	}
+}
+
+func newTestJob(workDir string, manager PackageManager) *YarnInstallJob {
+	return &YarnInstallJob{
+		WorkDir:  workDir,
+		SpecHash: "0123456789abcdef",
+		Logger:   logger.NewLogrusLogger("error", "text"),
+		Manager:  manager,
+	}
+}
+
+func TestYarnQueueMergesJobsForTheSameWorkspace(t *testing.T) {
+	queue := NewYarnQueue(1, time.Minute, logger.NewLogrusLogger("error", "text"))
+	manager := newFakePackageManager()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- queue.Install(context.Background(), newTestJob("/tmp/workspace", manager))
+		}()
+	}
+
+	<-manager.started
+	// Wait for every caller to join the running install
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Stats().Merged < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("callers were not merged: %+v", queue.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := queue.Stats()
+	if len(stats.Active) != 1 || stats.Active[0].Waiters != 3 {
+		t.Fatalf("Active = %+v, want one install with 3 waiters", stats.Active)
+	}
+
+	close(manager.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Install() error = %v", err)
+		}
+	}
+
+	if got := manager.installs.Load(); got != 1 {
+		t.Errorf("installs = %d, want 1", got)
+	}
+	if stats := queue.Stats(); stats.Finished != 1 || len(stats.Active) != 0 {
+		t.Errorf("Stats() = %+v, want one finished install and none active", stats)
+	}
+}
+
+func TestYarnQueueDropsQueuedJobWithoutWaiters(t *testing.T) {
+	queue := NewYarnQueue(1, time.Minute, logger.NewLogrusLogger("error", "text"))
+	manager := newFakePackageManager()
+
+	// Occupy the only slot
+	go func() { _ = queue.Install(context.Background(), newTestJob("/tmp/first", manager)) }()
+	<-manager.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- queue.Install(ctx, newTestJob("/tmp/second", manager)) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Stats().Depth != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("job was not queued: %+v", queue.Stats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Install() error = %v, want context.Canceled", err)
+	}
+	if stats := queue.Stats(); stats.Depth != 0 {
+		t.Errorf("Depth = %d after the only caller left, want 0", stats.Depth)
+	}
+
+	close(manager.release)
+	if got := manager.installs.Load(); got != 1 {
+		t.Errorf("installs = %d, want the cancelled job not to run", got)
+	}
+}
+
+func TestYarnQueueInstallTimeout(t *testing.T) {
+	queue := NewYarnQueue(1, 50*time.Millisecond, logger.NewLogrusLogger("error", "text"))
+	manager := newFakePackageManager()
+
+	err := queue.Install(context.Background(), newTestJob("/tmp/workspace", manager))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Install() error = %v, want a timeout", err)
+	}
+	if stats := queue.Stats(); stats.Failed != 1 {
+		t.Errorf("Failed = %d, want 1", stats.Failed)
+	}
+}
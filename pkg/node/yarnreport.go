@@ -0,0 +1,230 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Kinds of yarn install failures
+const (
+	// YarnFailureResolution means no version of a package matches its range
+	YarnFailureResolution = "resolution"
+	// YarnFailureFetch means a package archive could not be downloaded or read from the cache
+	YarnFailureFetch = "fetch"
+	// YarnFailureBuild means a package build script (postinstall, node-gyp...) failed
+	YarnFailureBuild = "build"
+	// YarnFailureLockfile means the install would modify an immutable yarn.lock
+	YarnFailureLockfile = "lockfile"
+	// YarnFailureOther is any other yarn error
+	YarnFailureOther = "other"
+)
+
+// InstallFailedReason is the reason of the warning reported when yarn install failed
+// but the process was started anyway
+const InstallFailedReason = "DependencyInstallFailed"
+
+// yarnFailureKinds maps yarn message codes (YNxxxx) to failure kinds
+var yarnFailureKinds = map[int]string{
+	9:  YarnFailureBuild,      // BUILD_FAILED
+	13: YarnFailureFetch,      // FETCH_NOT_CACHED, an error when the network is disabled
+	16: YarnFailureResolution, // REMOTE_NOT_FOUND
+	18: YarnFailureFetch,      // CACHE_CHECKSUM_MISMATCH
+	28: YarnFailureLockfile,   // FROZEN_LOCKFILE_EXCEPTION
+	30: YarnFailureFetch,      // FETCH_FAILED
+	33: YarnFailureFetch,      // AUTHENTICATION_NOT_FOUND
+	35: YarnFailureFetch,      // NETWORK_ERROR, also reported when the registry answers 404
+	41: YarnFailureFetch,      // AUTHENTICATION_INVALID
+	82: YarnFailureResolution, // RESOLUTION_FAILED, no candidates found
+}
+
+// YarnFailure is one error reported by yarn during an install
+type YarnFailure struct {
+	Kind    string `json:"kind"`
+	Code    string `json:"code"`              // Yarn message code, e.g. "YN0082"
+	Package string `json:"package,omitempty"` // Package the error is about, when known
+	Message string `json:"message"`
+}
+
+// String renders the failure as "package (kind, code): message"
+func (f YarnFailure) String() string {
+	if f.Package == "" {
+		return fmt.Sprintf("%s (%s): %s", f.Kind, f.Code, f.Message)
+	}
+	return fmt.Sprintf("%s (%s, %s): %s", f.Package, f.Kind, f.Code, f.Message)
+}
+
+// YarnInstallError is returned when yarn install fails. It holds the errors parsed
+// from the yarn JSON output.
+type YarnInstallError struct {
+	Failures []YarnFailure
+	Err      error // Error of the yarn command
+}
+
+// Error lists the failures, or the command error if yarn reported none
+func (e *YarnInstallError) Error() string {
+	if len(e.Failures) == 0 {
+		return fmt.Sprintf("yarn install failed: %v", e.Err)
+	}
+
+	lines := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		lines = append(lines, f.String())
+	}
+	return "yarn install failed: " + strings.Join(lines, "; ")
+}
+
+// Unwrap returns the error of the yarn command
+func (e *YarnInstallError) Unwrap() error {
+	return e.Err
+}
+
+// permanent reports whether every failure would happen again on retry: no version
+// matching a range, a build script failing or a lockfile to modify. Fetch failures, and
+// failures yarn did not report, may be transient.
+func (e *YarnInstallError) permanent() bool {
+	if len(e.Failures) == 0 {
+		return false
+	}
+	for _, f := range e.Failures {
+		if f.Kind != YarnFailureResolution && f.Kind != YarnFailureBuild && f.Kind != YarnFailureLockfile {
+			return false
+		}
+	}
+	return true
+}
+
+// UnresolvedPackages returns the packages that could not be resolved or fetched.
+// The function cannot run without them.
+func (e *YarnInstallError) UnresolvedPackages() []string {
+	var packages []string
+	for _, f := range e.Failures {
+		if (f.Kind == YarnFailureResolution || f.Kind == YarnFailureFetch) && f.Package != "" {
+			packages = append(packages, f.Package)
+		}
+	}
+	return packages
+}
+
+// hasKind reports whether one of the failures is of the given kind
+func (e *YarnInstallError) hasKind(kind string) bool {
+	for _, f := range e.Failures {
+		if f.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// yarnEvent is one line of `yarn --json` output
+type yarnEvent struct {
+	Type        string          `json:"type"`
+	Name        int             `json:"name"`
+	DisplayName string          `json:"displayName"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// yarnReport is an io.Writer collecting the errors of a `yarn --json` run
+type yarnReport struct {
+	mu       sync.Mutex
+	buffer   []byte
+	failures []YarnFailure
+	seen     map[YarnFailure]bool
+}
+
+// newYarnReport creates an empty report
+func newYarnReport() *yarnReport {
+	return &yarnReport{seen: make(map[YarnFailure]bool)}
+}
+
+// Write parses every complete line written so far
+func (r *yarnReport) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buffer = append(r.buffer, p...)
+	for {
+		i := strings.IndexByte(string(r.buffer), '\n')
+		if i < 0 {
+			break
+		}
+		r.parseLine(string(r.buffer[:i]))
+		r.buffer = r.buffer[i+1:]
+	}
+	return len(p), nil
+}
+
+// parseLine records the failure described by an error event
+func (r *yarnReport) parseLine(line string) {
+	var event yarnEvent
+	if err := json.Unmarshal([]byte(strings.TrimSpace(line)), &event); err != nil {
+		return
+	}
+	if event.Type != "error" {
+		return
+	}
+
+	var data string
+	if err := json.Unmarshal(event.Data, &data); err != nil {
+		return
+	}
+	// Messages are indented with box drawing characters
+	message := strings.TrimSpace(strings.TrimLeft(data, "│└┌ "))
+	if message == "" {
+		return
+	}
+
+	kind, ok := yarnFailureKinds[event.Name]
+	if !ok {
+		kind = YarnFailureOther
+	}
+	code := event.DisplayName
+	if code == "" {
+		code = fmt.Sprintf("YN%04d", event.Name)
+	}
+
+	failure := YarnFailure{
+		Kind:    kind,
+		Code:    code,
+		Package: packageNameFromMessage(message),
+		Message: message,
+	}
+
+	// Yarn may report the same error several times
+	if r.seen[failure] {
+		return
+	}
+	r.seen[failure] = true
+	r.failures = append(r.failures, failure)
+}
+
+// err returns a *YarnInstallError holding the parsed failures and the command error,
+// or nil if the command succeeded
+func (r *yarnReport) err(cmdErr error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Parse a last line without trailing newline
+	if len(r.buffer) > 0 {
+		r.parseLine(string(r.buffer))
+		r.buffer = nil
+	}
+
+	if cmdErr == nil {
+		return nil
+	}
+	return &YarnInstallError{Failures: r.failures, Err: cmdErr}
+}
+
+// packageNameFromMessage extracts the package name from a yarn message such as
+// "lodash@npm:^4.17.21: No candidates found" or "esbuild@npm:0.19.0 couldn't be built"
+func packageNameFromMessage(message string) string {
+	fields := strings.Fields(message)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	name, _ := splitDescriptor(strings.TrimSuffix(fields[0], ":"))
+	return name
+}
@@ -0,0 +1,98 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestYarnReportFailures(t *testing.T) {
+	report := newYarnReport()
+	output := `{"type":"info","name":0,"displayName":"YN0000","indent":"","data":"┌ Resolution step"}
+{"type":"error","name":82,"displayName":"YN0082","indent":"","data":"│ lodash@npm:^99.0.0: No candidates found"}
+{"type":"error","name":35,"displayName":"YN0035","indent":"","data":"│ @acme/utils@npm:1.2.3: The remote server failed to provide the requested resource"}
+{"type":"error","name":82,"displayName":"YN0082","indent":"","data":"│ lodash@npm:^99.0.0: No candidates found"}
+not json
+{"type":"error","name":9,"displayName":"YN0009","indent":"","data":"│ esbuild@npm:0.19.0 couldn't be built successfully (exit code 1, logs can be found here: /tmp/build.log)"}
+{"type":"error","name":28,"displayName":"YN0028","indent":"","data":"│ The lockfile would have been modified by this install, which is explicitly forbidden."}`
+
+	// Write in two chunks splitting a line to exercise buffering
+	if _, err := report.Write([]byte(output[:150])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := report.Write([]byte(output[150:])); err != nil {
+		t.Fatal(err)
+	}
+
+	var installErr *YarnInstallError
+	if err := report.err(&exec.ExitError{}); !errors.As(err, &installErr) {
+		t.Fatalf("expected *YarnInstallError, got %v", err)
+	}
+
+	want := []YarnFailure{
+		{Kind: YarnFailureResolution, Code: "YN0082", Package: "lodash", Message: "lodash@npm:^99.0.0: No candidates found"},
+		{Kind: YarnFailureFetch, Code: "YN0035", Package: "@acme/utils", Message: "@acme/utils@npm:1.2.3: The remote server failed to provide the requested resource"},
+		{Kind: YarnFailureBuild, Code: "YN0009", Package: "esbuild", Message: "esbuild@npm:0.19.0 couldn't be built successfully (exit code 1, logs can be found here: /tmp/build.log)"},
+		{Kind: YarnFailureLockfile, Code: "YN0028", Message: "The lockfile would have been modified by this install, which is explicitly forbidden."},
+	}
+	if !reflect.DeepEqual(installErr.Failures, want) {
+		t.Errorf("failures =\n%+v\nwant\n%+v", installErr.Failures, want)
+	}
+	if got, want := installErr.UnresolvedPackages(), []string{"lodash", "@acme/utils"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("UnresolvedPackages() = %v, want %v", got, want)
+	}
+	if !installErr.hasKind(YarnFailureLockfile) {
+		t.Error("expected a lockfile failure")
+	}
+}
+
+func TestYarnInstallErrorPermanent(t *testing.T) {
+	resolution := YarnFailure{Kind: YarnFailureResolution, Code: "YN0082", Package: "lodash"}
+	build := YarnFailure{Kind: YarnFailureBuild, Code: "YN0009", Package: "esbuild"}
+	fetch := YarnFailure{Kind: YarnFailureFetch, Code: "YN0035", Package: "@acme/utils"}
+
+	cases := []struct {
+		name      string
+		failures  []YarnFailure
+		permanent bool
+	}{
+		{"resolution", []YarnFailure{resolution}, true},
+		{"resolution and build", []YarnFailure{resolution, build}, true},
+		{"fetch", []YarnFailure{fetch}, false},
+		{"resolution and fetch", []YarnFailure{resolution, fetch}, false},
+		{"no failure reported", nil, false},
+	}
+	for _, tc := range cases {
+		err := fmt.Errorf("failed to install dependencies: %w", &YarnInstallError{Failures: tc.failures, Err: &exec.ExitError{}})
+		if got := isPermanent(err); got != tc.permanent {
+			t.Errorf("%s: isPermanent() = %v, want %v", tc.name, got, tc.permanent)
+		}
+	}
+}
+
+func TestYarnReportSuccess(t *testing.T) {
+	report := newYarnReport()
+	if _, err := report.Write([]byte(`{"type":"info","name":0,"displayName":"YN0000","data":"Done"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.err(nil); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestPackageNameFromMessage(t *testing.T) {
+	cases := map[string]string{
+		"lodash@npm:^4.17.21: No candidates found": "lodash",
+		"@scope/pkg@npm:1.0.0: Not found":          "@scope/pkg",
+		"esbuild@npm:0.19.0 couldn't be built":     "esbuild",
+		"Something went wrong":                     "",
+		"some sentence with @ sign: details":       "",
+	}
+	for message, want := range cases {
+		if got := packageNameFromMessage(message); got != want {
+			t.Errorf("packageNameFromMessage(%q) = %q, want %q", message, got, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package readiness derives the readiness of composed resources from their observed
+// state, for the resources a function does not mark ready itself.
+package readiness
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// ConditionReady is the condition checked for the kinds without rule
+const ConditionReady = "Ready"
+
+// Rule is how the readiness of the resources of a kind is derived
+type Rule struct {
+	// APIVersion of the resources, e.g. apps/v1. Empty matches every version.
+	APIVersion string `json:"apiVersion,omitempty"`
+	// Kind of the resources
+	Kind string `json:"kind"`
+	// Condition is the type of the condition that must be True, Ready when empty
+	Condition string `json:"condition,omitempty"`
+	// Exists marks the resources ready as soon as they are observed
+	Exists bool `json:"exists,omitempty"`
+}
+
+// Rules derives the readiness of resources. The zero value checks the Ready condition of
+// every resource.
+type Rules struct {
+	// Rules are checked from the last to the first, the first matching rule applies
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// DefaultRules are the rules of the Kubernetes kinds commonly composed, which have no
+// Ready condition
+func DefaultRules() *Rules {
+	return &Rules{Rules: []Rule{
+		{APIVersion: "apps/v1", Kind: "Deployment", Condition: "Available"},
+		{APIVersion: "batch/v1", Kind: "Job", Condition: "Complete"},
+		{APIVersion: "v1", Kind: "ConfigMap", Exists: true},
+		{APIVersion: "v1", Kind: "Secret", Exists: true},
+	}}
+}
+
+// LoadRules reads rules from a YAML or JSON file. They are added to the default rules
+// and take precedence over them.
+func LoadRules(file string) (*Rules, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read readiness rules file: %w", err)
+	}
+
+	loaded := &Rules{}
+	if err := yaml.UnmarshalStrict(content, loaded); err != nil {
+		return nil, fmt.Errorf("failed to parse readiness rules file %s: %w", file, err)
+	}
+	if err := loaded.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid readiness rules file %s: %w", file, err)
+	}
+
+	rules := DefaultRules()
+	rules.Rules = append(rules.Rules, loaded.Rules...)
+	return rules, nil
+}
+
+// Validate checks that every rule has a kind and a single check
+func (r *Rules) Validate() error {
+	for i, rule := range r.Rules {
+		if rule.Kind == "" {
+			return fmt.Errorf("rule %d has no kind", i)
+		}
+		if rule.Exists && rule.Condition != "" {
+			return fmt.Errorf("rule of %s: condition and exists are mutually exclusive", rule.Kind)
+		}
+	}
+	return nil
+}
+
+// Ready returns whether the observed resource is ready
+func (r *Rules) Ready(observed map[string]interface{}) bool {
+	u := &unstructured.Unstructured{Object: observed}
+	condition := ConditionReady
+	if rule := r.match(u.GetAPIVersion(), u.GetKind()); rule != nil {
+		if rule.Exists {
+			return true
+		}
+		if rule.Condition != "" {
+			condition = rule.Condition
+		}
+	}
+	return conditionTrue(observed, condition)
+}
+
+// match returns the rule of the kind, nil when there is none
+func (r *Rules) match(apiVersion, kind string) *Rule {
+	for i := len(r.Rules) - 1; i >= 0; i-- {
+		rule := &r.Rules[i]
+		if rule.Kind == kind && (rule.APIVersion == "" || rule.APIVersion == apiVersion) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// conditionTrue returns whether the status of the object has the condition with status True
+func conditionTrue(object map[string]interface{}, conditionType string) bool {
+	conditions, _, _ := unstructured.NestedSlice(object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == conditionType {
+			return condition["status"] == "True"
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package readiness
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func object(apiVersion, kind string, conditions ...map[string]interface{}) map[string]interface{} {
+	list := make([]interface{}, len(conditions))
+	for i, c := range conditions {
+		list[i] = c
+	}
+	return map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"status":     map[string]interface{}{"conditions": list},
+	}
+}
+
+func condition(conditionType, status string) map[string]interface{} {
+	return map[string]interface{}{"type": conditionType, "status": status}
+}
+
+func TestDefaultRules(t *testing.T) {
+	rules := DefaultRules()
+	cases := []struct {
+		name     string
+		observed map[string]interface{}
+		ready    bool
+	}{
+		{"ready managed resource", object("s3.aws.upbound.io/v1beta1", "Bucket", condition("Synced", "True"), condition("Ready", "True")), true},
+		{"unready managed resource", object("s3.aws.upbound.io/v1beta1", "Bucket", condition("Ready", "False")), false},
+		{"resource without conditions", object("v1", "Service"), false},
+		{"available deployment", object("apps/v1", "Deployment", condition("Available", "True")), true},
+		{"progressing deployment", object("apps/v1", "Deployment", condition("Progressing", "True")), false},
+		{"complete job", object("batch/v1", "Job", condition("Complete", "True")), true},
+		{"running job", object("batch/v1", "Job"), false},
+		{"config map", object("v1", "ConfigMap"), true},
+		{"deployment of another group", object("example.org/v1", "Deployment", condition("Available", "True")), false},
+	}
+	for _, tc := range cases {
+		if got := rules.Ready(tc.observed); got != tc.ready {
+			t.Errorf("%s: Ready() = %v, want %v", tc.name, got, tc.ready)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.yaml")
+	content := `rules:
+- kind: Deployment
+  condition: Ready
+- apiVersion: v1
+  kind: Service
+  exists: true
+`
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(file)
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	if !rules.Ready(object("v1", "Service")) {
+		t.Error("Service is not ready with an exists rule")
+	}
+	if rules.Ready(object("apps/v1", "Deployment", condition("Available", "True"))) {
+		t.Error("the default Deployment rule applies over the loaded rule")
+	}
+	if !rules.Ready(object("v1", "ConfigMap")) {
+		t.Error("the default ConfigMap rule no longer applies")
+	}
+
+	for _, invalid := range []string{
+		"rules:\n- condition: Ready\n",
+		"rules:\n- kind: Service\n  exists: true\n  condition: Ready\n",
+		"kinds: []\n",
+	} {
+		if err := os.WriteFile(file, []byte(invalid), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRules(file); err == nil {
+			t.Errorf("LoadRules() accepted %q", invalid)
+		}
+	}
+}
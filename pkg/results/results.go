@@ -0,0 +1,96 @@
+package results
+
+import (
+	"github.com/crossplane/crossplane-runtime/pkg/errors"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"k8s.io/utils/ptr"
+)
+
+// Severity of a result.
+type Severity string
+
+const (
+	// SeverityFatal fails the function pipeline with the message of the result.
+	SeverityFatal Severity = "Fatal"
+
+	// SeverityWarning emits a warning event without failing the pipeline.
+	SeverityWarning Severity = "Warning"
+
+	// SeverityNormal emits a normal event.
+	SeverityNormal Severity = "Normal"
+)
+
+// Result is a result returned by the JavaScript function.
+type Result struct {
+	// Severity of the result. Optional. One of Fatal, Warning or Normal, defaults to Normal.
+	Severity *Severity `json:"severity"`
+	// Reason of the result. Optional.
+	Reason *string `json:"reason"`
+	// The target(s) of the result. Can be Composite or CompositeAndClaim. Defaults to
+	// Composite
+	Target *string `json:"target"`
+	// Message of the result. Required.
+	Message string `json:"message"`
+}
+
+// JSResults represents results from JavaScript function response
+type JSResults []Result
+
+// HasFatal reports whether one of the results is Fatal
+func (rs JSResults) HasFatal() bool {
+	for _, r := range rs {
+		if ptr.Deref(r.Severity, SeverityNormal) == SeverityFatal {
+			return true
+		}
+	}
+	return false
+}
+
+// SetResults transforms the results and appends them to the RunFunctionResponse. Nothing
+// is appended when one of them is invalid.
+func SetResults(rsp *fnv1.RunFunctionResponse, rs JSResults) error {
+	transformed := make([]*fnv1.Result, 0, len(rs))
+	for i, r := range rs {
+		result, err := transformResult(r)
+		if err != nil {
+			return errors.Wrapf(err, "invalid result %d", i)
+		}
+		transformed = append(transformed, result)
+	}
+	rsp.Results = append(rsp.Results, transformed...)
+	return nil
+}
+
+// transformResult converts a Result into a fnv1.Result
+func transformResult(r Result) (*fnv1.Result, error) {
+	if r.Message == "" {
+		return nil, errors.New("message is required")
+	}
+
+	result := &fnv1.Result{
+		Reason:  r.Reason,
+		Message: r.Message,
+	}
+
+	switch severity := ptr.Deref(r.Severity, SeverityNormal); severity {
+	case SeverityFatal:
+		result.Severity = fnv1.Severity_SEVERITY_FATAL
+	case SeverityWarning:
+		result.Severity = fnv1.Severity_SEVERITY_WARNING
+	case SeverityNormal:
+		result.Severity = fnv1.Severity_SEVERITY_NORMAL
+	default:
+		return nil, errors.Errorf("invalid severity %s, must be one of [Fatal, Warning, Normal]", severity)
+	}
+
+	switch target := ptr.Deref(r.Target, "Composite"); target {
+	case "Composite":
+		result.Target = fnv1.Target_TARGET_COMPOSITE.Enum()
+	case "CompositeAndClaim":
+		result.Target = fnv1.Target_TARGET_COMPOSITE_AND_CLAIM.Enum()
+	default:
+		return nil, errors.Errorf("invalid target %s, must be one of [Composite, CompositeAndClaim]", target)
+	}
+
+	return result, nil
+}
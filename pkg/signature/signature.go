@@ -0,0 +1,189 @@
+// Package signature verifies the detached signatures of function sources against the
+// trusted public keys of the server.
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	_ "crypto/sha256" // SHA-256 digests of P-256 signatures
+	_ "crypto/sha512" // SHA-384 and SHA-512 digests of P-384 and P-521 signatures
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// bundle is the canonical form of a function source: everything that changes the code
+// run by the function. Maps are marshalled with sorted keys.
+type bundle struct {
+	Inline         string            `json:"inline,omitempty"`
+	Entrypoint     string            `json:"entrypoint,omitempty"`
+	Files          map[string]string `json:"files,omitempty"`
+	Dependencies   map[string]string `json:"dependencies,omitempty"`
+	PackageManager string            `json:"packageManager"`
+	Lockfile       string            `json:"lockfile,omitempty"`
+	TsConfig       string            `json:"tsConfig,omitempty"`
+}
+
+// Bundle returns the canonical source bundle signed by spec.source.signature: the JSON of
+// the decoded source, without HTML escaping nor trailing newline
+func Bundle(input *types.XFuncJSInput) ([]byte, error) {
+	source := input.Spec.Source
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err := encoder.Encode(bundle{
+		Inline:         source.Inline,
+		Entrypoint:     source.Entrypoint,
+		Files:          source.Files,
+		Dependencies:   source.Dependencies,
+		PackageManager: input.GetPackageManager(),
+		Lockfile:       input.Lockfile(),
+		TsConfig:       source.TsConfig,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal source bundle: %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// VerificationError reports an unsigned source or a signature that no trusted key verifies
+type VerificationError struct {
+	Reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "source signature verification failed: " + e.Reason
+}
+
+// Verifier verifies source signatures against the public keys of a directory
+type Verifier struct {
+	keysDir  string
+	required bool
+}
+
+// NewVerifier creates a Verifier reading the trusted public keys from keysDir. When
+// required is set, unsigned sources are rejected.
+func NewVerifier(keysDir string, required bool) *Verifier {
+	return &Verifier{keysDir: keysDir, required: required}
+}
+
+// Required reports whether unsigned or invalid sources are rejected
+func (v *Verifier) Required() bool {
+	return v.required
+}
+
+// Verify checks the signature of a decoded source. It returns a *VerificationError when
+// the source is unsigned and signatures are required, or when no trusted key verifies
+// the signature. The keys are read on every call, so they can be rotated.
+func (v *Verifier) Verify(input *types.XFuncJSInput) error {
+	encoded := input.Spec.Source.Signature
+	if encoded == "" {
+		if v.required {
+			return &VerificationError{Reason: "the source is not signed (spec.source.signature)"}
+		}
+		return nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return &VerificationError{Reason: fmt.Sprintf("invalid base64 signature: %v", err)}
+	}
+	keys, err := LoadKeys(v.keysDir)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return &VerificationError{Reason: "no trusted public key in " + v.keysDir}
+	}
+	message, err := Bundle(input)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if verify(key, message, sig) {
+			return nil
+		}
+	}
+	return &VerificationError{Reason: "the signature does not match the source or any trusted key"}
+}
+
+// LoadKeys reads the PEM encoded public keys (PKIX, "PUBLIC KEY") of a directory. Hidden
+// entries, such as the ..data links of mounted Secrets, are skipped.
+func LoadKeys(dir string) ([]crypto.PublicKey, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature keys directory: %w", err)
+	}
+
+	var keys []crypto.PublicKey
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature key %s: %w", entry.Name(), err)
+		}
+		for rest := data; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			if block.Type != "PUBLIC KEY" {
+				continue
+			}
+			key, err := parseKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid signature key %s: %w", entry.Name(), err)
+			}
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// parseKey parses an ed25519 or ECDSA PKIX public key
+func parseKey(der []byte) (crypto.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, errors.New("only ed25519 and ECDSA keys are supported")
+}
+
+// verify checks an ed25519 signature of message, or an ASN.1 ECDSA signature of its
+// digest with the hash of the curve (SHA-256 for P-256, SHA-384 for P-384, SHA-512 for P-521)
+func verify(key crypto.PublicKey, message, sig []byte) bool {
+	switch key := key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig)
+	case *ecdsa.PublicKey:
+		hash := crypto.SHA256
+		switch key.Curve {
+		case elliptic.P384():
+			hash = crypto.SHA384
+		case elliptic.P521():
+			hash = crypto.SHA512
+		}
+		h := hash.New()
+		h.Write(message)
+		return ecdsa.VerifyASN1(key, h.Sum(nil), sig)
+	}
+	return false
+}
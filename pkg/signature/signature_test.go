@@ -0,0 +1,109 @@
+package signature
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// writeKey writes a PEM public key into dir
+func writeKey(t *testing.T, dir, name string, key interface{}) {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newSignedInput() *types.XFuncJSInput {
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Inline = `export default () => ({ resources: { "a&b": {} } })`
+	input.Spec.Source.Dependencies = map[string]string{"lodash": "^4.17.21"}
+	input.Spec.Source.YarnLock = "# yarn lockfile"
+	return input
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "ci-ed25519.pub", edPublic)
+	writeKey(t, dir, "ci-ecdsa.pub", &ecPrivate.PublicKey)
+
+	input := newSignedInput()
+	message, err := Bundle(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(message)
+	ecSignature, err := ecdsa.SignASN1(rand.Reader, ecPrivate, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := NewVerifier(dir, true)
+	for name, sig := range map[string][]byte{"ed25519": ed25519.Sign(edPrivate, message), "ecdsa": ecSignature} {
+		input.Spec.Source.Signature = base64.StdEncoding.EncodeToString(sig)
+		if err := verifier.Verify(input); err != nil {
+			t.Errorf("Verify() with a valid %s signature error = %v", name, err)
+		}
+	}
+
+	// Any change to the bundle invalidates the signature
+	input.Spec.Source.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(edPrivate, message))
+	input.Spec.Source.Dependencies["lodash"] = "^4.17.22"
+	var verifyErr *VerificationError
+	if err := verifier.Verify(input); !errors.As(err, &verifyErr) {
+		t.Errorf("Verify() of a modified source = %v, want a VerificationError", err)
+	}
+
+	// Unsigned sources are only rejected when signatures are required
+	unsigned := newSignedInput()
+	if err := verifier.Verify(unsigned); !errors.As(err, &verifyErr) {
+		t.Errorf("Verify() of an unsigned source = %v, want a VerificationError", err)
+	}
+	if err := NewVerifier(dir, false).Verify(unsigned); err != nil {
+		t.Errorf("Verify() of an unsigned source without enforcement error = %v", err)
+	}
+}
+
+func TestLoadKeysRejectsUnsupportedKeys(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "..data"), []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := LoadKeys(dir); err != nil || len(keys) != 0 {
+		t.Errorf("LoadKeys() = %v, %v, want hidden entries skipped", keys, err)
+	}
+
+	// RSA keys are not accepted
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey(t, dir, "rsa.pub", &rsaPrivate.PublicKey)
+	if _, err := LoadKeys(dir); err == nil {
+		t.Error("LoadKeys() accepted an RSA key")
+	}
+}
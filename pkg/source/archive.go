@@ -0,0 +1,82 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// extractArchive adds the regular files of a tar or tar.gz archive to files. The total
+// size of the files is bounded by maxSize.
+func extractArchive(archive []byte, files map[string]string, maxSize int64) error {
+	var reader io.Reader = bytes.NewReader(archive)
+	if bytes.HasPrefix(archive, gzipMagic) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read gzip archive: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	size := totalSize(files)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		default:
+			// Links could point outside of the source
+			return fmt.Errorf("unsupported archive entry %s: only regular files and directories are allowed", header.Name)
+		}
+
+		name, err := archivePath(header.Name)
+		if err != nil {
+			return err
+		}
+		size += header.Size
+		if size > maxSize {
+			return fmt.Errorf("archive content exceeds %d bytes", maxSize)
+		}
+		content, err := io.ReadAll(io.LimitReader(tr, header.Size))
+		if err != nil {
+			return fmt.Errorf("failed to read archive entry %s: %w", name, err)
+		}
+		files[name] = string(content)
+	}
+}
+
+// archivePath returns the clean relative path of an archive entry
+func archivePath(name string) (string, error) {
+	clean := path.Clean(strings.TrimPrefix(name, "./"))
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("invalid archive entry %s: must stay within the archive", name)
+	}
+	return clean, nil
+}
+
+// totalSize returns the size of the content of files
+func totalSize(files map[string]string) int64 {
+	var size int64
+	for _, content := range files {
+		size += int64(len(content))
+	}
+	return size
+}
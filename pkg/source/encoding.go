@@ -0,0 +1,157 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// maxDecodedSize bounds the decompressed size of a source field
+const maxDecodedSize = 64 << 20
+
+// Decode decodes inline, files, tsConfig and the lockfiles of input with the encoding of
+// spec.source.encoding. Decoding is strict: content that does not match the encoding is
+// an error. The decoded input has the identity encoding, so its hash only depends on the
+// content.
+//
+// Without encoding, lockfiles holding base64 gzip content, as written by older versions of
+// the CLI, are still decoded.
+func Decode(input *types.XFuncJSInput) error {
+	source := &input.Spec.Source
+	encoding := source.Encoding
+	if encoding == types.SourceEncodingIdentity {
+		source.Encoding = ""
+		return nil
+	}
+
+	if encoding == "" {
+		for _, lockfile := range []struct {
+			field string
+			value *string
+		}{
+			{"yarnLock", &source.YarnLock},
+			{"packageLock", &source.PackageLock},
+			{"pnpmLock", &source.PnpmLock},
+		} {
+			if !isLegacyGzipBase64(*lockfile.value) {
+				continue
+			}
+			decoded, err := decodeField(*lockfile.value, types.SourceEncodingGzipBase64)
+			if err != nil {
+				return fmt.Errorf("source.%s: %w", lockfile.field, err)
+			}
+			*lockfile.value = decoded
+		}
+		return nil
+	}
+
+	fields := []struct {
+		name  string
+		value *string
+	}{
+		{"inline", &source.Inline},
+		{"tsConfig", &source.TsConfig},
+		{"yarnLock", &source.YarnLock},
+		{"packageLock", &source.PackageLock},
+		{"pnpmLock", &source.PnpmLock},
+	}
+	for _, field := range fields {
+		if *field.value == "" {
+			continue
+		}
+		decoded, err := decodeField(*field.value, encoding)
+		if err != nil {
+			return fmt.Errorf("source.%s: %w", field.name, err)
+		}
+		*field.value = decoded
+	}
+	for name, content := range source.Files {
+		decoded, err := decodeField(content, encoding)
+		if err != nil {
+			return fmt.Errorf("source.files[%s]: %w", name, err)
+		}
+		source.Files[name] = decoded
+	}
+
+	source.Encoding = ""
+	return nil
+}
+
+// isLegacyGzipBase64 reports whether a lockfile is base64 encoded gzip content. A raw
+// lockfile is never valid base64, as it holds spaces or newlines.
+func isLegacyGzipBase64(lockfile string) bool {
+	decoded, err := base64.StdEncoding.DecodeString(lockfile)
+	return err == nil && bytes.HasPrefix(decoded, gzipMagic)
+}
+
+// decodeField decodes a value with one of the encodings of source.encoding
+func decodeField(value, encoding string) (string, error) {
+	// Long base64 values are often wrapped
+	compact := strings.Join(strings.Fields(value), "")
+	data, err := base64.StdEncoding.DecodeString(compact)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s content: %w", encoding, err)
+	}
+
+	switch encoding {
+	case types.SourceEncodingBase64:
+		return string(data), nil
+	case types.SourceEncodingGzipBase64:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", fmt.Errorf("invalid %s content: %w", encoding, err)
+		}
+		defer zr.Close()
+		return readDecoded(zr, encoding)
+	case types.SourceEncodingZstdBase64:
+		return zstdDecompress(data)
+	}
+	return "", fmt.Errorf("unsupported encoding %s", encoding)
+}
+
+// readDecoded reads decompressed content, bounded by maxDecodedSize
+func readDecoded(r io.Reader, encoding string) (string, error) {
+	decoded, err := io.ReadAll(io.LimitReader(r, maxDecodedSize+1))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s content: %w", encoding, err)
+	}
+	if len(decoded) > maxDecodedSize {
+		return "", fmt.Errorf("decoded content exceeds %d bytes", maxDecodedSize)
+	}
+	return string(decoded), nil
+}
+
+// zstdDecompress decompresses zstd content with the zstd CLI
+func zstdDecompress(data []byte) (string, error) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		return "", errors.New("zstd+base64 requires the zstd command, which is not installed")
+	}
+
+	cmd := exec.Command("zstd", "--decompress", "--stdout", "--quiet")
+	cmd.Stdin = bytes.NewReader(data)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("failed to run zstd: %w", err)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("failed to run zstd: %w", err)
+	}
+
+	decoded, readErr := readDecoded(stdout, types.SourceEncodingZstdBase64)
+	if readErr != nil {
+		_ = cmd.Process.Kill()
+	}
+	if err := cmd.Wait(); err != nil && readErr == nil {
+		return "", fmt.Errorf("invalid %s content: %s", types.SourceEncodingZstdBase64, strings.TrimSpace(stderr.String()))
+	}
+	return decoded, readErr
+}
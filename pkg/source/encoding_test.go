@@ -0,0 +1,107 @@
+package source
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"os/exec"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+func gzipBase64(t *testing.T, content string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestDecode(t *testing.T) {
+	const code = "export default () => ({})"
+	const lockfile = "# yarn lockfile\n"
+
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Encoding = types.SourceEncodingGzipBase64
+	input.Spec.Source.Inline = gzipBase64(t, code)
+	input.Spec.Source.YarnLock = gzipBase64(t, lockfile)
+	input.Spec.Source.TsConfig = gzipBase64(t, "{}")
+	input.Spec.Source.Files = map[string]string{"lib.ts": gzipBase64(t, "export const a = 1")}
+	if err := input.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if err := Decode(input); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	source := input.Spec.Source
+	if source.Inline != code || source.YarnLock != lockfile || source.TsConfig != "{}" || source.Files["lib.ts"] != "export const a = 1" {
+		t.Errorf("Decode() = %+v, want the decoded fields", source)
+	}
+	if source.Encoding != "" {
+		t.Errorf("Decode() left encoding %q", source.Encoding)
+	}
+}
+
+func TestDecodeIsStrict(t *testing.T) {
+	tests := []struct {
+		encoding string
+		inline   string
+	}{
+		{types.SourceEncodingBase64, "export default () => ({})"},
+		{types.SourceEncodingGzipBase64, base64.StdEncoding.EncodeToString([]byte("not gzip"))},
+		{types.SourceEncodingZstdBase64, base64.StdEncoding.EncodeToString([]byte("not zstd"))},
+	}
+	for _, tt := range tests {
+		input := &types.XFuncJSInput{}
+		input.Spec.Source.Encoding = tt.encoding
+		input.Spec.Source.Inline = tt.inline
+		if err := Decode(input); err == nil {
+			t.Errorf("Decode() accepted content that is not %s", tt.encoding)
+		}
+	}
+}
+
+func TestDecodeZstd(t *testing.T) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		t.Skip("zstd is not installed")
+	}
+	const code = "export default () => ({})"
+	compressed, err := exec.Command("sh", "-c", `printf '%s' "$0" | zstd --quiet --stdout`, code).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Encoding = types.SourceEncodingZstdBase64
+	input.Spec.Source.Inline = base64.StdEncoding.EncodeToString(compressed)
+	if err := Decode(input); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if input.Spec.Source.Inline != code {
+		t.Errorf("Decode() = %q, want %q", input.Spec.Source.Inline, code)
+	}
+}
+
+func TestDecodeLegacyLockfile(t *testing.T) {
+	const lockfile = "lockfileVersion: '9.0'\n"
+
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Inline = "export default () => ({})"
+	input.Spec.Source.YarnLock = gzipBase64(t, lockfile)
+	if err := Decode(input); err != nil || input.Spec.Source.YarnLock != lockfile {
+		t.Errorf("Decode() = %q, %v, want the gunzipped lockfile", input.Spec.Source.YarnLock, err)
+	}
+
+	// Raw lockfiles are kept as they are
+	input.Spec.Source.YarnLock = lockfile
+	if err := Decode(input); err != nil || input.Spec.Source.YarnLock != lockfile {
+		t.Errorf("Decode() = %q, %v, want the raw lockfile", input.Spec.Source.YarnLock, err)
+	}
+}
@@ -0,0 +1,249 @@
+// Package source loads function sources: it decodes the encoded source fields and fetches
+// the sources published outside of the Composition (OCI artifacts, HTTP(S) tarballs and
+// git repositories), pinned by digest and cached on disk.
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// DefaultMaxSize bounds the size of a fetched source when Config.MaxSize is not set
+const DefaultMaxSize = 32 << 20
+
+// fetchTimeout bounds a single HTTP request
+const fetchTimeout = 2 * time.Minute
+
+// Config configures how remote sources are fetched
+type Config struct {
+	// CacheDir holds the fetched sources, by digest
+	CacheDir string
+	// RegistryMirror replaces the registry of every OCI reference, e.g.
+	// registry.internal:5000 or http://localhost:5000
+	RegistryMirror string
+	// Proxy is the HTTP(S) proxy URL of every fetch. The proxy environment variables
+	// are used when empty.
+	Proxy string
+	// CredentialsFile is a docker config.json holding the credentials of each host. It is
+	// read on every fetch, so the credentials can be rotated.
+	CredentialsFile string
+	// MaxSize bounds the size of an archive and of its extracted content
+	MaxSize int64
+}
+
+// Fetcher fetches remote sources and caches them on disk
+type Fetcher struct {
+	config Config
+	client *http.Client
+	// allowFileGit lets git sources use file:// URLs, for tests only
+	allowFileGit bool
+}
+
+// NewFetcher creates a Fetcher
+func NewFetcher(config Config) (*Fetcher, error) {
+	if config.CacheDir == "" {
+		return nil, fmt.Errorf("remote source cache directory is required")
+	}
+	if config.MaxSize <= 0 {
+		config.MaxSize = DefaultMaxSize
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxy, err := url.Parse(config.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote source proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	return &Fetcher{
+		config: config,
+		client: &http.Client{Transport: transport, Timeout: fetchTimeout},
+	}, nil
+}
+
+// Fetch returns the files of a remote source, relative to its path. The source is read
+// from the cache when it was fetched before.
+func (f *Fetcher) Fetch(ctx context.Context, remote *types.RemoteSource) (map[string]string, error) {
+	if err := remote.Validate(); err != nil {
+		return nil, err
+	}
+
+	kind, fetch := "oci", f.fetchOCI
+	switch {
+	case remote.URL != "":
+		kind, fetch = "http", f.fetchHTTP
+	case remote.Git != "":
+		kind, fetch = "git", f.fetchGit
+	}
+
+	cacheDir := filepath.Join(f.config.CacheDir, kind, strings.TrimPrefix(remote.Digest, "sha256:"))
+	files, err := readCache(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		if files, err = fetch(ctx, remote); err != nil {
+			return nil, err
+		}
+		if err := writeCache(cacheDir, files); err != nil {
+			return nil, err
+		}
+	}
+
+	return subtree(files, remote.Path)
+}
+
+// Resolve replaces the remote source of input with the fetched files. Lockfiles,
+// tsconfig.json and the dependencies of package.json are used when the spec does not set
+// them. The resolved input no longer references the remote source, so its hash only
+// depends on the content.
+func (f *Fetcher) Resolve(ctx context.Context, input *types.XFuncJSInput) error {
+	remote := input.Spec.Source.Remote
+	if remote == nil {
+		return nil
+	}
+
+	files, err := f.Fetch(ctx, remote)
+	if err != nil {
+		return err
+	}
+
+	source := &input.Spec.Source
+	lockfiles := map[string]*string{
+		"yarn.lock":         &source.YarnLock,
+		"package-lock.json": &source.PackageLock,
+		"pnpm-lock.yaml":    &source.PnpmLock,
+	}
+	source.Files = make(map[string]string, len(files))
+	for name, content := range files {
+		switch {
+		case name == "package.json":
+			if len(source.Dependencies) == 0 {
+				if source.Dependencies, err = packageDependencies(content); err != nil {
+					return err
+				}
+			}
+		case name == "tsconfig.json":
+			if source.TsConfig == "" {
+				source.TsConfig = content
+			}
+		case lockfiles[name] != nil:
+			// Only the lockfile of the selected package manager is used
+			if lockfile := lockfiles[name]; *lockfile == "" && types.LockfileName(input.GetPackageManager()) == name {
+				*lockfile = content
+			}
+		case types.ValidateSourcePath(name) == nil:
+			source.Files[name] = content
+		}
+	}
+	if source.Entrypoint == "" {
+		source.Entrypoint = "index.ts"
+	}
+	source.Remote = nil
+
+	if err := input.Validate(); err != nil {
+		return fmt.Errorf("invalid remote source: %w", err)
+	}
+	return nil
+}
+
+// packageDependencies returns the dependencies of a package.json
+func packageDependencies(content string) (map[string]string, error) {
+	var packageJSON struct {
+		Dependencies map[string]string `json:"dependencies"`
+	}
+	if err := json.Unmarshal([]byte(content), &packageJSON); err != nil {
+		return nil, fmt.Errorf("failed to parse package.json: %w", err)
+	}
+	return packageJSON.Dependencies, nil
+}
+
+// subtree returns the files under dir, relative to it
+func subtree(files map[string]string, dir string) (map[string]string, error) {
+	if dir == "" {
+		return files, nil
+	}
+	prefix := path.Clean(dir) + "/"
+	result := make(map[string]string)
+	for name, content := range files {
+		if rel, ok := strings.CutPrefix(name, prefix); ok {
+			result[rel] = content
+		}
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("remote source has no file under %s", dir)
+	}
+	return result, nil
+}
+
+// readCache returns the files cached in dir, or nil when the source is not cached
+func readCache(dir string) (map[string]string, error) {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cached source %s: %w", dir, err)
+	}
+	return files, nil
+}
+
+// writeCache stores verified files in dir. The files are written to a temporary
+// directory first, so a partially written source is never read.
+func writeCache(dir string, files map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return fmt.Errorf("failed to create source cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create source cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, content := range files {
+		target := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to cache %s: %w", name, err)
+		}
+		if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to cache %s: %w", name, err)
+		}
+	}
+
+	// Another fetch of the same digest may have been cached meanwhile
+	if err := os.Rename(tmp, dir); err != nil {
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+		return fmt.Errorf("failed to cache source: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package source
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+var testSourceFiles = map[string]string{
+	"fn/index.ts":      `import { helper } from "./lib/helper.ts"; export default helper`,
+	"fn/lib/helper.ts": `export const helper = () => ({})`,
+	"fn/package.json":  `{"dependencies": {"lodash": "^4.17.21"}}`,
+	"fn/yarn.lock":     "# yarn lockfile",
+	"README.md":        "# functions",
+}
+
+// tarGz builds a tar.gz archive of files
+func tarGz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func newTestFetcher(t *testing.T, config Config) *Fetcher {
+	t.Helper()
+	config.CacheDir = t.TempDir()
+	fetcher, err := NewFetcher(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetcher
+}
+
+func TestFetchHTTP(t *testing.T) {
+	archive := tarGz(t, testSourceFiles)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+	fetcher := newTestFetcher(t, Config{})
+
+	remote := &types.RemoteSource{URL: server.URL + "/fn.tgz", Path: "fn", Digest: digestOf(archive)}
+	files, err := fetcher.Fetch(context.Background(), remote)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if len(files) != 4 || files["lib/helper.ts"] != testSourceFiles["fn/lib/helper.ts"] {
+		t.Errorf("Fetch() = %v, want the files under fn", files)
+	}
+
+	// The source is cached by digest
+	server.Close()
+	if _, err := fetcher.Fetch(context.Background(), remote); err != nil {
+		t.Errorf("Fetch() from the cache error = %v", err)
+	}
+
+	// Content that does not match the digest is rejected
+	tampered := &types.RemoteSource{URL: remote.URL, Digest: digestOf([]byte("other"))}
+	if _, err := newTestFetcher(t, Config{}).Fetch(context.Background(), tampered); err == nil {
+		t.Error("Fetch() accepted content that does not match the digest")
+	}
+}
+
+func TestFetchOCI(t *testing.T) {
+	layer := tarGz(t, testSourceFiles)
+	file := []byte(`export const extra = 1`)
+	manifest, _ := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"layers": []map[string]interface{}{
+			{"mediaType": "application/vnd.oci.image.layer.v1.tar+gzip", "digest": digestOf(layer), "size": len(layer)},
+			{"mediaType": "application/typescript", "digest": digestOf(file), "size": len(file),
+				"annotations": map[string]string{titleAnnotation: "fn/extra.ts"}},
+		},
+	})
+	blobs := map[string][]byte{digestOf(manifest): manifest, digestOf(layer): layer, digestOf(file): file}
+
+	// A registry with token authentication, like ghcr.io
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			if username, password, ok := r.BasicAuth(); !ok || username != "ci" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`{"token": "registry-token"}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="registry",scope="repository:acme/fn:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		parts := strings.Split(r.URL.Path, "/")
+		if !strings.HasPrefix(r.URL.Path, "/v2/acme/fn/") || blobs[parts[len(parts)-1]] == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(blobs[parts[len(parts)-1]])
+	}))
+	defer server.Close()
+
+	credentialsFile := filepath.Join(t.TempDir(), "config.json")
+	host := strings.TrimPrefix(server.URL, "http://")
+	if err := os.WriteFile(credentialsFile, []byte(`{"auths": {"`+host+`": {"username": "ci", "password": "secret"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fetcher := newTestFetcher(t, Config{RegistryMirror: server.URL, CredentialsFile: credentialsFile})
+
+	remote := &types.RemoteSource{OCI: "ghcr.io/acme/fn:v1", Path: "fn", Digest: digestOf(manifest)}
+	files, err := fetcher.Fetch(context.Background(), remote)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if files["index.ts"] != testSourceFiles["fn/index.ts"] || files["extra.ts"] != string(file) {
+		t.Errorf("Fetch() = %v, want the archive layer and the titled file", files)
+	}
+
+	unknown := &types.RemoteSource{OCI: "ghcr.io/acme/fn", Digest: digestOf([]byte("unknown"))}
+	if _, err := fetcher.Fetch(context.Background(), unknown); err == nil {
+		t.Error("Fetch() accepted an unknown manifest digest")
+	}
+}
+
+func TestFetchGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	for name, content := range testSourceFiles {
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = repo
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "--quiet", "--initial-branch", "main")
+	git("add", ".")
+	git("commit", "--quiet", "-m", "function")
+	commit := git("rev-parse", "HEAD")
+
+	fetcher := newTestFetcher(t, Config{})
+	fetcher.allowFileGit = true
+	remote := &types.RemoteSource{Git: "file://" + repo, Ref: "main", Path: "fn", Digest: commit}
+	files, err := fetcher.Fetch(context.Background(), remote)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if len(files) != 4 || files["index.ts"] != testSourceFiles["fn/index.ts"] {
+		t.Errorf("Fetch() = %v, want the files under fn", files)
+	}
+
+	missing := &types.RemoteSource{Git: "file://" + repo, Ref: "main", Digest: strings.Repeat("0", 40)}
+	fetcher = newTestFetcher(t, Config{})
+	fetcher.allowFileGit = true
+	if _, err := fetcher.Fetch(context.Background(), missing); err == nil {
+		t.Error("Fetch() accepted a commit missing from the repository")
+	}
+
+	// Refs that git would read as options or that are not valid ref names are rejected
+	marker := filepath.Join(t.TempDir(), "marker")
+	for _, ref := range []string{"--upload-pack=touch " + marker, "main..other", "main:refs/heads/other"} {
+		invalid := &types.RemoteSource{Git: "file://" + repo, Ref: ref, Digest: strings.Repeat("0", 40)}
+		if _, err := fetcher.fetchGit(context.Background(), invalid); err == nil || !strings.Contains(err.Error(), "invalid ref") {
+			t.Errorf("fetchGit() with ref %q error = %v, want an invalid ref", ref, err)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("fetchGit() ran the command of a ref")
+	}
+
+	// Without the test switch, file:// URLs are rejected like every local transport
+	local := &types.RemoteSource{Git: "file://" + repo, Ref: "main", Digest: commit}
+	if _, err := newTestFetcher(t, Config{}).fetchGit(context.Background(), local); err == nil {
+		t.Error("fetchGit() accepted a file:// URL")
+	}
+
+	// A server only serving the advertised commits (protocol v0) gives the pinned commit
+	// through the history of its ref, deepened until the commit is present
+	home := t.TempDir()
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[protocol]\n\tversion = 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", home)
+	for i := 0; i < gitRefDepth+4; i++ {
+		git("commit", "--quiet", "--allow-empty", "-m", fmt.Sprintf("change %d", i))
+	}
+	files, err = fetcher.fetchGit(context.Background(), remote)
+	if err != nil {
+		t.Fatalf("fetchGit() of a commit behind its ref error = %v", err)
+	}
+	if files["fn/index.ts"] != testSourceFiles["fn/index.ts"] {
+		t.Errorf("fetchGit() = %v, want the files of the pinned commit", files)
+	}
+	if _, err := fetcher.fetchGit(context.Background(), missing); err == nil || !strings.Contains(err.Error(), "not in the history") {
+		t.Errorf("fetchGit() of a missing commit error = %v, want a commit not in the history", err)
+	}
+}
+
+func TestCheckGitRemote(t *testing.T) {
+	fetcher := newTestFetcher(t, Config{})
+	for _, repository := range []string{
+		"https://github.com/acme/fn.git",
+		"ssh://git@github.com/acme/fn.git",
+		"git://example.com/acme/fn.git",
+		"git@github.com:acme/fn.git",
+	} {
+		if err := fetcher.checkGitRemote(repository); err != nil {
+			t.Errorf("checkGitRemote(%q) error = %v", repository, err)
+		}
+	}
+	for _, repository := range []string{
+		"--upload-pack=touch /tmp/marker",
+		"-oProxyCommand=touch /tmp/marker@host:fn",
+		"ext::sh -c touch% /tmp/marker",
+		"fd::17",
+		"file:///srv/fn.git",
+		"/srv/fn.git",
+		"../fn",
+		"http://example.com/acme/fn.git",
+		"ssh://-oProxyCommand=touch/fn",
+		"git@-oProxyCommand=touch:fn",
+	} {
+		if err := fetcher.checkGitRemote(repository); err == nil {
+			t.Errorf("checkGitRemote(%q) accepted the repository", repository)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	archive := tarGz(t, testSourceFiles)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	input := &types.XFuncJSInput{}
+	input.Spec.Source.Remote = &types.RemoteSource{URL: server.URL, Path: "fn", Digest: digestOf(archive)}
+	if err := input.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	if err := newTestFetcher(t, Config{}).Resolve(context.Background(), input); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+
+	source := input.Spec.Source
+	if source.Remote != nil || source.Entrypoint != "index.ts" || len(source.Files) != 2 {
+		t.Errorf("source = %+v, want the files with index.ts as entrypoint", source)
+	}
+	if source.YarnLock != "# yarn lockfile" || source.Dependencies["lodash"] != "^4.17.21" {
+		t.Errorf("source = %+v, want the lockfile and the dependencies of package.json", source)
+	}
+}
+
+func TestRemoteSourceValidate(t *testing.T) {
+	digest := digestOf([]byte("content"))
+	invalid := []*types.RemoteSource{
+		{Digest: digest},
+		{OCI: "ghcr.io/acme/fn", URL: "https://example.com/fn.tgz", Digest: digest},
+		{OCI: "ghcr.io/acme/fn"},
+		{OCI: "ghcr.io/acme/fn", Digest: "sha256:abc"},
+		{URL: "ftp://example.com/fn.tgz", Digest: digest},
+		{Git: "https://github.com/acme/fn.git", Digest: digest},
+		{OCI: "ghcr.io/acme/fn", Ref: "main", Digest: digest},
+		{OCI: "ghcr.io/acme/fn", Path: "../fn", Digest: digest},
+		{Git: "--upload-pack=touch /tmp/marker", Digest: strings.Repeat("0", 40)},
+		{Git: "https://github.com/acme/fn.git", Ref: "--upload-pack=touch /tmp/marker", Digest: strings.Repeat("0", 40)},
+	}
+	for _, remote := range invalid {
+		if err := remote.Validate(); err == nil {
+			t.Errorf("Validate() accepted %+v", remote)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// History fetched through a ref: the first fetch gets gitRefDepth commits, each next one
+// doubles the depth up to gitMaxDepth
+const (
+	gitRefDepth = 16
+	gitMaxDepth = 1024
+)
+
+// scpLikeRemote matches the scp-like ssh addresses, e.g. git@github.com:acme/fn.git
+var scpLikeRemote = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9][A-Za-z0-9.-]*:[^:]`)
+
+// fetchGit checks out a commit of a git repository with the git CLI. The commit is
+// fetched directly, or through the history of its ref when the server does not allow it.
+func (f *Fetcher) fetchGit(ctx context.Context, remote *types.RemoteSource) (map[string]string, error) {
+	dir, err := os.MkdirTemp("", "xfuncjs-git-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create git directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := f.checkGitRemote(remote.Git); err != nil {
+		return nil, err
+	}
+	env, err := f.gitEnv(remote.Git)
+	if err != nil {
+		return nil, err
+	}
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", args...)
+		cmd.Dir = dir
+		cmd.Env = env
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	if remote.Ref != "" {
+		// The ref must not be read as an option, and must be a valid ref name
+		if strings.HasPrefix(remote.Ref, "-") {
+			return nil, fmt.Errorf("remote source %s: invalid ref %q", remote.Git, remote.Ref)
+		}
+		if _, err := git("check-ref-format", "--allow-onelevel", remote.Ref); err != nil {
+			return nil, fmt.Errorf("remote source %s: invalid ref %q", remote.Git, remote.Ref)
+		}
+	}
+	if _, err := git("init", "--quiet"); err != nil {
+		return nil, err
+	}
+	// The repository and the refs follow "--" so that they are never read as options
+	if _, err := git("fetch", "--quiet", "--depth", "1", "--", remote.Git, remote.Digest); err != nil {
+		if remote.Ref == "" {
+			return nil, fmt.Errorf("remote source %s: %w", remote.Git, err)
+		}
+		if err := fetchGitRef(git, remote); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := git("checkout", "--quiet", "--detach", remote.Digest); err != nil {
+		return nil, fmt.Errorf("remote source %s: commit %s not found: %w", remote.Git, remote.Digest, err)
+	}
+	head, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if head != remote.Digest {
+		return nil, fmt.Errorf("remote source %s: digest mismatch: expected %s, got %s", remote.Git, remote.Digest, head)
+	}
+
+	files := make(map[string]string)
+	var size int64
+	err = filepath.WalkDir(dir, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return fmt.Errorf("unsupported file %s: only regular files are allowed", p)
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if size += int64(len(content)); size > f.config.MaxSize {
+			return fmt.Errorf("content exceeds %d bytes", f.config.MaxSize)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("remote source %s: %w", remote.Git, err)
+	}
+	return files, nil
+}
+
+// fetchGitRef fetches the recent history of the ref of remote, deepening it step by step
+// until the pinned commit is present, at most gitMaxDepth commits
+func fetchGitRef(git func(args ...string) (string, error), remote *types.RemoteSource) error {
+	depth := gitRefDepth
+	if _, err := git("fetch", "--quiet", "--depth", strconv.Itoa(depth), "--", remote.Git, remote.Ref); err != nil {
+		return fmt.Errorf("remote source %s: %w", remote.Git, err)
+	}
+	for {
+		if _, err := git("cat-file", "-e", remote.Digest+"^{commit}"); err == nil {
+			return nil
+		}
+		// The whole history of the ref was fetched
+		if shallow, err := git("rev-parse", "--is-shallow-repository"); err != nil || shallow != "true" {
+			return fmt.Errorf("remote source %s: commit %s is not in the history of %s", remote.Git, remote.Digest, remote.Ref)
+		}
+		if depth >= gitMaxDepth {
+			return fmt.Errorf("remote source %s: commit %s is not in the last %d commits of %s", remote.Git, remote.Digest, depth, remote.Ref)
+		}
+		if _, err := git("fetch", "--quiet", "--deepen", strconv.Itoa(depth), "--", remote.Git, remote.Ref); err != nil {
+			return fmt.Errorf("remote source %s: %w", remote.Git, err)
+		}
+		depth *= 2
+	}
+}
+
+// checkGitRemote checks that repository is an https, ssh or git URL, or an scp-like ssh
+// address. Other transports, such as ext:: which runs a command or local paths, are
+// rejected. file:// URLs are only allowed in tests.
+func (f *Fetcher) checkGitRemote(repository string) error {
+	if strings.HasPrefix(repository, "-") {
+		return fmt.Errorf("remote source %s: invalid git repository", repository)
+	}
+	if scpLikeRemote.MatchString(repository) {
+		return nil
+	}
+	u, err := url.Parse(repository)
+	if err != nil {
+		return fmt.Errorf("remote source %s: invalid git repository: %w", repository, err)
+	}
+	switch {
+	case u.Scheme == "file" && f.allowFileGit:
+		return nil
+	case (u.Scheme == "https" || u.Scheme == "ssh" || u.Scheme == "git") && u.Host != "" && !strings.HasPrefix(u.Host, "-"):
+		return nil
+	}
+	return fmt.Errorf("remote source %s: git repository must be an https, ssh or git URL", repository)
+}
+
+// gitEnv returns the environment of the git commands. The proxy and the credentials of
+// the repository host are set through GIT_CONFIG_* variables, which unlike command line
+// options are not visible to other processes.
+func (f *Fetcher) gitEnv(repository string) ([]string, error) {
+	config := map[string]string{}
+	if f.config.Proxy != "" {
+		config["http.proxy"] = f.config.Proxy
+	}
+	if host := hostOf(repository); host != "" {
+		creds, err := f.credentials(host)
+		if err != nil {
+			return nil, err
+		}
+		if creds != nil {
+			config["http.extraHeader"] = "Authorization: Basic " + basicAuth(creds)
+		}
+	}
+
+	// Git only uses the allowed transports, for the repository as well as for redirects
+	protocols := "https:ssh:git"
+	if f.allowFileGit {
+		protocols += ":file"
+	}
+
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_PROTOCOL_FROM_USER=0",
+		"GIT_ALLOW_PROTOCOL="+protocols,
+		fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(config)),
+	)
+	i := 0
+	for key, value := range config {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, key), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, value))
+		i++
+	}
+	return env, nil
+}
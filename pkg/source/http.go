@@ -0,0 +1,231 @@
+package source
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// credentials are the username and password of a host
+type credentials struct {
+	username string
+	password string
+}
+
+// credentials returns the credentials of host from the credentials file, or nil
+func (f *Fetcher) credentials(host string) (*credentials, error) {
+	if f.config.CredentialsFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(f.config.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote source credentials: %w", err)
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse remote source credentials: %w", err)
+	}
+
+	for key, auth := range config.Auths {
+		// Docker writes some hosts as URLs, e.g. https://index.docker.io/v1/
+		if key != host && hostOf(key) != host {
+			continue
+		}
+		if auth.Auth == "" {
+			return &credentials{username: auth.Username, password: auth.Password}, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote source credentials for %s: %w", host, err)
+		}
+		username, password, _ := strings.Cut(string(decoded), ":")
+		return &credentials{username: username, password: password}, nil
+	}
+	return nil, nil
+}
+
+// hostOf returns the host of a URL, or an empty string
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// get sends a GET request and returns the response body, bounded by the maximum source
+// size. Credentials of the host are sent when the server asks for them.
+func (f *Fetcher) get(ctx context.Context, rawURL string, header http.Header) ([]byte, error) {
+	resp, err := f.do(ctx, rawURL, header, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := f.authorize(ctx, resp)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp, err = f.do(ctx, rawURL, header, authorization); err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: %s", rawURL, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.config.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	if int64(len(body)) > f.config.MaxSize {
+		return nil, fmt.Errorf("failed to fetch %s: larger than %d bytes", rawURL, f.config.MaxSize)
+	}
+	return body, nil
+}
+
+// do sends a GET request with an optional Authorization header
+func (f *Fetcher) do(ctx context.Context, rawURL string, header http.Header, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote source URL %s: %w", rawURL, err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	return resp, nil
+}
+
+// authorize answers the authentication challenge of a 401 response: basic credentials,
+// or a bearer token requested from the token service of an OCI registry
+func (f *Fetcher) authorize(ctx context.Context, challenged *http.Response) (string, error) {
+	creds, err := f.credentials(challenged.Request.URL.Host)
+	if err != nil {
+		return "", err
+	}
+
+	scheme, params := parseChallenge(challenged.Header.Get("WWW-Authenticate"))
+	if !strings.EqualFold(scheme, "bearer") {
+		if creds == nil {
+			return "", fmt.Errorf("failed to fetch %s: authentication required and no credentials for %s", challenged.Request.URL, challenged.Request.URL.Host)
+		}
+		return "Basic " + basicAuth(creds), nil
+	}
+
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm in the challenge of %s", challenged.Request.URL)
+	}
+	query := tokenURL.Query()
+	for _, key := range []string{"service", "scope"} {
+		if params[key] != "" {
+			query.Set(key, params[key])
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	// Anonymous tokens are requested without credentials
+	authorization := ""
+	if tokenCreds, err := f.credentials(tokenURL.Host); err != nil {
+		return "", err
+	} else if tokenCreds != nil {
+		authorization = "Basic " + basicAuth(tokenCreds)
+	} else if creds != nil {
+		authorization = "Basic " + basicAuth(creds)
+	}
+
+	resp, err := f.do(ctx, tokenURL.String(), nil, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get a registry token from %s: %s", tokenURL.Host, resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to parse the registry token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	return "Bearer " + token.Token, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header: Bearer realm="...",service="..."
+func parseChallenge(header string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, ", "), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return scheme, params
+}
+
+// basicAuth encodes credentials for a Basic Authorization header
+func basicAuth(creds *credentials) string {
+	return base64.StdEncoding.EncodeToString([]byte(creds.username + ":" + creds.password))
+}
+
+// verifyDigest checks that content matches a sha256:<hex> digest
+func verifyDigest(content []byte, digest string) error {
+	sum := sha256.Sum256(content)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return nil
+}
+
+// fetchHTTP downloads and extracts a tarball
+func (f *Fetcher) fetchHTTP(ctx context.Context, remote *types.RemoteSource) (map[string]string, error) {
+	archive, err := f.get(ctx, remote.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyDigest(archive, remote.Digest); err != nil {
+		return nil, fmt.Errorf("remote source %s: %w", remote.URL, err)
+	}
+
+	files := make(map[string]string)
+	if err := extractArchive(archive, files, f.config.MaxSize); err != nil {
+		return nil, fmt.Errorf("remote source %s: %w", remote.URL, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,125 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/socialgouv/xfuncjs-server/pkg/types"
+)
+
+// manifestMediaTypes are the manifest formats accepted from registries
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// titleAnnotation names the file held by a layer, as pushed by oras
+const titleAnnotation = "org.opencontainers.image.title"
+
+// ociManifest is the part of an image manifest listing the layers
+type ociManifest struct {
+	Layers []struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// parseReference splits an OCI reference into its registry and repository. A tag or a
+// digest in the reference is ignored: the content is pinned by the digest of the source.
+func parseReference(reference string) (string, string, error) {
+	name, _, _ := strings.Cut(reference, "@")
+	if slash, colon := strings.LastIndex(name, "/"), strings.LastIndex(name, ":"); colon > slash {
+		name = name[:colon]
+	}
+
+	registry, repository, found := strings.Cut(name, "/")
+	if !found || !strings.ContainsAny(registry, ".:") && registry != "localhost" {
+		// Docker Hub references, e.g. acme/function or function
+		registry, repository = "docker.io", name
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+	if repository == "" {
+		return "", "", fmt.Errorf("invalid OCI reference %s", reference)
+	}
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	return registry, repository, nil
+}
+
+// registryURL returns the base URL of a registry, or of the configured mirror
+func (f *Fetcher) registryURL(registry string) string {
+	if mirror := f.config.RegistryMirror; mirror != "" {
+		registry = mirror
+	}
+	if strings.HasPrefix(registry, "http://") || strings.HasPrefix(registry, "https://") {
+		return strings.TrimSuffix(registry, "/")
+	}
+	return "https://" + registry
+}
+
+// fetchOCI downloads the manifest of an OCI artifact by digest and extracts its layers.
+// Archive layers are extracted; other layers are files named by their title annotation.
+func (f *Fetcher) fetchOCI(ctx context.Context, remote *types.RemoteSource) (map[string]string, error) {
+	registry, repository, err := parseReference(remote.OCI)
+	if err != nil {
+		return nil, err
+	}
+	if _, digest, found := strings.Cut(remote.OCI, "@"); found && digest != remote.Digest {
+		return nil, fmt.Errorf("remote source %s: the reference digest does not match %s", remote.OCI, remote.Digest)
+	}
+	base := fmt.Sprintf("%s/v2/%s", f.registryURL(registry), repository)
+
+	header := http.Header{"Accept": {strings.Join(manifestMediaTypes, ", ")}}
+	content, err := f.get(ctx, base+"/manifests/"+remote.Digest, header)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyDigest(content, remote.Digest); err != nil {
+		return nil, fmt.Errorf("remote source %s: manifest %w", remote.OCI, err)
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(content, &manifest); err != nil {
+		return nil, fmt.Errorf("remote source %s: failed to parse manifest: %w", remote.OCI, err)
+	}
+	if len(manifest.Layers) == 0 {
+		return nil, fmt.Errorf("remote source %s: the artifact has no layer", remote.OCI)
+	}
+
+	files := make(map[string]string)
+	for _, layer := range manifest.Layers {
+		blob, err := f.get(ctx, base+"/blobs/"+layer.Digest, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyDigest(blob, layer.Digest); err != nil {
+			return nil, fmt.Errorf("remote source %s: layer %w", remote.OCI, err)
+		}
+
+		if strings.Contains(layer.MediaType, ".tar") {
+			if err := extractArchive(blob, files, f.config.MaxSize); err != nil {
+				return nil, fmt.Errorf("remote source %s: %w", remote.OCI, err)
+			}
+			continue
+		}
+		title := layer.Annotations[titleAnnotation]
+		if title == "" {
+			return nil, fmt.Errorf("remote source %s: layer %s is neither an archive nor a titled file", remote.OCI, layer.Digest)
+		}
+		name, err := archivePath(title)
+		if err != nil {
+			return nil, fmt.Errorf("remote source %s: %w", remote.OCI, err)
+		}
+		if totalSize(files)+int64(len(blob)) > f.config.MaxSize {
+			return nil, fmt.Errorf("remote source %s: content exceeds %d bytes", remote.OCI, f.config.MaxSize)
+		}
+		files[name] = string(blob)
+	}
+	return files, nil
+}
@@ -2,10 +2,17 @@ package types
 
 import (
 	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // XFuncJSInput represents the input for the XFuncJS function
@@ -14,19 +21,141 @@ type XFuncJSInput struct {
 	Kind       string `json:"kind"`
 	Spec       struct {
 		Source struct {
-			Inline       string            `json:"inline,omitempty"`
+			Inline string `json:"inline,omitempty"`
+			// Files maps relative paths to the content of additional source files,
+			// imported from the entrypoint with relative imports
+			Files map[string]string `json:"files,omitempty"`
+			// Entrypoint is the path, among files, of the module exporting the function.
+			// It is required when inline is empty.
+			Entrypoint   string            `json:"entrypoint,omitempty"`
 			Dependencies map[string]string `json:"dependencies,omitempty"`
 			YarnLock     string            `json:"yarnLock,omitempty"`
 			TsConfig     string            `json:"tsConfig,omitempty"`
+			// PackageManager installs the dependencies: yarn (default), npm or pnpm
+			PackageManager string `json:"packageManager,omitempty"`
+			// PackageLock is the package-lock.json used by npm
+			PackageLock string `json:"packageLock,omitempty"`
+			// PnpmLock is the pnpm-lock.yaml used by pnpm
+			PnpmLock string `json:"pnpmLock,omitempty"`
+			// ConfigMapRef loads the code and lockfile from a ConfigMap, fetched by
+			// Crossplane as a required resource
+			ConfigMapRef *SourceRef `json:"configMapRef,omitempty"`
+			// SecretRef loads the code and lockfile from a Secret, fetched by Crossplane
+			// as a required resource
+			SecretRef *SourceRef `json:"secretRef,omitempty"`
+			// Remote loads the source published outside the Composition, pinned by digest
+			Remote *RemoteSource `json:"remote,omitempty"`
+			// Encoding of inline, files, tsConfig and the lockfile: identity (default),
+			// base64, gzip+base64 or zstd+base64
+			Encoding string `json:"encoding,omitempty"`
+			// Signature is a base64 detached ed25519 or ECDSA signature of the source
+			// bundle, verified against the trusted keys of the server
+			Signature string `json:"signature,omitempty"`
 		} `json:"source"`
 		Params map[string]interface{} `json:"params,omitempty"`
 		Target string                 `json:"target,omitempty"`
+		// LogLevel overrides the server-wide log level of the Node.js process. It is applied
+		// to the running process and is not part of the function identity.
+		LogLevel string `json:"logLevel,omitempty"`
+		// Merge is the default merge strategy of the resources returned by the function
+		Merge *MergeStrategy `json:"merge,omitempty"`
+		// ConnectionSecret configures the Secret generated for the connection details of
+		// a namespaced composite resource
+		ConnectionSecret *ConnectionSecret `json:"connectionSecret,omitempty"`
+		// AutoReady derives the readiness of the composed resources the function leaves
+		// unset from their observed state, enabled when not set
+		AutoReady *bool `json:"autoReady,omitempty"`
 	} `json:"spec"`
 
 	// TypeMeta is required for runtime.Object implementation
 	metav1.TypeMeta `json:",inline"`
 }
 
+// SourceRef references the ConfigMap or Secret holding the function source
+type SourceRef struct {
+	Name string `json:"name"`
+	// Namespace of the object, the namespace of the composite resource when empty
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ConnectionSecret configures the Secret holding the connection details of a namespaced
+// composite resource, which Crossplane v2 no longer writes itself
+type ConnectionSecret struct {
+	// Name of the Secret, <composite name>-connection when empty
+	Name string `json:"name,omitempty"`
+	// Disabled stops generating the Secret
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// Validate checks that the name is a valid Secret name
+func (c *ConnectionSecret) Validate() error {
+	if c.Name == "" {
+		return nil
+	}
+	if errs := validation.IsDNS1123Subdomain(c.Name); len(errs) > 0 {
+		return fmt.Errorf("connectionSecret.name %q is invalid: %s", c.Name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// RemoteSource references function code published by a separate pipeline: an OCI
+// artifact, an HTTP(S) tarball or a git repository, always pinned by digest
+type RemoteSource struct {
+	// OCI is an OCI artifact reference, e.g. ghcr.io/acme/functions/bucket:v1
+	OCI string `json:"oci,omitempty"`
+	// URL is an HTTP(S) URL of a tar or tar.gz archive
+	URL string `json:"url,omitempty"`
+	// Git is the URL of a git repository
+	Git string `json:"git,omitempty"`
+	// Ref is the git branch or tag holding the commit, fetched when the server does not
+	// allow fetching the commit directly
+	Ref string `json:"ref,omitempty"`
+	// Path is the directory of the source within the artifact, archive or repository
+	Path string `json:"path,omitempty"`
+	// Digest pins the content: the digest of the OCI manifest or of the archive
+	// (sha256:<hex>), or the git commit hash
+	Digest string `json:"digest"`
+}
+
+// sha256Digest matches the digests of OCI manifests and archives
+var sha256Digest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// gitCommit matches full SHA-1 and SHA-256 git commit hashes
+var gitCommit = regexp.MustCompile(`^([0-9a-f]{40}|[0-9a-f]{64})$`)
+
+// Validate checks that exactly one location is set and that the content is pinned
+func (r *RemoteSource) Validate() error {
+	locations := 0
+	for _, location := range []string{r.OCI, r.URL, r.Git} {
+		if location != "" {
+			locations++
+		}
+	}
+	switch {
+	case locations != 1:
+		return errors.New("source.remote requires exactly one of oci, url or git")
+	case r.Ref != "" && r.Git == "":
+		return errors.New("source.remote.ref can only be set with git")
+	case strings.HasPrefix(r.Git, "-") || strings.HasPrefix(r.Ref, "-"):
+		return errors.New("source.remote.git and source.remote.ref must not start with -")
+	case r.Git != "" && !gitCommit.MatchString(r.Digest):
+		return errors.New("source.remote.digest must be the full git commit hash")
+	case r.Git == "" && !sha256Digest.MatchString(r.Digest):
+		return errors.New("source.remote.digest must be a sha256:<hex> digest")
+	}
+	if r.URL != "" {
+		if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.New("source.remote.url must be an http(s) URL")
+		}
+	}
+	if r.Path != "" {
+		if err := ValidateSourcePath(r.Path); err != nil {
+			return fmt.Errorf("source.remote.path: %w", err)
+		}
+	}
+	return nil
+}
+
 // GetObjectKind implements the runtime.Object interface
 func (i *XFuncJSInput) GetObjectKind() schema.ObjectKind {
 	return &i.TypeMeta
@@ -42,9 +171,40 @@ func (i *XFuncJSInput) DeepCopyObject() runtime.Object {
 		Kind:       i.Kind,
 	}
 	copy.Spec.Source.Inline = i.Spec.Source.Inline
+	copy.Spec.Source.Entrypoint = i.Spec.Source.Entrypoint
 	copy.Spec.Source.YarnLock = i.Spec.Source.YarnLock
 	copy.Spec.Source.TsConfig = i.Spec.Source.TsConfig
+	copy.Spec.Source.PackageManager = i.Spec.Source.PackageManager
+	copy.Spec.Source.PackageLock = i.Spec.Source.PackageLock
+	copy.Spec.Source.PnpmLock = i.Spec.Source.PnpmLock
+	copy.Spec.Source.Encoding = i.Spec.Source.Encoding
+	copy.Spec.Source.Signature = i.Spec.Source.Signature
+	if i.Spec.Source.ConfigMapRef != nil {
+		ref := *i.Spec.Source.ConfigMapRef
+		copy.Spec.Source.ConfigMapRef = &ref
+	}
+	if i.Spec.Source.SecretRef != nil {
+		ref := *i.Spec.Source.SecretRef
+		copy.Spec.Source.SecretRef = &ref
+	}
+	if i.Spec.Source.Remote != nil {
+		remote := *i.Spec.Source.Remote
+		copy.Spec.Source.Remote = &remote
+	}
 	copy.Spec.Target = i.Spec.Target
+	copy.Spec.LogLevel = i.Spec.LogLevel
+	if i.Spec.Merge != nil {
+		merge := *i.Spec.Merge
+		copy.Spec.Merge = &merge
+	}
+	if i.Spec.ConnectionSecret != nil {
+		connectionSecret := *i.Spec.ConnectionSecret
+		copy.Spec.ConnectionSecret = &connectionSecret
+	}
+	if i.Spec.AutoReady != nil {
+		autoReady := *i.Spec.AutoReady
+		copy.Spec.AutoReady = &autoReady
+	}
 
 	// Copy dependencies
 	if i.Spec.Source.Dependencies != nil {
@@ -54,6 +214,14 @@ func (i *XFuncJSInput) DeepCopyObject() runtime.Object {
 		}
 	}
 
+	// Copy source files
+	if i.Spec.Source.Files != nil {
+		copy.Spec.Source.Files = make(map[string]string, len(i.Spec.Source.Files))
+		for k, v := range i.Spec.Source.Files {
+			copy.Spec.Source.Files[k] = v
+		}
+	}
+
 	// Copy params
 	if i.Spec.Params != nil {
 		copy.Spec.Params = make(map[string]interface{}, len(i.Spec.Params))
@@ -67,8 +235,235 @@ func (i *XFuncJSInput) DeepCopyObject() runtime.Object {
 
 // Validate validates the input
 func (i *XFuncJSInput) Validate() error {
-	if i.Spec.Source.Inline == "" {
-		return errors.New("source.inline is required")
+	if err := i.validateSource(); err != nil {
+		return err
+	}
+	if i.Spec.LogLevel != "" && !IsValidNodeLogLevel(i.Spec.LogLevel) {
+		return fmt.Errorf("logLevel must be one of: %s", strings.Join(NodeLogLevels, ", "))
+	}
+
+	if i.Spec.Merge != nil {
+		if err := i.Spec.Merge.Validate(); err != nil {
+			return err
+		}
+	}
+	if i.Spec.ConnectionSecret != nil {
+		if err := i.Spec.ConnectionSecret.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if i.Spec.Source.Encoding != "" && !slices.Contains(SourceEncodings, i.Spec.Source.Encoding) {
+		return fmt.Errorf("source.encoding must be one of: %s", strings.Join(SourceEncodings, ", "))
+	}
+
+	packageManager := i.GetPackageManager()
+	if !slices.Contains(PackageManagers, packageManager) {
+		return fmt.Errorf("source.packageManager must be one of: %s", strings.Join(PackageManagers, ", "))
+	}
+	// A lockfile is only read by its own package manager
+	for _, lockfile := range []struct{ field, manager string }{
+		{"yarnLock", PackageManagerYarn},
+		{"packageLock", PackageManagerNpm},
+		{"pnpmLock", PackageManagerPnpm},
+	} {
+		if lockfile.manager != packageManager && i.lockfileField(lockfile.manager) != "" {
+			return fmt.Errorf("source.%s requires source.packageManager %s, got %s", lockfile.field, lockfile.manager, packageManager)
+		}
+	}
+	return nil
+}
+
+// validateSource checks that the source has an entrypoint and that every file path stays
+// within the function workspace
+func (i *XFuncJSInput) validateSource() error {
+	source := i.Spec.Source
+	if source.Remote != nil {
+		if source.Inline != "" || len(source.Files) > 0 || source.ConfigMapRef != nil || source.SecretRef != nil {
+			return errors.New("source.remote cannot be combined with inline, files, configMapRef or secretRef")
+		}
+		if source.Encoding != "" {
+			return errors.New("source.encoding cannot be set with source.remote, whose files are not encoded")
+		}
+		// The files are not known until the source is fetched
+		return source.Remote.Validate()
+	}
+	if kind, ref := i.SourceObject(); ref != nil {
+		switch {
+		case source.ConfigMapRef != nil && source.SecretRef != nil:
+			return errors.New("source.configMapRef and source.secretRef cannot be used together")
+		case ref.Name == "":
+			return fmt.Errorf("source.%sRef.name is required", strings.ToLower(kind[:1])+kind[1:])
+		case source.Inline != "" || i.Lockfile() != "":
+			return fmt.Errorf("source.inline and the lockfile are read from the %s and cannot be set with it", kind)
+		}
+		// The code is not known until Crossplane supplies the object
+		return nil
+	}
+
+	switch {
+	case source.Inline == "" && len(source.Files) == 0:
+		return errors.New("source.inline or source.files is required")
+	case source.Inline != "" && source.Entrypoint != "":
+		return errors.New("source.entrypoint cannot be set with source.inline, which is the entrypoint")
+	case source.Inline == "" && source.Entrypoint == "":
+		return errors.New("source.entrypoint is required with source.files")
+	}
+
+	for path := range source.Files {
+		if err := ValidateSourcePath(path); err != nil {
+			return fmt.Errorf("source.files: %w", err)
+		}
+	}
+	if source.Entrypoint != "" {
+		if _, ok := source.Files[source.Entrypoint]; !ok {
+			return fmt.Errorf("source.entrypoint %q is not in source.files", source.Entrypoint)
+		}
+	}
+	return nil
+}
+
+// SourceObject returns the kind (ConfigMap or Secret) and the reference of the object
+// holding the function source, or a nil reference when the source is in the spec
+func (i *XFuncJSInput) SourceObject() (string, *SourceRef) {
+	switch {
+	case i.Spec.Source.ConfigMapRef != nil:
+		return "ConfigMap", i.Spec.Source.ConfigMapRef
+	case i.Spec.Source.SecretRef != nil:
+		return "Secret", i.Spec.Source.SecretRef
+	}
+	return "", nil
+}
+
+// EntrypointSource returns the content of the module exporting the function
+func (i *XFuncJSInput) EntrypointSource() string {
+	if i.Spec.Source.Inline != "" {
+		return i.Spec.Source.Inline
+	}
+	return i.Spec.Source.Files[i.Spec.Source.Entrypoint]
+}
+
+// reservedSourcePaths are workspace files and directories written by the server or the
+// package manager, which source files must not overwrite
+var reservedSourcePaths = []string{
+	"package.json", "tsconfig.json", "yarn.lock", "package-lock.json", "pnpm-lock.yaml",
+	".yarnrc.yml", ".npmrc", ".yarn", ".pnp.cjs", ".pnp.loader.mjs", "node_modules",
+}
+
+// ValidateSourcePath checks that a source file path is a clean relative path ("lib/util.ts")
+// that stays within the workspace and does not overwrite a reserved file
+func ValidateSourcePath(p string) error {
+	switch {
+	case p == "":
+		return errors.New("empty file path")
+	case strings.ContainsAny(p, "\\\x00"):
+		return fmt.Errorf("invalid file path %q: backslashes and NUL are not allowed", p)
+	case path.IsAbs(p):
+		return fmt.Errorf("invalid file path %q: must be relative", p)
+	case path.Clean(p) != p:
+		return fmt.Errorf("invalid file path %q: must be a clean path, e.g. %q", p, path.Clean(p))
+	case p == "." || p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("invalid file path %q: must stay within the function workspace", p)
+	}
+
+	first, _, _ := strings.Cut(p, "/")
+	if slices.Contains(reservedSourcePaths, first) {
+		return fmt.Errorf("invalid file path %q: %s is reserved", p, first)
 	}
 	return nil
 }
+
+// Encodings of the source fields
+const (
+	SourceEncodingIdentity   = "identity"
+	SourceEncodingBase64     = "base64"
+	SourceEncodingGzipBase64 = "gzip+base64"
+	SourceEncodingZstdBase64 = "zstd+base64"
+)
+
+// SourceEncodings lists the values accepted by source.encoding
+var SourceEncodings = []string{SourceEncodingIdentity, SourceEncodingBase64, SourceEncodingGzipBase64, SourceEncodingZstdBase64}
+
+// Package managers that can install the function dependencies
+const (
+	PackageManagerYarn = "yarn"
+	PackageManagerNpm  = "npm"
+	PackageManagerPnpm = "pnpm"
+)
+
+// PackageManagers lists the values accepted by source.packageManager
+var PackageManagers = []string{PackageManagerYarn, PackageManagerNpm, PackageManagerPnpm}
+
+// GetPackageManager returns the package manager installing the dependencies, yarn by default
+func (i *XFuncJSInput) GetPackageManager() string {
+	if i.Spec.Source.PackageManager == "" {
+		return PackageManagerYarn
+	}
+	return i.Spec.Source.PackageManager
+}
+
+// IsAutoReady returns whether the readiness of the composed resources is derived from
+// their observed state, true by default
+func (i *XFuncJSInput) IsAutoReady() bool {
+	return i.Spec.AutoReady == nil || *i.Spec.AutoReady
+}
+
+// Lockfile returns the lockfile of the selected package manager, empty if none is supplied
+func (i *XFuncJSInput) Lockfile() string {
+	return i.lockfileField(i.GetPackageManager())
+}
+
+// LockfileName returns the name of the lockfile read by a package manager
+func LockfileName(packageManager string) string {
+	switch packageManager {
+	case PackageManagerNpm:
+		return "package-lock.json"
+	case PackageManagerPnpm:
+		return "pnpm-lock.yaml"
+	default:
+		return "yarn.lock"
+	}
+}
+
+// lockfileField returns the lockfile field read by a package manager
+func (i *XFuncJSInput) lockfileField(packageManager string) string {
+	switch packageManager {
+	case PackageManagerNpm:
+		return i.Spec.Source.PackageLock
+	case PackageManagerPnpm:
+		return i.Spec.Source.PnpmLock
+	default:
+		return i.Spec.Source.YarnLock
+	}
+}
+
+// NodeLogLevels lists the log levels understood by the Node.js (Pino) logger
+var NodeLogLevels = []string{"trace", "debug", "info", "warn", "error", "fatal", "silent"}
+
+// IsValidNodeLogLevel reports whether level is a valid Node.js log level
+func IsValidNodeLogLevel(level string) bool {
+	for _, l := range NodeLogLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Strategies providing the shared parts of /app/.yarn (yarn release, plugins) to each workspace
+const (
+	// YarnDirReference symlinks the shared entries, so they are used in place
+	YarnDirReference = "reference"
+	// YarnDirHardlink hardlinks every shared file, copying the files that cannot be linked
+	YarnDirHardlink = "hardlink"
+	// YarnDirCopy copies every shared file
+	YarnDirCopy = "copy"
+)
+
+// YarnDirStrategies lists the valid .yarn strategies
+var YarnDirStrategies = []string{YarnDirReference, YarnDirHardlink, YarnDirCopy}
+
+// IsValidYarnDirStrategy reports whether strategy is a valid .yarn strategy
+func IsValidYarnDirStrategy(strategy string) bool {
+	return slices.Contains(YarnDirStrategies, strategy)
+}
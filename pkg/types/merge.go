@@ -0,0 +1,59 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Merge strategies of a resource returned by the function
+const (
+	// MergeStrategyReplace replaces the desired resource of earlier pipeline steps
+	MergeStrategyReplace = "replace"
+	// MergeStrategyMergePatch applies the resource as a JSON merge patch (RFC 7396):
+	// objects are merged, null removes a field and lists are replaced verbatim
+	MergeStrategyMergePatch = "mergePatch"
+	// MergeStrategyDeepMerge merges objects recursively, null removes a field and lists
+	// are combined according to the list strategy, without the null fields of their objects
+	MergeStrategyDeepMerge = "deepMerge"
+)
+
+// MergeStrategies lists the values accepted by merge.strategy
+var MergeStrategies = []string{MergeStrategyReplace, MergeStrategyMergePatch, MergeStrategyDeepMerge}
+
+// List strategies of the deepMerge strategy
+const (
+	// ListStrategyReplace replaces the list
+	ListStrategyReplace = "replace"
+	// ListStrategyAppend appends the items to the list
+	ListStrategyAppend = "append"
+	// ListStrategyMergeByName merges the objects with the same name field and appends
+	// the other items
+	ListStrategyMergeByName = "mergeByName"
+)
+
+// ListStrategies lists the values accepted by merge.lists
+var ListStrategies = []string{ListStrategyReplace, ListStrategyAppend, ListStrategyMergeByName}
+
+// MergeStrategy is how a resource returned by the function is combined with the desired
+// resource of the same name produced by earlier pipeline steps
+type MergeStrategy struct {
+	// Strategy is replace (default), mergePatch or deepMerge
+	Strategy string `json:"strategy,omitempty"`
+	// Lists is how deepMerge combines lists: replace (default), append or mergeByName
+	Lists string `json:"lists,omitempty"`
+}
+
+// Validate checks the strategy and list strategy
+func (m *MergeStrategy) Validate() error {
+	if m.Strategy != "" && !slices.Contains(MergeStrategies, m.Strategy) {
+		return fmt.Errorf("merge.strategy must be one of: %s", strings.Join(MergeStrategies, ", "))
+	}
+	if m.Lists != "" && !slices.Contains(ListStrategies, m.Lists) {
+		return fmt.Errorf("merge.lists must be one of: %s", strings.Join(ListStrategies, ", "))
+	}
+	if m.Lists != "" && m.Strategy != MergeStrategyDeepMerge {
+		return fmt.Errorf("merge.lists requires merge.strategy %s", MergeStrategyDeepMerge)
+	}
+	return nil
+}